@@ -10,6 +10,9 @@ import (
 
 const nonTransactionSeqNo uint64 = 0
 
+// 单条记录除 key/value 以外的最大开销：事务序列号 + 记录头部
+const maxLogRecordOverhead = binary.MaxVarintLen64*4 + 9
+
 var txnFixKey = []byte("txn-fix")
 
 type WriteBatch struct {
@@ -20,9 +23,6 @@ type WriteBatch struct {
 }
 
 func (db *DB) NewWriteBatch(options WriteBatchOptions) *WriteBatch {
-	if db.options.IndexType == index.BPTree && !db.seqNoFileExists && !db.isInitial {
-		panic("cannot use write batch, seq no file not exists")
-	}
 	return &WriteBatch{
 		options:      options,
 		mu:           new(sync.Mutex),
@@ -53,7 +53,9 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
-	logRecordPos := wb.db.index.Get(key)
+	wb.db.filesLock.RLock()
+	logRecordPos := wb.db.indexGet(key)
+	wb.db.filesLock.RUnlock()
 
 	if logRecordPos == nil {
 		if _, ok := wb.pendingWrite[string(key)]; ok {
@@ -81,49 +83,53 @@ func (wb *WriteBatch) Commit() error {
 		return ErrExceedMaxBatchNum
 	}
 
-	wb.db.mu.Lock()
-	defer wb.db.mu.Unlock()
-
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
-	positions := make(map[string]*data.LogRecordPos)
+	wb.db.throttleWrite()
+	pending := make([]*data.LogRecord, 0, len(wb.pendingWrite))
+	keys := make([][]byte, 0, len(wb.pendingWrite))
+	// 提前检查整个批次是否会超过配额，避免写入一半的事务
+	var batchSize int64
 	for _, record := range wb.pendingWrite {
-		pos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
+		pending = append(pending, record)
+		keys = append(keys, record.Key)
+		batchSize += int64(len(record.Key) + len(record.Value) + maxLogRecordOverhead)
+	}
+	unlock := wb.db.lockKeys(keys)
+	defer unlock()
+
+	// 整个批次的索引更新一起完成，B+ 树索引在一个事务中更新
+	_, err := wb.db.appendAndApply(func() ([]index.BatchOp, error) {
+		if err := wb.db.checkDiskQuota(batchSize); err != nil {
+			return nil, err
+		}
+		seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+		// 事务中的记录和事务完成记录合并为一次批量写入
+		logRecords := make([]*data.LogRecord, 0, len(pending)+1)
+		for _, record := range pending {
+			logRecords = append(logRecords, &data.LogRecord{
+				Key:   logRecordKeyWithSeq(record.Key, seqNo),
+				Value: record.Value,
+				Type:  record.Type,
+			})
+		}
+		logRecords = append(logRecords, &data.LogRecord{
+			Key:   logRecordKeyWithSeq(txnFixKey, seqNo),
+			Value: nil,
+			Type:  data.LogRecordFinished,
 		})
+		positions, err := wb.db.appendLogRecords(logRecords, wb.options.SyncWrites)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		positions[string(record.Key)] = pos
-	}
-	_, err := wb.db.appendLogRecord(&data.LogRecord{
-		Key:   logRecordKeyWithSeq(txnFixKey, seqNo),
-		Value: nil,
-		Type:  data.LogRecordFinished,
+		ops := make([]index.BatchOp, len(pending))
+		for i, record := range pending {
+			ops[i] = index.BatchOp{Key: record.Key, Pos: positions[i], Delete: record.Type == data.LogRecordDeleted}
+		}
+		return ops, nil
 	})
 	if err != nil {
 		return err
 	}
 
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
-			return err
-		}
-	}
-	for _, record := range wb.pendingWrite {
-		pos := positions[string(record.Key)]
-		var oldPos *data.LogRecordPos
-		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
-		} else if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
-		}
-		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
-		}
-	}
-
 	wb.pendingWrite = make(map[string]*data.LogRecord)
 	return nil
 }
@@ -1,10 +1,16 @@
 package bitcask_go
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/Tuanzi-bug/TuanKV/utils"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestDB_WriteBatch1(t *testing.T) {
@@ -109,3 +115,71 @@ func TestDB_WriteBatch2(t *testing.T) {
 //	//err = wb.Commit()
 //	//assert.Nil(t, err)
 //}
+
+func TestDB_WriteBatchAtomicVisibility(t *testing.T) {
+	for _, shards := range []int{1, 4} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-batch-atomic")
+		opts.DirPath = dir
+		opts.IndexShards = shards
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		const keyNum = 1000
+		commit := func(version int) {
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			for i := 0; i < keyNum; i++ {
+				assert.Nil(t, wb.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("%08d", version))))
+			}
+			assert.Nil(t, wb.Commit())
+		}
+		commit(0)
+
+		// 每个事务把所有的 key 更新为同一个版本，读到某个 key 的新版本之后，其他 key 不会再读到更旧的版本
+		var partial int64
+		done := make(chan struct{})
+		wg := new(sync.WaitGroup)
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					var last []byte
+					for _, i := range rand.Perm(keyNum) {
+						value, err := db.Get(utils.GetTestKey(i))
+						assert.Nil(t, err)
+						if bytes.Compare(value, last) < 0 {
+							atomic.AddInt64(&partial, 1)
+						}
+						last = value
+					}
+					// 迭代器创建时的索引同样包含完整的事务
+					iter := db.NewIterator(DefaultIteratorOptions)
+					var first []byte
+					for iter.Rewind(); iter.Valid(); iter.Next() {
+						value, err := iter.Value()
+						assert.Nil(t, err)
+						if first == nil {
+							first = value
+						} else if !bytes.Equal(first, value) {
+							atomic.AddInt64(&partial, 1)
+						}
+					}
+					iter.Close()
+				}
+			}()
+		}
+		for version, start := 1, time.Now(); time.Since(start) < time.Second; version++ {
+			commit(version)
+		}
+		close(done)
+		wg.Wait()
+		assert.Equal(t, int64(0), atomic.LoadInt64(&partial))
+		destroyDB(db)
+	}
+}
@@ -2,11 +2,13 @@ package benchmark
 
 import (
 	"errors"
+	"fmt"
 	bitcask "github.com/Tuanzi-bug/TuanKV"
 	"github.com/Tuanzi-bug/TuanKV/utils"
 	"github.com/stretchr/testify/assert"
 	"math/rand"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -62,3 +64,37 @@ func Benchmark_Delete(b *testing.B) {
 		assert.Nil(b, err)
 	}
 }
+
+// 多个写入者并发写入不同的 key，索引在 db.mu 之外按分片更新
+func Benchmark_ParallelPut(b *testing.B) {
+	for _, shards := range []int{1, 8} {
+		b.Run(fmt.Sprintf("shards-%d", shards), func(b *testing.B) {
+			options := bitcask.DefaultOptions
+			dir, _ := os.MkdirTemp("", "bitcask-go-bench-parallel")
+			defer os.RemoveAll(dir)
+			options.DirPath = dir
+			options.IndexShards = shards
+			parallelDB, err := bitcask.Open(options)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer parallelDB.Close()
+			value := utils.RandomValue(128)
+			var writer atomic.Int64
+			b.ResetTimer()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				prefix := writer.Add(1)
+				var i int
+				for pb.Next() {
+					key := []byte(fmt.Sprintf("writer-%d-key-%d", prefix, i))
+					if err := parallelDB.Put(key, value); err != nil {
+						b.Error(err)
+						return
+					}
+					i++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package benchmark
+
+import (
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/index"
+	"github.com/Tuanzi-bug/TuanKV/utils"
+	"math/rand"
+	"runtime"
+	"sync/atomic"
+	"testing"
+)
+
+// 对比各个内存索引的读写延迟和内存占用
+var benchIndexTypes = []struct {
+	name      string
+	indexType index.IndexType
+}{
+	{"btree", index.Btree},
+	{"art", index.Art},
+	{"hash", index.Hash},
+	{"skiplist", index.Skiplist},
+	{"compact", index.Compact},
+}
+
+const benchIndexKeys = 100000
+
+func Benchmark_IndexPut(b *testing.B) {
+	for _, typ := range benchIndexTypes {
+		b.Run(typ.name, func(b *testing.B) {
+			idx, _ := index.NewIndexer(typ.indexType, "", false)
+			pos := &data.LogRecordPos{Fid: 1, Offset: 100, Size: 128}
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				idx.Put(utils.GetTestKey(i), pos)
+			}
+		})
+	}
+}
+
+func Benchmark_IndexGet(b *testing.B) {
+	for _, typ := range benchIndexTypes {
+		b.Run(typ.name, func(b *testing.B) {
+			idx, keys := newBenchIndex(typ.indexType)
+			r := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				idx.Get(keys[r.Intn(len(keys))])
+			}
+		})
+	}
+}
+
+// Benchmark_IndexMemory 报告每个 key 占用的索引内存（不含 key 和位置信息本身，紧凑索引中复制的 key 和编码后的位置计算在内）
+func Benchmark_IndexMemory(b *testing.B) {
+	for _, typ := range benchIndexTypes {
+		b.Run(typ.name, func(b *testing.B) {
+			keys := make([][]byte, benchIndexKeys)
+			positions := make([]data.LogRecordPos, benchIndexKeys)
+			for i := range keys {
+				keys[i] = utils.GetTestKey(i)
+			}
+			b.ResetTimer()
+			var bytesPerKey float64
+			for n := 0; n < b.N; n++ {
+				before := heapAlloc()
+				idx, _ := index.NewIndexer(typ.indexType, "", false)
+				for i, key := range keys {
+					idx.Put(key, &positions[i])
+				}
+				bytesPerKey = float64(heapAlloc()-before) / benchIndexKeys
+				runtime.KeepAlive(idx)
+			}
+			b.ReportMetric(bytesPerKey, "bytes/key")
+		})
+	}
+}
+
+// Benchmark_IndexConcurrent 对比有序索引在多个 goroutine 并发读写时的表现，每 10 次操作中有 1 次写入
+func Benchmark_IndexConcurrent(b *testing.B) {
+	for _, typ := range benchIndexTypes {
+		if typ.indexType == index.Hash {
+			continue
+		}
+		b.Run(typ.name, func(b *testing.B) {
+			idx, keys := newBenchIndex(typ.indexType)
+			pos := &data.LogRecordPos{Fid: 2, Offset: 100, Size: 128}
+			var seed atomic.Int64
+			b.ResetTimer()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(seed.Add(1)))
+				for pb.Next() {
+					key := keys[r.Intn(len(keys))]
+					if r.Intn(10) == 0 {
+						idx.Put(key, pos)
+					} else {
+						idx.Get(key)
+					}
+				}
+			})
+		})
+	}
+}
+
+func newBenchIndex(indexType index.IndexType) (index.Indexer, [][]byte) {
+	idx, _ := index.NewIndexer(indexType, "", false)
+	keys := make([][]byte, benchIndexKeys)
+	for i := range keys {
+		keys[i] = utils.GetTestKey(i)
+		idx.Put(keys[i], &data.LogRecordPos{Fid: 1, Offset: int64(i), Size: 128})
+	}
+	return idx, keys
+}
+
+func heapAlloc() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
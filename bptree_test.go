@@ -0,0 +1,143 @@
+package bitcask_go
+
+import (
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/fio"
+	"github.com/Tuanzi-bug/TuanKV/index"
+	"github.com/Tuanzi-bug/TuanKV/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func openBPlusTreeDB(t *testing.T, name string) (*DB, Options) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bptree-"+name)
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexType = index.BPTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	return db, opts
+}
+
+// crashDB 模拟进程崩溃：不写入序列号文件和索引，直接释放文件和目录锁
+func crashDB(db *DB) {
+	close(db.closeCh)
+	db.bgWg.Wait()
+	db.closeDataFiles()
+	_ = db.index.Close()
+	_ = db.fileLock.Unlock()
+}
+
+func checkValues(t *testing.T, db *DB, expected map[string][]byte) {
+	assert.Equal(t, len(expected), len(db.ListKeys()))
+	for key, value := range expected {
+		v, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, v)
+	}
+}
+
+func TestDB_BPlusTree(t *testing.T) {
+	db, opts := openBPlusTreeDB(t, "crash")
+	expected := make(map[string][]byte)
+	putValues(t, db, expected, 0, 2000)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		delete(expected, string(utils.GetTestKey(i)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 2000; i < 2100; i++ {
+		value := utils.RandomValue(64)
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), value))
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	assert.Nil(t, wb.Commit())
+	// 记录已经写入数据文件，但是还没有更新索引时崩溃
+	unindexed := &data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("unindexed"), nonTransactionSeqNo),
+		Value: []byte("value"),
+		Type:  data.LogRecordNormal,
+	}
+	_, err := db.appendLogRecordWithLock(unindexed)
+	assert.Nil(t, err)
+	expected["unindexed"] = []byte("value")
+	crashDB(db)
+
+	// 重放 checkpoint 之后的记录，没有序列号文件时从索引中恢复序列号，可以继续使用事务
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkValues(t, db, expected)
+	assert.Equal(t, uint64(1), db.seqNo)
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Delete(utils.GetTestKey(100)))
+	delete(expected, string(utils.GetTestKey(100)))
+	assert.Nil(t, wb.Commit())
+	assert.Equal(t, uint64(2), db.seqNo)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkValues(t, db, expected)
+	destroyDB(db)
+}
+
+func TestDB_BPlusTreePowerLoss(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bptree-power-loss")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexType = index.BPTree
+	injector := fio.NewFaultInjector()
+	opts.IOManagerFactory = injector.Factory(fio.StandardFIO)
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	opts.IOManagerFactory = nil
+
+	expected := make(map[string][]byte)
+	putValues(t, db, expected, 0, 2000)
+	assert.Nil(t, db.Sync())
+	for i := 2000; i < 2100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 索引中包含了断电时丢失的数据，checkpoint 超出了数据文件，需要重建索引
+	assert.Nil(t, injector.PowerLoss())
+	db = reopenDB(t, db, opts, expected)
+	destroyDB(db)
+}
+
+func TestDB_BPlusTreeMerge(t *testing.T) {
+	db, opts := openBPlusTreeDB(t, "merge")
+	opts.DataFileMergeRatio = 0
+	assert.Nil(t, db.Close())
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	expected := make(map[string][]byte)
+	putValues(t, db, expected, 0, 2000)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		delete(expected, string(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	// merge 之后写入的数据不会被 merge 的结果覆盖
+	putValues(t, db, expected, 1500, 1600)
+	assert.Nil(t, db.Close())
+
+	for i := 0; i < 2; i++ {
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		checkValues(t, db, expected)
+		putValues(t, db, expected, 1600+i*100, 1700+i*100)
+		assert.Nil(t, db.Close())
+	}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkValues(t, db, expected)
+	destroyDB(db)
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"container/list"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"sync"
+	"sync/atomic"
+)
+
+// ValueCache is a sharded LRU cache of record values keyed by their position on disk.
+// 由于数据文件只追加写，同一个位置上的数据不会被修改，key 被覆盖或删除后旧位置的缓存只需要淘汰即可
+type ValueCache struct {
+	shards []*lruShard
+	hits   uint64
+	misses uint64
+}
+
+type cacheKey struct {
+	fid    uint32
+	offset int64
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	value []byte
+}
+
+type lruShard struct {
+	lock     *sync.Mutex
+	capacity int64 // 当前分片最多缓存的字节数
+	size     int64 // 当前分片已经缓存的字节数
+	ll       *list.List
+	items    map[cacheKey]*list.Element
+}
+
+// NewValueCache 创建一个总容量为 capacity 字节、分为 shardNum 个分片的缓存
+func NewValueCache(capacity int64, shardNum int) *ValueCache {
+	if shardNum <= 0 {
+		shardNum = 1
+	}
+	shards := make([]*lruShard, shardNum)
+	for i := range shards {
+		shards[i] = &lruShard{
+			lock:     new(sync.Mutex),
+			capacity: capacity / int64(shardNum),
+			ll:       list.New(),
+			items:    make(map[cacheKey]*list.Element),
+		}
+	}
+	return &ValueCache{shards: shards}
+}
+
+// Get 返回位置对应的 value 副本
+func (vc *ValueCache) Get(pos *data.LogRecordPos) ([]byte, bool) {
+	key := cacheKey{fid: pos.Fid, offset: pos.Offset}
+	shard := vc.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	elem, ok := shard.items[key]
+	if !ok {
+		atomic.AddUint64(&vc.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&vc.hits, 1)
+	shard.ll.MoveToFront(elem)
+	value := elem.Value.(*cacheEntry).value
+	res := make([]byte, len(value))
+	copy(res, value)
+	return res, true
+}
+
+// Put 缓存位置对应的 value，超过容量时淘汰最久未被访问的数据
+func (vc *ValueCache) Put(pos *data.LogRecordPos, value []byte) {
+	key := cacheKey{fid: pos.Fid, offset: pos.Offset}
+	shard := vc.shard(key)
+	size := int64(len(value))
+	if size > shard.capacity {
+		return
+	}
+	buf := make([]byte, len(value))
+	copy(buf, value)
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if elem, ok := shard.items[key]; ok {
+		shard.ll.MoveToFront(elem)
+		return
+	}
+	shard.items[key] = shard.ll.PushFront(&cacheEntry{key: key, value: buf})
+	shard.size += size
+	for shard.size > shard.capacity {
+		shard.removeElement(shard.ll.Back())
+	}
+}
+
+// Remove 淘汰位置对应的缓存，在 key 被覆盖或者删除时调用
+func (vc *ValueCache) Remove(pos *data.LogRecordPos) {
+	key := cacheKey{fid: pos.Fid, offset: pos.Offset}
+	shard := vc.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if elem, ok := shard.items[key]; ok {
+		shard.removeElement(elem)
+	}
+}
+
+// Purge 清空所有缓存
+func (vc *ValueCache) Purge() {
+	for _, shard := range vc.shards {
+		shard.lock.Lock()
+		shard.ll.Init()
+		shard.items = make(map[cacheKey]*list.Element)
+		shard.size = 0
+		shard.lock.Unlock()
+	}
+}
+
+// Stats 返回缓存命中和未命中的次数
+func (vc *ValueCache) Stats() (hits uint64, misses uint64) {
+	return atomic.LoadUint64(&vc.hits), atomic.LoadUint64(&vc.misses)
+}
+
+// Size 返回当前缓存的字节数
+func (vc *ValueCache) Size() int64 {
+	var size int64
+	for _, shard := range vc.shards {
+		shard.lock.Lock()
+		size += shard.size
+		shard.lock.Unlock()
+	}
+	return size
+}
+
+func (vc *ValueCache) shard(key cacheKey) *lruShard {
+	h := uint64(key.fid)*0x9E3779B97F4A7C15 ^ uint64(key.offset)
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return vc.shards[h%uint64(len(vc.shards))]
+}
+
+func (s *lruShard) removeElement(elem *list.Element) {
+	entry := s.ll.Remove(elem).(*cacheEntry)
+	delete(s.items, entry.key)
+	s.size -= int64(len(entry.value))
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValueCache_PutGet(t *testing.T) {
+	vc := NewValueCache(1024, 4)
+
+	pos1 := &data.LogRecordPos{Fid: 1, Offset: 10}
+	_, ok := vc.Get(pos1)
+	assert.False(t, ok)
+
+	vc.Put(pos1, []byte("value-1"))
+	val, ok := vc.Get(pos1)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value-1"), val)
+
+	// 修改返回的副本不会影响缓存
+	val[0] = 'x'
+	val, ok = vc.Get(pos1)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value-1"), val)
+
+	hits, misses := vc.Stats()
+	assert.Equal(t, uint64(2), hits)
+	assert.Equal(t, uint64(1), misses)
+}
+
+func TestValueCache_Remove(t *testing.T) {
+	vc := NewValueCache(1024, 4)
+
+	pos1 := &data.LogRecordPos{Fid: 1, Offset: 10}
+	vc.Put(pos1, []byte("value-1"))
+	vc.Remove(pos1)
+	_, ok := vc.Get(pos1)
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), vc.Size())
+
+	vc.Put(pos1, []byte("value-1"))
+	vc.Purge()
+	_, ok = vc.Get(pos1)
+	assert.False(t, ok)
+}
+
+func TestValueCache_Evict(t *testing.T) {
+	vc := NewValueCache(100, 1)
+
+	for i := 0; i < 10; i++ {
+		vc.Put(&data.LogRecordPos{Fid: 1, Offset: int64(i)}, make([]byte, 20))
+	}
+	assert.True(t, vc.Size() <= 100)
+
+	// 最早写入的数据被淘汰，最新写入的数据依然存在
+	_, ok := vc.Get(&data.LogRecordPos{Fid: 1, Offset: 0})
+	assert.False(t, ok)
+	_, ok = vc.Get(&data.LogRecordPos{Fid: 1, Offset: 9})
+	assert.True(t, ok)
+
+	// 超过分片容量的数据不进行缓存
+	vc.Put(&data.LogRecordPos{Fid: 2, Offset: 0}, make([]byte, 200))
+	_, ok = vc.Get(&data.LogRecordPos{Fid: 2, Offset: 0})
+	assert.False(t, ok)
+}
@@ -0,0 +1,94 @@
+package data
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// ChecksumType 数据记录使用的校验算法，记录在数据文件头部中
+type ChecksumType = byte
+
+const (
+	// ChecksumCRC32 CRC32-IEEE，旧版本数据文件使用的算法
+	ChecksumCRC32 ChecksumType = iota
+
+	// ChecksumCRC32C CRC32-Castagnoli，在支持 SSE4.2/ARMv8 CRC 指令的平台上有硬件加速
+	ChecksumCRC32C
+
+	// ChecksumXXHash64 64 位的 xxHash，大 value 下漏检的概率更低
+	ChecksumXXHash64
+)
+
+// 校验值的最大长度
+const maxChecksumSize = 8
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// IsValidChecksumType 判断是否是支持的校验算法
+func IsValidChecksumType(typ ChecksumType) bool {
+	return typ <= ChecksumXXHash64
+}
+
+// checksumSize 返回校验值在记录头部中占用的字节数
+func checksumSize(typ ChecksumType) int {
+	if typ == ChecksumXXHash64 {
+		return 8
+	}
+	return crc32.Size
+}
+
+// checksumDigest 流式计算校验值，用于不能一次读入内存的大 value
+type checksumDigest interface {
+	io.Writer
+	Sum64() uint64
+}
+
+type crc32Digest struct {
+	table *crc32.Table
+	crc   uint32
+}
+
+func (d *crc32Digest) Write(b []byte) (int, error) {
+	d.crc = crc32.Update(d.crc, d.table, b)
+	return len(b), nil
+}
+
+func (d *crc32Digest) Sum64() uint64 {
+	return uint64(d.crc)
+}
+
+func newChecksumDigest(typ ChecksumType) checksumDigest {
+	switch typ {
+	case ChecksumCRC32C:
+		return &crc32Digest{table: castagnoliTable}
+	case ChecksumXXHash64:
+		return newXXHash64()
+	default:
+		return &crc32Digest{table: crc32.IEEETable}
+	}
+}
+
+// computeChecksum 依次对每一段数据计算校验值
+func computeChecksum(typ ChecksumType, parts ...[]byte) uint64 {
+	d := newChecksumDigest(typ)
+	for _, part := range parts {
+		_, _ = d.Write(part)
+	}
+	return d.Sum64()
+}
+
+func putChecksum(buf []byte, typ ChecksumType, checksum uint64) {
+	if checksumSize(typ) == 8 {
+		binary.LittleEndian.PutUint64(buf, checksum)
+	} else {
+		binary.LittleEndian.PutUint32(buf, uint32(checksum))
+	}
+}
+
+func readChecksum(buf []byte, typ ChecksumType) uint64 {
+	if checksumSize(typ) == 8 {
+		return binary.LittleEndian.Uint64(buf)
+	}
+	return uint64(binary.LittleEndian.Uint32(buf))
+}
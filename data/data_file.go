@@ -4,33 +4,182 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Tuanzi-bug/TuanKV/fio"
-	"hash/crc32"
 	"io"
+	"math"
 	"path"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
-	ErrInvalidCRC = errors.New("invalid crc value, log record maybe corrupted")
+	ErrInvalidCRC           = errors.New("invalid crc value, log record maybe corrupted")
+	ErrTruncateNotSupported = errors.New("the io manager does not support truncate")
 )
 
 const (
 	DataFileNameSuffix    = ".data"
+	RecycleFileNameSuffix = ".recycle"
+	HintFileNameSuffix    = ".hint"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
 	SeqNoFileName         = "seq-no"
+	IndexSnapshotFileName = "index-snapshot"
 )
 
 type DataFile struct {
 	FileId    uint32
 	WriteOff  int64
 	IoManager fio.IOManager
+	Header    *FileHeader // 数据文件头部，只有 .data 文件才有
+
+	blockCache  *fio.BlockCache // 共享的块缓存，为 nil 时直接读取文件
+	readAhead   int64           // 检测到顺序读取后的预读字节数
+	readLock    *sync.Mutex
+	lastReadOff int64 // 上一次读取的起始位置
+	lastReadEnd int64 // 上一次读取的结束位置
+	seqReads    int   // 连续顺序读取的次数
+
+	// appendEnd 本进程写入的数据中已经完整写入的末尾，为 0 时表示没有写入过
+	// 读取和写入可以并发进行，块缓存只缓存这之前的数据，避免缓存正在写入的记录
+	appendEnd int64
+
+	// sealed 文件已经封存，不会再写入，尾部不完整的记录说明文件已经损坏，而不是写入时宕机
+	sealed atomic.Bool
+}
+
+// 连续顺序读取达到该次数之后开始预读
+const seqReadsBeforeReadAhead = 2
+
+// FileOptions 创建新的数据文件时写入文件头部的信息
+type FileOptions struct {
+	Fingerprint      uint64               // 配置项的指纹
+	Checksum         ChecksumType         // 新文件中记录使用的校验算法
+	IOManagerFactory fio.IOManagerFactory // 不为 nil 时使用它创建数据文件的 IOManager，忽略 IO 类型
+	VFS              fio.VFS              // 数据文件所在的文件系统，为 nil 时使用操作系统的文件系统
 }
 
-func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+// OpenDataFile 打开数据文件，新文件会先写入文件头部，已有的文件会校验文件头部并沿用其中的校验算法
+func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType, options FileOptions) (*DataFile, error) {
 	// 获取文件路径
 	fileName := GetDataFileName(dirPath, fileId)
-	return newDataFile(fileName, fileId, ioType)
+	if err := initFileHeader(options.VFS, fileName, fileId, options); err != nil {
+		return nil, err
+	}
+	var dataFile *DataFile
+	var err error
+	if options.IOManagerFactory != nil {
+		ioManager, err := options.IOManagerFactory(fileName)
+		if err != nil {
+			return nil, err
+		}
+		dataFile = &DataFile{FileId: fileId, IoManager: ioManager, readLock: new(sync.Mutex)}
+	} else if dataFile, err = newDataFile(options.VFS, fileName, fileId, ioType); err != nil {
+		return nil, err
+	}
+	header, err := dataFile.readFileHeader()
+	if err != nil {
+		_ = dataFile.Close()
+		return nil, err
+	}
+	dataFile.Header = header
+	dataFile.WriteOff = FileHeaderSize
+	return dataFile, nil
+}
+
+// initFileHeader 为空的数据文件写入文件头部，统一使用标准文件 IO 写入并持久化
+func initFileHeader(vfs fio.VFS, fileName string, fileId uint32, options FileOptions) error {
+	if vfs == nil {
+		vfs = fio.OSFS{}
+	}
+	if stat, err := vfs.Stat(fileName); err == nil && stat.Size() > 0 {
+		return nil
+	}
+	ioManager, err := vfs.OpenFile(fileName, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer ioManager.Close()
+	return writeFileHeader(ioManager, fileId, options)
+}
+
+// PrepareRecycledFile 在复用的回收文件开头写入 fileId 的文件头部，文件的其余部分在回收时已经被清零
+// 需要在重命名为数据文件之前调用，避免宕机之后留下没有文件头部的数据文件
+func PrepareRecycledFile(vfs fio.VFS, fileName string, fileId uint32, options FileOptions) error {
+	ioManager, err := vfs.OpenFile(fileName, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer ioManager.Close()
+	if writer, ok := ioManager.(fio.OffsetWriter); ok {
+		if err := writer.SetWriteOffset(0); err != nil {
+			return err
+		}
+	} else if truncater, ok := ioManager.(fio.Truncater); ok {
+		if err := truncater.Truncate(0); err != nil {
+			return err
+		}
+	} else {
+		return ErrTruncateNotSupported
+	}
+	return writeFileHeader(ioManager, fileId, options)
+}
+
+func writeFileHeader(ioManager fio.IOManager, fileId uint32, options FileOptions) error {
+	header := &FileHeader{
+		Version:     CurrentFileVersion,
+		FileId:      fileId,
+		CreatedAt:   time.Now(),
+		Fingerprint: options.Fingerprint,
+		Checksum:    options.Checksum,
+	}
+	if _, err := ioManager.Write(EncodeFileHeader(header)); err != nil {
+		return err
+	}
+	return ioManager.Sync()
+}
+
+func (df *DataFile) readFileHeader() (*FileHeader, error) {
+	buf := make([]byte, FileHeaderSize)
+	n, err := df.IoManager.Read(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	header, err := DecodeFileHeader(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	if header.Version < HeaderFileVersion || header.Version > CurrentFileVersion {
+		return nil, ErrUnsupportedFileVersion
+	}
+	if header.FileId != df.FileId || !IsValidChecksumType(header.Checksum) {
+		return nil, ErrInvalidFileHeader
+	}
+	return header, nil
+}
+
+// ChecksumType 返回文件中记录使用的校验算法，没有文件头部的文件使用 CRC32-IEEE
+func (df *DataFile) ChecksumType() ChecksumType {
+	if df.Header == nil {
+		return ChecksumCRC32
+	}
+	return df.Header.Checksum
+}
+
+// SupportsTimestamp 判断文件中的记录是否可以带有写入时间戳
+func (df *DataFile) SupportsTimestamp() bool {
+	return df.Header != nil && df.Header.Version >= TimestampFileVersion
+}
+
+// EncodeLogRecord 按照文件头部中的校验算法和格式版本对记录进行编码，不支持时间戳的旧文件中去掉时间戳
+func (df *DataFile) EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	if logRecord.Timestamp != 0 && !df.SupportsTimestamp() {
+		record := *logRecord
+		record.Timestamp = 0
+		logRecord = &record
+	}
+	return EncodeLogRecordWithChecksum(logRecord, df.ChecksumType())
 }
 
 func (df *DataFile) Read(offset int64) ([]byte, error) {
@@ -42,9 +191,123 @@ func (df *DataFile) Read(offset int64) ([]byte, error) {
 }
 
 func (df *DataFile) GetLogRecord(offset int64) (*LogRecord, int64, error) {
+	logRecord, _, size, err := df.ReadLogRecord(offset, math.MaxInt64)
+	return logRecord, size, err
+}
+
+// ReadLogRecord 读取 offset 处的记录，value 不超过 maxValueSize 时和 key 一起读入内存并完成校验；
+// 否则返回的记录中不包含 value，由返回的 ValueReader 分块读取 value，读到末尾时完成校验
+func (df *DataFile) ReadLogRecord(offset int64, maxValueSize int64) (*LogRecord, *ValueReader, int64, error) {
+	header, headerBuf, headerSize, err := df.readLogRecordHeader(offset)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	checksumType := df.ChecksumType()
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+	recordSize := headerSize + keySize + valueSize
+
+	logRecord := &LogRecord{
+		Type:      header.recordType,
+		Timestamp: header.timestamp,
+	}
+	if valueSize > maxValueSize {
+		if keySize > 0 {
+			if logRecord.Key, err = df.readNBytes(keySize, offset+headerSize); err != nil {
+				return nil, nil, 0, err
+			}
+		}
+		digest := newChecksumDigest(checksumType)
+		_, _ = digest.Write(headerBuf[checksumSize(checksumType):headerSize])
+		_, _ = digest.Write(logRecord.Key)
+		valueReader := &ValueReader{
+			ioManager: df.IoManager,
+			offset:    offset + headerSize + keySize,
+			size:      valueSize,
+			digest:    digest,
+			checksum:  header.checksum,
+		}
+		return logRecord, valueReader, recordSize, nil
+	}
+	// 读取key和value值
+	if keySize > 0 || valueSize > 0 {
+		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headerSize)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		logRecord.Key = kvBuf[:keySize]
+		logRecord.Value = kvBuf[keySize:]
+	}
+	// 根据解码出来的头部信息和key-value信息生成校验值与记录中的校验值进行对比
+	checksum := getLogRecordChecksum(logRecord, headerBuf[checksumSize(checksumType):headerSize], checksumType)
+	if checksum != header.checksum {
+		return nil, nil, 0, ErrInvalidCRC
+	}
+	return logRecord, nil, recordSize, nil
+}
+
+// ReadLogRecords 读取多个位置上的完整记录，IOManager 支持批量读取时在一次提交中完成，批量读取不经过块缓存
+func (df *DataFile) ReadLogRecords(positions []*LogRecordPos) ([]*LogRecord, error) {
+	logRecords := make([]*LogRecord, len(positions))
+	reader, ok := df.IoManager.(fio.BatchReader)
+	if !ok {
+		for i, pos := range positions {
+			logRecord, _, err := df.GetLogRecord(pos.Offset)
+			if err != nil {
+				return nil, err
+			}
+			logRecords[i] = logRecord
+		}
+		return logRecords, nil
+	}
+
+	reqs := make([]*fio.ReadRequest, len(positions))
+	for i, pos := range positions {
+		reqs[i] = &fio.ReadRequest{Buf: make([]byte, pos.Size), Offset: pos.Offset}
+	}
+	reader.ReadBatch(reqs)
+	for i, req := range reqs {
+		if req.N < len(req.Buf) {
+			if req.Err != nil && req.Err != io.EOF {
+				return nil, req.Err
+			}
+			return nil, io.ErrUnexpectedEOF
+		}
+		logRecord, err := decodeLogRecord(req.Buf, df.ChecksumType())
+		if err != nil {
+			return nil, err
+		}
+		logRecords[i] = logRecord
+	}
+	return logRecords, nil
+}
+
+func (df *DataFile) tornRecordErr() error {
+	if df.sealed.Load() {
+		return io.ErrUnexpectedEOF
+	}
+	return io.EOF
+}
+
+func allZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// readLogRecordHeader 读取并解码 offset 处记录的头部，返回头部、读取到的原始数据以及头部长度
+func (df *DataFile) readLogRecordHeader(offset int64) (*LogRecordHeader, []byte, int64, error) {
 	// 按照最大头部长度进行读取
 	var headerBytes int64 = maxLogRecordHeaderSize
 	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if offset >= fileSize {
+		return nil, nil, 0, io.EOF
+	}
 	// 特殊情况：长度超过了文件大小，则按实际的进行读取
 	if headerBytes+offset > fileSize {
 		headerBytes = fileSize - offset
@@ -52,45 +315,144 @@ func (df *DataFile) GetLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 读取头部信息
 	heardBuf, err := df.readNBytes(headerBytes, offset)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, 0, err
 	}
 	// 对头部信息进行解码
-	header, headerSize := decodeLogRecordHeader(heardBuf)
-	if header == nil || (header.crc == 0 && header.keySize == 0 && header.valueSize == 0) {
-		return nil, 0, io.EOF
+	header, headerSize := decodeLogRecordHeader(heardBuf, df.ChecksumType())
+	// 预分配或者异常宕机后文件尾部可能是全 0 的数据，视为读到了文件末尾
+	if header == nil && allZero(heardBuf) ||
+		header != nil && header.checksum == 0 && header.keySize == 0 && header.valueSize == 0 {
+		return nil, nil, 0, io.EOF
+	}
+	// 活跃文件尾部的记录没有完整写入（写入时宕机），视为读到了文件末尾；封存的文件中出现说明文件已经损坏
+	if header == nil {
+		return nil, nil, 0, df.tornRecordErr()
 	}
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
-	recordSize := headerSize + keySize + valueSize
+	if keySize < 0 || valueSize < 0 {
+		return nil, nil, 0, ErrInvalidCRC
+	}
+	if keySize+valueSize > fileSize-offset-headerSize {
+		return nil, nil, 0, df.tornRecordErr()
+	}
+	return header, heardBuf, headerSize, nil
+}
 
-	logRecord := &LogRecord{
-		Type: header.recordType,
+// Seal 标记文件已经封存，之后读到不完整的记录时返回 io.ErrUnexpectedEOF
+func (df *DataFile) Seal() {
+	df.sealed.Store(true)
+}
+
+func (df *DataFile) Write(buf []byte) error {
+	size, err := df.IoManager.Write(buf)
+	if err != nil {
+		df.rollback(df.WriteOff, size)
+		return err
 	}
-	// 读取key和value值
-	if keySize > 0 || valueSize > 0 {
-		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headerSize)
+	df.SetWriteOff(df.WriteOff + int64(size))
+	return nil
+}
+
+// SetWriteOff 设置之后写入的起始位置，这之前的数据都已经完整写入
+func (df *DataFile) SetWriteOff(offset int64) {
+	df.WriteOff = offset
+	atomic.StoreInt64(&df.appendEnd, offset)
+}
+
+// rollback 写入失败时截断从 offset 开始已经写入的 written 字节，避免之后的记录写在不完整的记录之后
+// 截断失败时保留这部分数据，WriteOff 依然指向文件末尾
+func (df *DataFile) rollback(offset int64, written int) {
+	if written <= 0 {
+		return
+	}
+	if err := df.Truncate(offset); err != nil {
+		df.SetWriteOff(offset + int64(written))
+	}
+}
+
+// WriteBatch 依次追加写入多段数据，IOManager 支持批量写入时写入和持久化在一次提交中完成
+func (df *DataFile) WriteBatch(bufs [][]byte, sync bool) error {
+	if writer, ok := df.IoManager.(fio.BatchWriter); ok {
+		n, err := writer.WriteBatch(bufs, sync)
 		if err != nil {
-			return nil, 0, err
+			df.rollback(df.WriteOff, n)
+			return err
 		}
-		logRecord.Key = kvBuf[:keySize]
-		logRecord.Value = kvBuf[keySize:]
+		df.SetWriteOff(df.WriteOff + int64(n))
+		return nil
 	}
-	// 根据解码出来的头部信息和key-value信息生成crc与记录crc进行对比
-	crc := getLogRecordCRC(logRecord, heardBuf[crc32.Size:headerSize])
-	if crc != header.crc {
-		return nil, 0, ErrInvalidCRC
+	for _, buf := range bufs {
+		if err := df.Write(buf); err != nil {
+			return err
+		}
+	}
+	if sync {
+		return df.Sync()
 	}
-	return logRecord, recordSize, nil
+	return nil
 }
 
-func (df *DataFile) Write(buf []byte) error {
-	size, err := df.IoManager.Write(buf)
-	if err != nil {
+// Truncate 丢弃文件中 size 之后的数据，之后的写入从 size 处开始
+func (df *DataFile) Truncate(size int64) error {
+	truncater, ok := df.IoManager.(fio.Truncater)
+	if !ok {
+		return ErrTruncateNotSupported
+	}
+	if err := truncater.Truncate(size); err != nil {
 		return err
 	}
-	df.WriteOff += int64(size)
+	df.SetWriteOff(size)
 	return nil
 }
 
+// ResetTail 丢弃 WriteOff 之后的数据，之后的写入从 WriteOff 开始
+// IOManager 支持时把这部分数据清零并保留已经分配的磁盘空间（复用的回收文件），否则截断文件
+// zeroed 为 true 时这部分数据已经全部是 0，只需要修改写入的位置
+func (df *DataFile) ResetTail(zeroed bool) error {
+	size, err := df.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if size <= df.WriteOff {
+		return nil
+	}
+	writer, ok := df.IoManager.(fio.OffsetWriter)
+	if !ok {
+		return df.Truncate(df.WriteOff)
+	}
+	if !zeroed {
+		if err := writer.ZeroRange(df.WriteOff, size-df.WriteOff); err != nil {
+			return err
+		}
+	}
+	return writer.SetWriteOffset(df.WriteOff)
+}
+
+// WriteStream 将 reader 中的数据分块追加写入文件，不会把全部数据读入内存
+// 中途失败时截断已经写入的分块，避免留下不完整的记录
+func (df *DataFile) WriteStream(r io.Reader) (int64, error) {
+	buf := make([]byte, streamChunkSize)
+	startOff := df.WriteOff
+	var written int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := df.Write(buf[:n]); err != nil {
+				df.rollback(startOff, int(df.WriteOff-startOff))
+				return 0, err
+			}
+			written += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return written, nil
+		}
+		if err != nil {
+			df.rollback(startOff, int(df.WriteOff-startOff))
+			return 0, err
+		}
+	}
+}
+
 func (df *DataFile) Sync() error {
 	return df.IoManager.Sync()
 }
@@ -101,27 +463,100 @@ func (df *DataFile) Close() error {
 
 func (df *DataFile) readNBytes(n int64, offset int64) (b []byte, err error) {
 	b = make([]byte, n)
-	_, err = df.IoManager.Read(b, offset)
+	if df.blockCache == nil {
+		_, err = df.IoManager.Read(b, offset)
+		return
+	}
+	var ioManager fio.IOManager = df.IoManager
+	if end := atomic.LoadInt64(&df.appendEnd); end > 0 {
+		ioManager = &committedIO{IOManager: df.IoManager, end: end}
+	}
+	_, err = df.blockCache.ReadAt(ioManager, df.FileId, b, offset, df.readAheadSize(offset, n))
 	return
 }
 
-func OpenHintFile(dirPath string) (*DataFile, error) {
+// committedIO 只读取 end 之前已经完整写入的数据，读到 end 时返回 io.EOF
+type committedIO struct {
+	fio.IOManager
+	end int64
+}
+
+func (c *committedIO) Read(b []byte, offset int64) (int, error) {
+	if offset >= c.end {
+		return 0, io.EOF
+	}
+	if offset+int64(len(b)) <= c.end {
+		return c.IOManager.Read(b, offset)
+	}
+	n, err := c.IOManager.Read(b[:c.end-offset], offset)
+	if err == nil {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// SetBlockCache 设置数据文件读取时使用的块缓存，检测到顺序读取时每次预读 readAhead 字节
+func (df *DataFile) SetBlockCache(blockCache *fio.BlockCache, readAhead int64) {
+	df.blockCache = blockCache
+	df.readAhead = readAhead
+}
+
+// readAheadSize 根据最近的读取位置判断是否在顺序读取，是则返回需要预读的字节数
+// 读取记录时会先多读头部再读取 key/value，因此落在上一次读取范围内或者紧接其后（相差不超过一个块）的读取都视为顺序读取
+func (df *DataFile) readAheadSize(offset int64, n int64) int64 {
+	df.readLock.Lock()
+	defer df.readLock.Unlock()
+	if offset >= df.lastReadOff && offset <= df.lastReadEnd+fio.DefaultBlockSize {
+		df.seqReads++
+	} else {
+		df.seqReads = 0
+	}
+	df.lastReadOff = offset
+	if offset+n > df.lastReadEnd || df.seqReads == 0 {
+		df.lastReadEnd = offset + n
+	}
+	if df.seqReads >= seqReadsBeforeReadAhead {
+		return df.readAhead
+	}
+	return 0
+}
+
+func OpenHintFile(vfs fio.VFS, dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(vfs, fileName, 0, fio.StandardFIO)
 }
 
-func OpenSeqNoFile(dirPath string) (*DataFile, error) {
+// OpenHintFileByName 打开指定名称的 hint 文件，用于每个数据文件各自的 hint
+func OpenHintFileByName(vfs fio.VFS, fileName string) (*DataFile, error) {
+	return newDataFile(vfs, fileName, 0, fio.StandardFIO)
+}
+
+func OpenSeqNoFile(vfs fio.VFS, dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, SeqNoFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(vfs, fileName, 0, fio.StandardFIO)
 }
 
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return path.Join(dirPath, fmt.Sprintf("%d", fileId)+DataFileNameSuffix)
 }
 
-func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+// GetHintFileName 返回数据文件对应的 hint 文件名称
+func GetHintFileName(dirPath string, fileId uint32) string {
+	return path.Join(dirPath, fmt.Sprintf("%d", fileId)+HintFileNameSuffix)
+}
+
+// GetRecycleFileName 返回 merge 后被回收、等待复用的数据文件名称
+func GetRecycleFileName(dirPath string, fileId uint32) string {
+	return GetDataFileName(dirPath, fileId) + RecycleFileNameSuffix
+}
+
+// newDataFile 在 vfs 中打开文件，vfs 为 nil 时使用操作系统的文件系统
+func newDataFile(vfs fio.VFS, fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	if vfs == nil {
+		vfs = fio.OSFS{}
+	}
 	// 创建文件对应的io结构体
-	ioManager, err := fio.NewIOManager(fileName, ioType)
+	ioManager, err := vfs.OpenFile(fileName, ioType)
 	if err != nil {
 		return nil, err
 	}
@@ -129,6 +564,7 @@ func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFi
 		FileId:    fileId,
 		WriteOff:  0,
 		IoManager: ioManager,
+		readLock:  new(sync.Mutex),
 	}, nil
 }
 
@@ -143,16 +579,19 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 
 // todo: 为什么这里是写入一个文件而不是一个标识
 
-func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
+func OpenMergeFinishedFile(vfs fio.VFS, dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(vfs, fileName, 0, fio.StandardFIO)
 }
 
-func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
+func (df *DataFile) SetIOManager(vfs fio.VFS, dirPath string, ioType fio.FileIOType) error {
+	if vfs == nil {
+		vfs = fio.OSFS{}
+	}
 	if err := df.IoManager.Close(); err != nil {
 		return err
 	}
-	ioManager, err := fio.NewIOManager(GetDataFileName(dirPath, df.FileId), ioType)
+	ioManager, err := vfs.OpenFile(GetDataFileName(dirPath, df.FileId), ioType)
 	if err != nil {
 		return err
 	}
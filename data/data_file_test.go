@@ -1,28 +1,35 @@
 package data
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/Tuanzi-bug/TuanKV/fio"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"os"
 	"testing"
 )
 
 func TestOpenDataFile(t *testing.T) {
-	dataFile1, err := OpenDataFile(os.TempDir(), 0, fio.StandardFIO)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file")
+	defer os.RemoveAll(dir)
+	dataFile1, err := OpenDataFile(dir, 0, fio.StandardFIO, FileOptions{})
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
-	dataFile2, err := OpenDataFile(os.TempDir(), 111, fio.StandardFIO)
+	dataFile2, err := OpenDataFile(dir, 111, fio.StandardFIO, FileOptions{})
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile2)
 
-	dataFile3, err := OpenDataFile(os.TempDir(), 111, fio.StandardFIO)
+	dataFile3, err := OpenDataFile(dir, 111, fio.StandardFIO, FileOptions{})
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile3)
 }
 
 func TestDataFile_Write(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 0, fio.StandardFIO)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO, FileOptions{})
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -37,7 +44,9 @@ func TestDataFile_Write(t *testing.T) {
 }
 
 func TestDataFile_Close(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 123, fio.StandardFIO)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 123, fio.StandardFIO, FileOptions{})
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -49,7 +58,9 @@ func TestDataFile_Close(t *testing.T) {
 }
 
 func TestDataFile_Sync(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 456, fio.StandardFIO)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 456, fio.StandardFIO, FileOptions{})
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -61,7 +72,9 @@ func TestDataFile_Sync(t *testing.T) {
 }
 
 func TestDataFile_ReadLogRecord(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 6666, fio.StandardFIO)
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-file")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 6666, fio.StandardFIO, FileOptions{})
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -74,7 +87,7 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	err = dataFile.Write(res1)
 	assert.Nil(t, err)
 
-	readRec1, readSize1, err := dataFile.GetLogRecord(0)
+	readRec1, readSize1, err := dataFile.GetLogRecord(FileHeaderSize)
 	assert.Nil(t, err)
 	assert.Equal(t, rec1, readRec1)
 	assert.Equal(t, size1, readSize1)
@@ -89,7 +102,7 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	err = dataFile.Write(res2)
 	assert.Nil(t, err)
 
-	readRec2, readSize2, err := dataFile.GetLogRecord(size1)
+	readRec2, readSize2, err := dataFile.GetLogRecord(FileHeaderSize + size1)
 	assert.Nil(t, err)
 	assert.Equal(t, rec2, readRec2)
 	assert.Equal(t, size2, readSize2)
@@ -104,8 +117,192 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	err = dataFile.Write(res3)
 	assert.Nil(t, err)
 
-	readRec3, readSize3, err := dataFile.GetLogRecord(size1 + size2)
+	readRec3, readSize3, err := dataFile.GetLogRecord(FileHeaderSize + size1 + size2)
 	assert.Nil(t, err)
 	assert.Equal(t, rec3, readRec3)
 	assert.Equal(t, size3, readSize3)
 }
+
+func TestDataFile_ReadZeroTail(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-zero-tail")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO, FileOptions{})
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	rec := &LogRecord{
+		Key:   []byte("name"),
+		Value: []byte("bitcask kv go"),
+	}
+	res, size := EncodeLogRecord(rec)
+	err = dataFile.Write(res)
+	assert.Nil(t, err)
+	// 文件尾部是全 0 的数据
+	err = dataFile.Write(make([]byte, 64))
+	assert.Nil(t, err)
+
+	readRec, readSize, err := dataFile.GetLogRecord(FileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+	assert.Equal(t, size, readSize)
+
+	_, _, err = dataFile.GetLogRecord(FileHeaderSize + size)
+	assert.Equal(t, io.EOF, err)
+	_, _, err = dataFile.GetLogRecord(FileHeaderSize + size + 64)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDataFile_ReadTornRecord(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-torn-record")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO, FileOptions{})
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	rec := &LogRecord{
+		Key:   []byte("name"),
+		Value: []byte("bitcask kv go"),
+	}
+	res, size := EncodeLogRecord(rec)
+	err = dataFile.Write(res)
+	assert.Nil(t, err)
+	// 第二条记录只写入了一半
+	err = dataFile.Write(res[:len(res)/2])
+	assert.Nil(t, err)
+
+	// 活跃文件尾部的不完整记录视为文件末尾
+	_, _, err = dataFile.GetLogRecord(FileHeaderSize + size)
+	assert.Equal(t, io.EOF, err)
+
+	// 封存的文件中出现不完整的记录说明文件已经损坏
+	dataFile.Seal()
+	readRec, _, err := dataFile.GetLogRecord(FileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+	_, _, err = dataFile.GetLogRecord(FileHeaderSize + size)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestDataFile_ReadWithBlockCache(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-block-cache")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO, FileOptions{})
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	blockCache := fio.NewBlockCache(1024*1024, 4096)
+	dataFile.SetBlockCache(blockCache, 64*1024)
+
+	var records []*LogRecord
+	for i := 0; i < 1000; i++ {
+		rec := &LogRecord{
+			Key:   []byte(fmt.Sprintf("key-%d", i)),
+			Value: []byte(fmt.Sprintf("bitcask kv go value %d", i)),
+		}
+		enc, _ := EncodeLogRecord(rec)
+		err = dataFile.Write(enc)
+		assert.Nil(t, err)
+		records = append(records, rec)
+	}
+
+	// 顺序读取整个文件，触发预读之后大部分读取命中缓存
+	var offset int64 = FileHeaderSize
+	for _, rec := range records {
+		readRec, size, err := dataFile.GetLogRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, rec, readRec)
+		offset += size
+	}
+	_, _, err = dataFile.GetLogRecord(offset)
+	assert.Equal(t, io.EOF, err)
+
+	hits, misses := blockCache.Stats()
+	t.Log(hits, misses)
+	assert.True(t, hits > misses*10)
+}
+
+func TestDataFile_ReadLogRecordStream(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO, FileOptions{Checksum: ChecksumXXHash64})
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	value := bytes.Repeat([]byte("bitcask kv go"), 200000)
+	rec := &LogRecord{Key: []byte("name"), Type: LogRecordNormal}
+	stream, err := EncodeLogRecordStream(rec, bytes.NewReader(value), int64(len(value)), ChecksumXXHash64)
+	assert.Nil(t, err)
+	n, err := dataFile.WriteStream(stream.Reader())
+	assert.Nil(t, err)
+	assert.Equal(t, stream.Size(), n)
+
+	// 和一次性编码的结果相同，可以直接读入内存
+	rec.Value = value
+	readRec, size, err := dataFile.GetLogRecord(FileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+	assert.Equal(t, stream.Size(), size)
+
+	// 超过阈值时分块读取
+	readRec, valueReader, size, err := dataFile.ReadLogRecord(FileHeaderSize, StreamValueThreshold)
+	assert.Nil(t, err)
+	assert.Nil(t, readRec.Value)
+	assert.Equal(t, []byte("name"), readRec.Key)
+	assert.Equal(t, int64(len(value)), valueReader.Size())
+	assert.Equal(t, stream.Size(), size)
+	readValue, err := io.ReadAll(valueReader)
+	assert.Nil(t, err)
+	assert.Equal(t, value, readValue)
+
+	// 计算校验值之后 value 被篡改，读到末尾时校验失败
+	corrupted, err := EncodeLogRecordStream(rec, bytes.NewReader(value), int64(len(value)), ChecksumXXHash64)
+	assert.Nil(t, err)
+	value[len(value)-1] = 'X'
+	offset := dataFile.WriteOff
+	_, err = dataFile.WriteStream(corrupted.Reader())
+	assert.Nil(t, err)
+	_, valueReader, _, err = dataFile.ReadLogRecord(offset, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrInvalidCRC, valueReader.Verify())
+}
+
+func TestDataFile_BatchReadWrite(t *testing.T) {
+	for _, ioType := range []fio.FileIOType{fio.StandardFIO, fio.IOUring} {
+		dir, _ := os.MkdirTemp("", "bitcask-go-batch-io")
+		dataFile, err := OpenDataFile(dir, 0, ioType, FileOptions{Checksum: ChecksumCRC32C})
+		assert.Nil(t, err)
+
+		var records []*LogRecord
+		var bufs [][]byte
+		var positions []*LogRecordPos
+		offset := dataFile.WriteOff
+		for i := 0; i < 100; i++ {
+			rec := &LogRecord{
+				Key:   []byte(fmt.Sprintf("key-%d", i)),
+				Value: []byte(fmt.Sprintf("bitcask kv go value %d", i)),
+				Type:  LogRecordNormal,
+			}
+			enc, size := dataFile.EncodeLogRecord(rec)
+			records = append(records, rec)
+			bufs = append(bufs, enc)
+			positions = append(positions, &LogRecordPos{Offset: offset, Size: uint64(size)})
+			offset += size
+		}
+		assert.Nil(t, dataFile.WriteBatch(bufs, true))
+		assert.Equal(t, offset, dataFile.WriteOff)
+
+		readRecords, err := dataFile.ReadLogRecords(positions)
+		assert.Nil(t, err)
+		assert.Equal(t, records, readRecords)
+
+		// 批量读取时位置信息中的长度和记录不一致则校验失败
+		if _, ok := dataFile.IoManager.(fio.BatchReader); ok {
+			positions[3].Size--
+			_, err = dataFile.ReadLogRecords(positions[:5])
+			assert.Equal(t, ErrInvalidCRC, err)
+		}
+
+		assert.Nil(t, dataFile.Close())
+		_ = os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"time"
+)
+
+var (
+	ErrInvalidFileHeader      = errors.New("invalid data file header, data file maybe corrupted")
+	ErrUnsupportedFileVersion = errors.New("unsupported data file format version")
+)
+
+const (
+	// FileHeaderSize 数据文件头部的固定长度，记录从该偏移开始写入
+	FileHeaderSize = 64
+
+	// FileMagic 数据文件头部的魔数 "TKVF"
+	FileMagic uint32 = 0x46564b54
+
+	// LegacyFileVersion 没有文件头部的旧版本数据文件
+	LegacyFileVersion uint16 = 0
+
+	// HeaderFileVersion 第一个带有文件头部的版本
+	HeaderFileVersion uint16 = 1
+
+	// TimestampFileVersion 从该版本开始记录头部中可以带有写入时间戳
+	TimestampFileVersion uint16 = 2
+
+	// CurrentFileVersion 当前写入的数据文件格式版本
+	CurrentFileVersion = TimestampFileVersion
+)
+
+// FileHeader is the metadata written at the beginning of every data file.
+//
+//	+-------+---------+----------+----------+---------+------------+-------------+----------+-------+
+//	| magic | version | checksum | reserved | file id | created at | fingerprint | reserved |  crc  |
+//	+-------+---------+----------+----------+---------+------------+-------------+----------+-------+
+//	   4         2         1          1          4          8            8           32        4
+type FileHeader struct {
+	Version     uint16
+	Checksum    ChecksumType // 文件中记录使用的校验算法
+	FileId      uint32
+	CreatedAt   time.Time
+	Fingerprint uint64 // 创建文件时配置项的指纹，便于排查数据目录被不同配置打开的问题
+}
+
+// EncodeFileHeader 对文件头部进行编码
+func EncodeFileHeader(header *FileHeader) []byte {
+	buf := make([]byte, FileHeaderSize)
+	binary.LittleEndian.PutUint32(buf[0:4], FileMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], header.Version)
+	buf[6] = header.Checksum
+	binary.LittleEndian.PutUint32(buf[8:12], header.FileId)
+	binary.LittleEndian.PutUint64(buf[12:20], uint64(header.CreatedAt.UnixNano()))
+	binary.LittleEndian.PutUint64(buf[20:28], header.Fingerprint)
+	binary.LittleEndian.PutUint32(buf[FileHeaderSize-crc32.Size:], crc32.ChecksumIEEE(buf[:FileHeaderSize-crc32.Size]))
+	return buf
+}
+
+// DecodeFileHeader 对文件头部进行解码，没有魔数的文件视为旧版本的数据文件
+func DecodeFileHeader(buf []byte) (*FileHeader, error) {
+	if len(buf) < FileHeaderSize || binary.LittleEndian.Uint32(buf[0:4]) != FileMagic {
+		return &FileHeader{Version: LegacyFileVersion}, nil
+	}
+	crc := binary.LittleEndian.Uint32(buf[FileHeaderSize-crc32.Size:])
+	if crc != crc32.ChecksumIEEE(buf[:FileHeaderSize-crc32.Size]) {
+		return nil, ErrInvalidFileHeader
+	}
+	return &FileHeader{
+		Version:     binary.LittleEndian.Uint16(buf[4:6]),
+		Checksum:    buf[6],
+		FileId:      binary.LittleEndian.Uint32(buf[8:12]),
+		CreatedAt:   time.Unix(0, int64(binary.LittleEndian.Uint64(buf[12:20]))),
+		Fingerprint: binary.LittleEndian.Uint64(buf[20:28]),
+	}, nil
+}
@@ -0,0 +1,125 @@
+package data
+
+import (
+	"github.com/Tuanzi-bug/TuanKV/fio"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEncodeFileHeader(t *testing.T) {
+	header := &FileHeader{
+		Version:     CurrentFileVersion,
+		FileId:      12,
+		CreatedAt:   time.Unix(0, time.Now().UnixNano()),
+		Fingerprint: 0x1234,
+	}
+	buf := EncodeFileHeader(header)
+	assert.Equal(t, FileHeaderSize, len(buf))
+
+	header2, err := DecodeFileHeader(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, header.Version, header2.Version)
+	assert.Equal(t, header.FileId, header2.FileId)
+	assert.True(t, header.CreatedAt.Equal(header2.CreatedAt))
+	assert.Equal(t, header.Fingerprint, header2.Fingerprint)
+
+	// 头部数据被破坏
+	buf[10] ^= 0xff
+	_, err = DecodeFileHeader(buf)
+	assert.Equal(t, ErrInvalidFileHeader, err)
+
+	// 没有魔数的旧版本文件
+	rec, _ := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask")})
+	header3, err := DecodeFileHeader(rec)
+	assert.Nil(t, err)
+	assert.Equal(t, LegacyFileVersion, header3.Version)
+}
+
+func TestOpenDataFile_Header(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-header")
+	defer os.RemoveAll(dir)
+
+	dataFile, err := OpenDataFile(dir, 7, fio.StandardFIO, FileOptions{Fingerprint: 99})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(FileHeaderSize), dataFile.WriteOff)
+	assert.Equal(t, uint32(7), dataFile.Header.FileId)
+	assert.Equal(t, uint64(99), dataFile.Header.Fingerprint)
+	assert.Nil(t, dataFile.Close())
+
+	// 重新打开时校验头部
+	dataFile2, err := OpenDataFile(dir, 7, fio.StandardFIO, FileOptions{Fingerprint: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(99), dataFile2.Header.Fingerprint)
+	assert.Nil(t, dataFile2.Close())
+
+	// 文件名和头部中的文件 id 不一致
+	err = os.Rename(GetDataFileName(dir, 7), GetDataFileName(dir, 8))
+	assert.Nil(t, err)
+	_, err = OpenDataFile(dir, 8, fio.StandardFIO, FileOptions{Fingerprint: 99})
+	assert.Equal(t, ErrInvalidFileHeader, err)
+}
+
+func TestUpgradeDataFile(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-upgrade")
+	defer os.RemoveAll(dir)
+
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask kv go")}
+	enc, size := EncodeLogRecord(rec)
+	err := os.WriteFile(GetDataFileName(dir, 3), enc, fio.DataFilePerm)
+	assert.Nil(t, err)
+
+	version, err := DataFileVersion(fio.OSFS{}, dir, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, LegacyFileVersion, version)
+	_, err = OpenDataFile(dir, 3, fio.StandardFIO, FileOptions{})
+	assert.Equal(t, ErrUnsupportedFileVersion, err)
+
+	err = UpgradeDataFile(fio.OSFS{}, dir, 3, 0)
+	assert.Nil(t, err)
+	version, err = DataFileVersion(fio.OSFS{}, dir, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, CurrentFileVersion, version)
+
+	dataFile, err := OpenDataFile(dir, 3, fio.StandardFIO, FileOptions{})
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	readRec, readSize, err := dataFile.GetLogRecord(FileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+	assert.Equal(t, size, readSize)
+}
+
+func TestDataFile_EncodeLogRecordVersion(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-version")
+	defer os.RemoveAll(dir)
+
+	lr := &LogRecord{Key: []byte("name"), Value: []byte("bitcask"), Timestamp: time.Now().UnixNano()}
+
+	dataFile, err := OpenDataFile(dir, 1, fio.StandardFIO, FileOptions{})
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	assert.True(t, dataFile.SupportsTimestamp())
+	enc, _ := dataFile.EncodeLogRecord(lr)
+	assert.Nil(t, dataFile.Write(enc))
+	readRec, _, err := dataFile.GetLogRecord(FileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, lr, readRec)
+
+	// 版本 1 的文件中不写入时间戳
+	header := &FileHeader{Version: HeaderFileVersion, FileId: 2, CreatedAt: time.Now()}
+	err = os.WriteFile(GetDataFileName(dir, 2), EncodeFileHeader(header), fio.DataFilePerm)
+	assert.Nil(t, err)
+	oldFile, err := OpenDataFile(dir, 2, fio.StandardFIO, FileOptions{})
+	assert.Nil(t, err)
+	defer oldFile.Close()
+	assert.False(t, oldFile.SupportsTimestamp())
+	enc, _ = oldFile.EncodeLogRecord(lr)
+	assert.Nil(t, oldFile.Write(enc))
+	readRec, _, err = oldFile.GetLogRecord(FileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), readRec.Timestamp)
+	assert.Equal(t, lr.Value, readRec.Value)
+	assert.NotEqual(t, int64(0), lr.Timestamp)
+}
@@ -2,7 +2,6 @@ package data
 
 import (
 	"encoding/binary"
-	"hash/crc32"
 )
 
 type LogRecordType = byte
@@ -13,28 +12,34 @@ const (
 	LogRecordFinished
 )
 
-// crc + type+keySize+valueSize= 4+1+5+5
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+// 记录类型的最高位表示头部中带有写入时间戳
+const logRecordTimestampFlag LogRecordType = 0x80
+
+// checksum + type+keySize+valueSize+timestamp= 8+1+10+10+10
+const maxLogRecordHeaderSize = maxChecksumSize + 1 + binary.MaxVarintLen64*3
 
 // LogRecord is a struct that represents the data record on the disk.
 type LogRecord struct {
 	Key   []byte
 	Value []byte
 	Type  LogRecordType
+
+	Timestamp int64 // 写入时间（Unix 纳秒），为 0 表示没有记录
 }
 
 type LogRecordHeader struct {
-	crc        uint32
+	checksum   uint64
 	recordType LogRecordType
-	keySize    uint32
-	valueSize  uint32
+	keySize    uint64
+	valueSize  uint64
+	timestamp  int64
 }
 
 // LogRecordPos is a struct that represents the position of data record on the disk.
 type LogRecordPos struct {
 	Fid    uint32 // File ID : represents that the file in which the data will be stored.
 	Offset int64  // offset in the file : represents where the data will be stored in the data file.
-	Size   uint32
+	Size   uint64 // 记录在文件中占用的字节数
 }
 
 type TransactionRecord struct {
@@ -42,14 +47,16 @@ type TransactionRecord struct {
 	Pos    *LogRecordPos
 }
 
+// EncodeLogRecord 使用 CRC32-IEEE 对记录进行编码，用于 hint 等没有文件头部的文件
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	return EncodeLogRecordWithChecksum(logRecord, ChecksumCRC32)
+}
+
+// EncodeLogRecordWithChecksum 使用指定的校验算法对记录进行编码
+func EncodeLogRecordWithChecksum(logRecord *LogRecord, checksumType ChecksumType) ([]byte, int64) {
 	header := make([]byte, maxLogRecordHeaderSize)
 
-	var index = 4
-	header[index] = logRecord.Type
-	index += 1
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	index := encodeLogRecordHeader(header, logRecord, int64(len(logRecord.Value)), checksumType)
 	recordSize := index + len(logRecord.Key) + len(logRecord.Value)
 
 	encBytes := make([]byte, recordSize)
@@ -58,41 +65,98 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	copy(encBytes[index:], logRecord.Key)
 	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
 
-	crc := crc32.ChecksumIEEE(encBytes[4:])
-	binary.LittleEndian.PutUint32(encBytes[:4], crc)
+	checksum := computeChecksum(checksumType, encBytes[checksumSize(checksumType):])
+	putChecksum(encBytes, checksumType, checksum)
 	return encBytes, int64(recordSize)
 }
 
-func decodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
-	if len(buf) < 4 {
+// encodeLogRecordHeader 编码除校验值以外的记录头部，返回头部长度
+func encodeLogRecordHeader(buf []byte, logRecord *LogRecord, valueSize int64, checksumType ChecksumType) int {
+	var index = checksumSize(checksumType)
+	buf[index] = logRecord.Type
+	if logRecord.Timestamp != 0 {
+		buf[index] |= logRecordTimestampFlag
+	}
+	index += 1
+	index += binary.PutVarint(buf[index:], int64(len(logRecord.Key)))
+	index += binary.PutVarint(buf[index:], valueSize)
+	if logRecord.Timestamp != 0 {
+		index += binary.PutVarint(buf[index:], logRecord.Timestamp)
+	}
+	return index
+}
+
+func decodeLogRecordHeader(buf []byte, checksumType ChecksumType) (*LogRecordHeader, int64) {
+	var index = checksumSize(checksumType)
+	if len(buf) <= index {
 		return nil, 0
 	}
 	lgHeader := &LogRecordHeader{
-		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		checksum:   readChecksum(buf, checksumType),
+		recordType: buf[index] &^ logRecordTimestampFlag,
 	}
-	var index = 5
+	hasTimestamp := buf[index]&logRecordTimestampFlag != 0
+	index += 1
 	keySize, n := binary.Varint(buf[index:])
-	lgHeader.keySize = uint32(keySize)
+	if n <= 0 {
+		return nil, 0
+	}
+	lgHeader.keySize = uint64(keySize)
 	index += n
 	valueSize, n := binary.Varint(buf[index:])
-	lgHeader.valueSize = uint32(valueSize)
+	if n <= 0 {
+		return nil, 0
+	}
+	lgHeader.valueSize = uint64(valueSize)
 	index += n
+	if hasTimestamp {
+		timestamp, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return nil, 0
+		}
+		lgHeader.timestamp = timestamp
+		index += n
+	}
 	return lgHeader, int64(index)
 }
 
-func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
+// decodeLogRecord 解码一条完整的记录并完成校验，buf 的长度需要和记录的长度一致
+func decodeLogRecord(buf []byte, checksumType ChecksumType) (*LogRecord, error) {
+	header, headerSize := decodeLogRecordHeader(buf, checksumType)
+	if header == nil {
+		return nil, ErrInvalidCRC
+	}
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+	if keySize < 0 || valueSize < 0 || headerSize+keySize+valueSize != int64(len(buf)) {
+		return nil, ErrInvalidCRC
+	}
+	logRecord := &LogRecord{
+		Key:       buf[headerSize : headerSize+keySize],
+		Value:     buf[headerSize+keySize:],
+		Type:      header.recordType,
+		Timestamp: header.timestamp,
+	}
+	checksum := getLogRecordChecksum(logRecord, buf[checksumSize(checksumType):headerSize], checksumType)
+	if checksum != header.checksum {
+		return nil, ErrInvalidCRC
+	}
+	return logRecord, nil
+}
+
+// MaxLogRecordSize 返回 key/value 长度给定时编码后记录的最大长度
+func MaxLogRecordSize(keySize, valueSize int64) int64 {
+	return maxLogRecordHeaderSize + keySize + valueSize
+}
+
+func getLogRecordChecksum(lr *LogRecord, header []byte, checksumType ChecksumType) uint64 {
 	if lr == nil {
 		return 0
 	}
-	crc := crc32.ChecksumIEEE(header)
-	crc = crc32.Update(crc, crc32.IEEETable, lr.Key)
-	crc = crc32.Update(crc, crc32.IEEETable, lr.Value)
-	return crc
+	return computeChecksum(checksumType, header, lr.Key, lr.Value)
 }
 
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*2)
 	index := 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
@@ -113,6 +177,6 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	return &LogRecordPos{
 		Fid:    uint32(fileId),
 		Offset: offset,
-		Size:   uint32(size),
+		Size:   uint64(size),
 	}
 }
@@ -42,36 +42,36 @@ func TestEncodeLogRecord(t *testing.T) {
 
 func TestDecodeLogRecord(t *testing.T) {
 	headerBuf1 := []byte{252, 173, 227, 208, 0, 8, 8}
-	des1, size1 := decodeLogRecordHeader(headerBuf1)
+	des1, size1 := decodeLogRecordHeader(headerBuf1, ChecksumCRC32)
 	t.Log(des1)
 	assert.NotNil(t, des1)
 	assert.Equal(t, int64(7), size1)
-	assert.Equal(t, uint32(3504582140), des1.crc)
+	assert.Equal(t, uint64(3504582140), des1.checksum)
 	assert.Equal(t, LogRecordNormal, des1.recordType)
-	assert.Equal(t, uint32(4), des1.keySize)
-	assert.Equal(t, uint32(4), des1.valueSize)
+	assert.Equal(t, uint64(4), des1.keySize)
+	assert.Equal(t, uint64(4), des1.valueSize)
 
 	// value 为空
 	headerBuf2 := []byte{218, 214, 180, 17, 0, 8, 0}
-	des2, size2 := decodeLogRecordHeader(headerBuf2)
+	des2, size2 := decodeLogRecordHeader(headerBuf2, ChecksumCRC32)
 	t.Log(des2)
 	assert.NotNil(t, des2)
 	assert.Equal(t, int64(7), size2)
-	assert.Equal(t, uint32(297064154), des2.crc)
+	assert.Equal(t, uint64(297064154), des2.checksum)
 	assert.Equal(t, LogRecordNormal, des2.recordType)
-	assert.Equal(t, uint32(4), des2.keySize)
-	assert.Equal(t, uint32(0), des2.valueSize)
+	assert.Equal(t, uint64(4), des2.keySize)
+	assert.Equal(t, uint64(0), des2.valueSize)
 
 	// 对 deleted 情况
 	headerBuf3 := []byte{60, 114, 109, 17, 1, 8, 8}
-	des3, size3 := decodeLogRecordHeader(headerBuf3)
+	des3, size3 := decodeLogRecordHeader(headerBuf3, ChecksumCRC32)
 	t.Log(des3)
 	assert.NotNil(t, des3)
 	assert.Equal(t, int64(7), size3)
-	assert.Equal(t, uint32(292385340), des3.crc)
+	assert.Equal(t, uint64(292385340), des3.checksum)
 	assert.Equal(t, LogRecordDeleted, des3.recordType)
-	assert.Equal(t, uint32(4), des3.keySize)
-	assert.Equal(t, uint32(4), des3.valueSize)
+	assert.Equal(t, uint64(4), des3.keySize)
+	assert.Equal(t, uint64(4), des3.valueSize)
 }
 
 func TestGetLogRecordCRC(t *testing.T) {
@@ -83,8 +83,8 @@ func TestGetLogRecordCRC(t *testing.T) {
 	res1, _ := EncodeLogRecord(lr1)
 	headerBuf1 := res1[:7]
 
-	crc1 := getLogRecordCRC(lr1, headerBuf1[crc32.Size:])
-	assert.Equal(t, uint32(3504582140), crc1)
+	crc1 := getLogRecordChecksum(lr1, headerBuf1[crc32.Size:], ChecksumCRC32)
+	assert.Equal(t, uint64(3504582140), crc1)
 
 	// value 为 0
 	lr2 := &LogRecord{
@@ -95,8 +95,8 @@ func TestGetLogRecordCRC(t *testing.T) {
 	res2, _ := EncodeLogRecord(lr2)
 	headerBuf2 := res2[:7]
 
-	crc2 := getLogRecordCRC(lr2, headerBuf2[crc32.Size:])
-	assert.Equal(t, uint32(297064154), crc2)
+	crc2 := getLogRecordChecksum(lr2, headerBuf2[crc32.Size:], ChecksumCRC32)
+	assert.Equal(t, uint64(297064154), crc2)
 
 	lr3 := &LogRecord{
 		Key:   []byte("tuan"),
@@ -106,6 +106,77 @@ func TestGetLogRecordCRC(t *testing.T) {
 	res3, _ := EncodeLogRecord(lr3)
 	headerBuf3 := res3[:7]
 
-	crc3 := getLogRecordCRC(lr3, headerBuf3[crc32.Size:])
-	assert.Equal(t, uint32(292385340), crc3)
+	crc3 := getLogRecordChecksum(lr3, headerBuf3[crc32.Size:], ChecksumCRC32)
+	assert.Equal(t, uint64(292385340), crc3)
+}
+
+func TestEncodeLogRecordWithChecksum(t *testing.T) {
+	for _, typ := range []ChecksumType{ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash64} {
+		lr := &LogRecord{
+			Key:   []byte("tuan"),
+			Value: []byte("bitcask kv go"),
+			Type:  LogRecordNormal,
+		}
+		res, n := EncodeLogRecordWithChecksum(lr, typ)
+		assert.Equal(t, int64(len(res)), n)
+
+		header, headerSize := decodeLogRecordHeader(res, typ)
+		assert.NotNil(t, header)
+		assert.Equal(t, int64(checksumSize(typ)+3), headerSize)
+		assert.Equal(t, uint64(4), header.keySize)
+		assert.Equal(t, uint64(13), header.valueSize)
+
+		checksum := getLogRecordChecksum(lr, res[checksumSize(typ):headerSize], typ)
+		assert.Equal(t, header.checksum, checksum)
+
+		// value 被篡改之后校验失败
+		lr.Value = []byte("bitcask kv gO")
+		assert.NotEqual(t, header.checksum, getLogRecordChecksum(lr, res[checksumSize(typ):headerSize], typ))
+	}
+}
+
+func TestXXHash64(t *testing.T) {
+	cases := map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"a":   0xd24ec4f1a98c6e5b,
+		"abc": 0x44bc2cf5ad770999,
+		"Nobody inspects the spammish repetition": 0xfbcea83c8a378bf1,
+	}
+	for input, expected := range cases {
+		d := newXXHash64()
+		d.Write([]byte(input))
+		assert.Equal(t, expected, d.Sum64())
+
+		// 分多次写入的结果和一次写入相同
+		d2 := newXXHash64()
+		for i := 0; i < len(input); i++ {
+			d2.Write([]byte{input[i]})
+		}
+		assert.Equal(t, expected, d2.Sum64())
+	}
+}
+
+func TestEncodeLogRecordWithTimestamp(t *testing.T) {
+	lr := &LogRecord{
+		Key:       []byte("tuan"),
+		Value:     []byte("bitcask kv go"),
+		Type:      LogRecordDeleted,
+		Timestamp: 1700000000123456789,
+	}
+	res, n := EncodeLogRecordWithChecksum(lr, ChecksumCRC32C)
+	assert.Equal(t, int64(len(res)), n)
+
+	header, headerSize := decodeLogRecordHeader(res, ChecksumCRC32C)
+	assert.NotNil(t, header)
+	assert.Equal(t, LogRecordDeleted, header.recordType)
+	assert.Equal(t, lr.Timestamp, header.timestamp)
+	assert.Equal(t, uint64(13), header.valueSize)
+	assert.Equal(t, header.checksum, getLogRecordChecksum(lr, res[checksumSize(ChecksumCRC32C):headerSize], ChecksumCRC32C))
+
+	// 没有时间戳的记录和之前的格式相同
+	lr.Timestamp = 0
+	res2, _ := EncodeLogRecordWithChecksum(lr, ChecksumCRC32C)
+	header2, headerSize2 := decodeLogRecordHeader(res2, ChecksumCRC32C)
+	assert.Equal(t, int64(0), header2.timestamp)
+	assert.Equal(t, int64(checksumSize(ChecksumCRC32C)+3), headerSize2)
 }
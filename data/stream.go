@@ -0,0 +1,123 @@
+package data
+
+import (
+	"bytes"
+	"github.com/Tuanzi-bug/TuanKV/fio"
+	"io"
+)
+
+const (
+	// StreamValueThreshold value 超过该长度时，重建索引和 merge 改为分块读取，避免把大 value 整个读入内存
+	StreamValueThreshold = 1024 * 1024
+
+	// 分块读写大 value 时每一块的大小
+	streamChunkSize = 1024 * 1024
+)
+
+// ValueReader 分块读取数据文件中一条记录的 value，读到末尾时校验整条记录
+type ValueReader struct {
+	ioManager fio.IOManager
+	offset    int64 // value 在文件中的起始位置
+	size      int64
+	read      int64 // 已经顺序读取的字节数
+	digest    checksumDigest
+	checksum  uint64 // 记录头部中保存的校验值
+}
+
+// Size 返回 value 的长度
+func (vr *ValueReader) Size() int64 {
+	return vr.size
+}
+
+// Read 顺序读取 value，读到末尾时校验值不一致则返回 ErrInvalidCRC
+func (vr *ValueReader) Read(p []byte) (int, error) {
+	if vr.read >= vr.size {
+		return 0, vr.verify()
+	}
+	if remain := vr.size - vr.read; int64(len(p)) > remain {
+		p = p[:remain]
+	}
+	n, err := vr.ioManager.Read(p, vr.offset+vr.read)
+	_, _ = vr.digest.Write(p[:n])
+	vr.read += int64(n)
+	if err == io.EOF {
+		if vr.read < vr.size {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	if err == nil && vr.read == vr.size {
+		if err := vr.verify(); err != io.EOF {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// ReadAt 随机读取 value 中的一段数据，不进行校验
+func (vr *ValueReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= vr.size {
+		return 0, io.EOF
+	}
+	var err error
+	if remain := vr.size - off; int64(len(p)) > remain {
+		p = p[:remain]
+		err = io.EOF
+	}
+	n, readErr := vr.ioManager.Read(p, vr.offset+off)
+	if readErr != nil {
+		return n, readErr
+	}
+	return n, err
+}
+
+// Verify 读取剩余的 value 并校验整条记录
+func (vr *ValueReader) Verify() error {
+	_, err := io.Copy(io.Discard, vr)
+	return err
+}
+
+func (vr *ValueReader) verify() error {
+	if vr.digest.Sum64() != vr.checksum {
+		return ErrInvalidCRC
+	}
+	return io.EOF
+}
+
+// LogRecordStream 编码后的流式记录，头部和 key 保存在内存中，value 在写入时才从 io.ReaderAt 中读取
+type LogRecordStream struct {
+	prefix    []byte
+	value     io.ReaderAt
+	valueSize int64
+}
+
+// EncodeLogRecordStream 对 value 由 io.ReaderAt 给出的记录进行编码，计算校验值时会完整读取一遍 value，
+// 写入时通过 Reader 再读取一遍，整个过程只在内存中保留一个分块
+func EncodeLogRecordStream(logRecord *LogRecord, value io.ReaderAt, valueSize int64, checksumType ChecksumType) (*LogRecordStream, error) {
+	prefix := make([]byte, maxLogRecordHeaderSize+len(logRecord.Key))
+	index := encodeLogRecordHeader(prefix, logRecord, valueSize, checksumType)
+	index += copy(prefix[index:], logRecord.Key)
+	prefix = prefix[:index]
+
+	digest := newChecksumDigest(checksumType)
+	_, _ = digest.Write(prefix[checksumSize(checksumType):])
+	n, err := io.CopyBuffer(digest, io.NewSectionReader(value, 0, valueSize), make([]byte, streamChunkSize))
+	if err != nil {
+		return nil, err
+	}
+	if n != valueSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	putChecksum(prefix, checksumType, digest.Sum64())
+	return &LogRecordStream{prefix: prefix, value: value, valueSize: valueSize}, nil
+}
+
+// Size 返回编码后记录的长度
+func (s *LogRecordStream) Size() int64 {
+	return int64(len(s.prefix)) + s.valueSize
+}
+
+// Reader 返回依次输出整条编码后记录的 reader
+func (s *LogRecordStream) Reader() io.Reader {
+	return io.MultiReader(bytes.NewReader(s.prefix), io.NewSectionReader(s.value, 0, s.valueSize))
+}
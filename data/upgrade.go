@@ -0,0 +1,96 @@
+package data
+
+import (
+	"errors"
+	"github.com/Tuanzi-bug/TuanKV/fio"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// 转换数据文件格式时使用的临时文件后缀
+const upgradeFileNameSuffix = ".upgrade"
+
+// DataFileVersion 读取数据文件的格式版本，空文件在打开时会直接写入当前版本的头部，因此视为当前版本
+func DataFileVersion(vfs fio.VFS, dirPath string, fileId uint32) (uint16, error) {
+	if vfs == nil {
+		vfs = fio.OSFS{}
+	}
+	file, err := vfs.OpenFile(GetDataFileName(dirPath, fileId), fio.StandardFIO)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, FileHeaderSize)
+	n, err := file.Read(buf, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if n == 0 {
+		return CurrentFileVersion, nil
+	}
+	header, err := DecodeFileHeader(buf[:n])
+	if err != nil {
+		return 0, err
+	}
+	return header.Version, nil
+}
+
+// UpgradeDataFile 将没有文件头部的旧版本数据文件转换为当前版本的格式，转换之后所有记录的偏移都增加 FileHeaderSize
+// 旧版本的记录使用 CRC32-IEEE 校验，记录内容保持不变
+// 转换时先写入临时文件，完成之后再替换原文件，中途崩溃不会破坏原文件
+func UpgradeDataFile(vfs fio.VFS, dirPath string, fileId uint32, fingerprint uint64) error {
+	if vfs == nil {
+		vfs = fio.OSFS{}
+	}
+	fileName := GetDataFileName(dirPath, fileId)
+	tmpFileName := fileName + upgradeFileNameSuffix
+
+	src, err := vfs.OpenFile(fileName, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// 清理上次转换时遗留的临时文件
+	if err := vfs.Remove(tmpFileName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	dst, err := vfs.OpenFile(tmpFileName, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	header := &FileHeader{
+		Version:     CurrentFileVersion,
+		FileId:      fileId,
+		CreatedAt:   time.Now(),
+		Fingerprint: fingerprint,
+		Checksum:    ChecksumCRC32,
+	}
+	if _, err := dst.Write(EncodeFileHeader(header)); err != nil {
+		return err
+	}
+	buf := make([]byte, streamChunkSize)
+	for offset := int64(0); ; {
+		n, err := src.Read(buf, offset)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := dst.Sync(); err != nil {
+		return err
+	}
+	return vfs.Rename(tmpFileName, fileName)
+}
@@ -0,0 +1,108 @@
+package data
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// xxHash64 的实现，参考 https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// xxHash64 is a streaming xxHash64 digest with seed 0.
+type xxHash64 struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	mem            [32]byte
+	n              int // mem 中缓存的字节数
+}
+
+func newXXHash64() *xxHash64 {
+	// 使用变量避免常量运算溢出的编译错误
+	prime1, prime2 := xxPrime1, xxPrime2
+	return &xxHash64{
+		v1: prime1 + prime2,
+		v2: prime2,
+		v3: 0,
+		v4: -prime1,
+	}
+}
+
+func (d *xxHash64) Write(b []byte) (int, error) {
+	size := len(b)
+	d.total += uint64(size)
+	if d.n+size < 32 {
+		d.n += copy(d.mem[d.n:], b)
+		return size, nil
+	}
+	if d.n > 0 {
+		c := copy(d.mem[d.n:], b)
+		d.v1 = xxRound(d.v1, binary.LittleEndian.Uint64(d.mem[0:8]))
+		d.v2 = xxRound(d.v2, binary.LittleEndian.Uint64(d.mem[8:16]))
+		d.v3 = xxRound(d.v3, binary.LittleEndian.Uint64(d.mem[16:24]))
+		d.v4 = xxRound(d.v4, binary.LittleEndian.Uint64(d.mem[24:32]))
+		b = b[c:]
+		d.n = 0
+	}
+	for ; len(b) >= 32; b = b[32:] {
+		d.v1 = xxRound(d.v1, binary.LittleEndian.Uint64(b[0:8]))
+		d.v2 = xxRound(d.v2, binary.LittleEndian.Uint64(b[8:16]))
+		d.v3 = xxRound(d.v3, binary.LittleEndian.Uint64(b[16:24]))
+		d.v4 = xxRound(d.v4, binary.LittleEndian.Uint64(b[24:32]))
+	}
+	d.n = copy(d.mem[:], b)
+	return size, nil
+}
+
+func (d *xxHash64) Sum64() uint64 {
+	var h uint64
+	if d.total >= 32 {
+		h = bits.RotateLeft64(d.v1, 1) + bits.RotateLeft64(d.v2, 7) + bits.RotateLeft64(d.v3, 12) + bits.RotateLeft64(d.v4, 18)
+		h = xxMergeRound(h, d.v1)
+		h = xxMergeRound(h, d.v2)
+		h = xxMergeRound(h, d.v3)
+		h = xxMergeRound(h, d.v4)
+	} else {
+		h = d.v3 + xxPrime5
+	}
+	h += d.total
+
+	b := d.mem[:d.n]
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b[:8]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b[:4])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	val = xxRound(0, val)
+	acc ^= val
+	return acc*xxPrime1 + xxPrime4
+}
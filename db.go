@@ -1,42 +1,88 @@
 package bitcask_go
 
 import (
+	"errors"
 	"fmt"
+	"github.com/Tuanzi-bug/TuanKV/cache"
 	"github.com/Tuanzi-bug/TuanKV/data"
 	"github.com/Tuanzi-bug/TuanKV/fio"
 	"github.com/Tuanzi-bug/TuanKV/index"
 	redis2 "github.com/Tuanzi-bug/TuanKV/redis/interface/redis"
 	"github.com/Tuanzi-bug/TuanKV/utils"
-	"github.com/gofrs/flock"
-	"io"
-	"os"
+	"io/fs"
+	"math"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
 	seqNoKey     = "seq-no"
 	fileLockName = "flock"
+
+	// 内存模式下没有配置 DirPath 时使用的路径
+	memoryDirPath = "tuankv"
+
+	// Fold 每批读取的最大记录数和最大字节数
+	foldBatchSize  = 64
+	foldBatchBytes = 1024 * 1024
+
+	// 按照 key 分段的写入锁的数量
+	keyLockStripes = 256
 )
 
 // DB is Storage engine instance of bitcask
 type DB struct {
-	options         Options                   // 用户的配置项
-	fileIds         []uint32                  //文件对应的ID
-	mu              *sync.RWMutex             //锁
-	activeFile      *data.DataFile            //当前正在写入的文件
-	olderFiles      map[uint32]*data.DataFile // 历史文件
-	index           index.Indexer             //索引
-	seqNo           uint64
-	isMerging       bool
-	seqNoFileExists bool
-	isInitial       bool
-	fileLock        *flock.Flock
-	bytesWrite      uint
-	reclaimSize     int64
+	options     Options                   // 用户的配置项
+	fileIds     []uint32                  //文件对应的ID
+	mu          *sync.RWMutex             //锁，追加写入数据文件时互斥
+	filesLock   *sync.RWMutex             // 保护活跃文件、历史文件和索引的替换，读取数据时只需要获取它的读锁
+	writeLock   *sync.RWMutex             // 写入从追加记录到更新索引的整个过程持有读锁，需要索引和日志一致的操作（快照、merge、关闭）持有写锁
+	keyLocks    []sync.Mutex              // 按照 key 的哈希分段的锁，保证同一个 key 的写入按照追加的顺序更新索引
+	indexLock   *sync.RWMutex             // 批量写入持有写锁更新索引，读取持有读锁访问索引，不会看到只更新了一部分的批量写入
+	activeFile  *data.DataFile            //当前正在写入的文件
+	olderFiles  map[uint32]*data.DataFile // 历史文件
+	index       index.Indexer             //索引
+	seqNo       uint64
+	isMerging   bool
+	isInitial   bool
+	fileLock    fio.FileLock
+	vfs         fio.VFS // 数据目录所在的文件系统
+	bytesWrite  uint
+	reclaimSize int64 // 失效数据的大小，索引在 db.mu 之外更新，使用原子操作读写
+
+	diskSize          int64 // 数据目录当前占用的磁盘空间，仅在配置了 MaxDiskBytes 时维护
+	availableDiskSize int64 // 磁盘剩余可用空间
+	quotaMerging      int32 // 是否已经因为超过软水位触发了后台 merge
+	quotaMergeNext    int64 // 下一次允许自动 merge 的时间（UnixNano）
+	quotaMergeReclaim int64 // 上一次自动 merge 时的失效数据量
+	quotaMergeBackoff time.Duration
+	bgWg              *sync.WaitGroup
+	recycledFiles     []string           // merge 后回收的、等待复用的数据文件
+	mergeLimiter      *utils.RateLimiter // merge 和备份的读写限速
+
+	activeFileCreatedAt time.Time // 当前活跃文件的创建时间（重启后为加载时间）
+	activeFileRecords   uint      // 当前活跃文件中的记录数
+
+	valueCache *cache.ValueCache // 热点数据缓存，未开启时为 nil
+	blockCache *fio.BlockCache   // 数据文件块缓存，未开启时为 nil
+
+	fingerprint     uint64   // 写入数据文件头部的配置指纹
+	mismatchedFiles []uint32 // 打开时头部的配置指纹和当前配置不一致的数据文件
+
+	indexMergeFid uint32 // B+ 树索引中已经包含的 merge 结果对应的 nonMergeFileId
+
+	skipHintFiles bool // 封存数据文件时不生成 hint 文件，merge 使用的临时实例由 merge 自己的 hint 文件记录索引
+
+	snapshotPos     *indexSnapshotPos // 启动时加载的索引快照（或者 B+ 树索引的 checkpoint）覆盖到的位置，没有时为 nil
+	lastSnapshotPos *indexSnapshotPos // 最近一次保存的索引快照覆盖到的位置
+	snapshotLock    *sync.Mutex
+	backupLock      *sync.RWMutex // 备份复制文件时持有读锁，reload 持有写锁
+	closeCh         chan struct{} // 关闭数据库时通知后台任务退出
 }
 
 func (db *DB) Exec(client redis2.Connection, cmdLine [][]byte) redis2.Reply {
@@ -50,10 +96,16 @@ func (db *DB) AfterClientClose(c redis2.Connection) {
 }
 
 type Stat struct {
-	keyNum          uint
-	DataFileNum     uint
-	reclaimableSize int64
-	DiskSize        int64
+	keyNum           uint
+	DataFileNum      uint
+	reclaimableSize  int64
+	DiskSize         int64
+	ValueCacheHits   uint64            // 热点数据缓存命中次数
+	ValueCacheMisses uint64            // 热点数据缓存未命中次数
+	BlockCacheHits   uint64            // 块缓存命中次数
+	BlockCacheMisses uint64            // 块缓存未命中次数
+	IndexMemory      index.MemoryUsage // 紧凑索引的内存占用，其他索引为空
+	MismatchedFiles  []uint32          // 使用不同的配置写入的数据文件，头部的配置指纹和当前配置不一致
 }
 
 // Put is a method to store the key-value pair in the storage engine
@@ -68,26 +120,30 @@ func (db *DB) Put(key []byte, value []byte) error {
 		Type:  data.LogRecordNormal,
 	}
 
-	// 添加进入文件中
-	pos, err := db.appendLogRecordWithLock(logRecord)
-	if err != nil {
-		return err
-	}
-	// 记录写入索引树中
-	if oldValue := db.index.Put(key, pos); oldValue != nil {
-		db.reclaimSize += int64(oldValue.Size)
-	}
-	return nil
+	db.throttleWrite()
+	unlock := db.lockKeys([][]byte{key})
+	defer unlock()
+	_, err := db.appendAndApply(func() ([]index.BatchOp, error) {
+		// 添加进入文件中
+		pos, err := db.appendLogRecord(logRecord)
+		if err != nil {
+			return nil, err
+		}
+		return []index.BatchOp{{Key: key, Pos: pos}}, nil
+	})
+	return err
 }
 
+// Get 读取 key 对应的 value，不获取 db.mu，只在批量写入更新索引时短暂等待
+// 记录完整写入数据文件之后才会更新索引，因此从索引中读到的位置总是可以直接读取
 func (db *DB) Get(key []byte) ([]byte, error) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	db.filesLock.RLock()
+	defer db.filesLock.RUnlock()
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
 	// 获取key对应的文件ID以及偏置
-	logRecordPos := db.index.Get(key)
+	logRecordPos := db.indexGet(key)
 
 	if logRecordPos == nil {
 		return nil, ErrKeyNotFound
@@ -96,32 +152,140 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	return db.getValueByPosition(logRecordPos)
 }
 
-func (db *DB) getValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
+// RecordMeta 记录的元信息
+type RecordMeta struct {
+	SeqNo     uint64    // 写入时的事务序列号，非事务写入为 0
+	Timestamp time.Time // 写入时间，没有开启 RecordTimestamp 时为零值
+	Size      int64     // 记录在数据文件中占用的字节数
+}
+
+// GetWithMeta 获取 key 对应的 value 以及记录的元信息
+func (db *DB) GetWithMeta(key []byte) ([]byte, *RecordMeta, error) {
+	db.filesLock.RLock()
+	defer db.filesLock.RUnlock()
+	if len(key) == 0 {
+		return nil, nil, ErrKeyIsEmpty
+	}
+	logRecordPos := db.indexGet(key)
+	if logRecordPos == nil {
+		return nil, nil, ErrKeyNotFound
+	}
+	logRecord, err := db.getLogRecordByPosition(logRecordPos, math.MaxInt64)
+	if err != nil {
+		return nil, nil, err
+	}
+	return logRecord.Value, newRecordMeta(logRecord, logRecordPos), nil
+}
+
+func newRecordMeta(logRecord *data.LogRecord, pos *data.LogRecordPos) *RecordMeta {
+	_, seqNo := parseLogRecordKey(logRecord.Key)
+	meta := &RecordMeta{
+		SeqNo: seqNo,
+		Size:  int64(pos.Size),
+	}
+	if logRecord.Timestamp != 0 {
+		meta.Timestamp = time.Unix(0, logRecord.Timestamp)
+	}
+	return meta
+}
+
+// getDataFile 根据ID寻找对应文件对象，调用方需要持有 db.mu 或者 db.filesLock
+func (db *DB) getDataFile(fileId uint32) (*data.DataFile, error) {
 	var dataFile *data.DataFile
-	// 根据ID寻找对应文件对象
-	if db.activeFile.FileId == pos.Fid {
+	if db.activeFile != nil && db.activeFile.FileId == fileId {
 		dataFile = db.activeFile
 	} else {
-		dataFile = db.olderFiles[pos.Fid]
+		dataFile = db.olderFiles[fileId]
 	}
-
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
 	}
+	return dataFile, nil
+}
+
+// getLogRecordByPosition 读取 pos 处的记录，value 超过 maxValueSize 时不读取 value
+func (db *DB) getLogRecordByPosition(pos *data.LogRecordPos, maxValueSize int64) (*data.LogRecord, error) {
+	dataFile, err := db.getDataFile(pos.Fid)
+	if err != nil {
+		return nil, err
+	}
+	logRecord, _, _, err := dataFile.ReadLogRecord(pos.Offset, maxValueSize)
+	return logRecord, err
+}
+
+func (db *DB) getValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
+	dataFile, err := db.getDataFile(pos.Fid)
+	if err != nil {
+		return nil, err
+	}
+
+	if db.valueCache != nil {
+		if value, ok := db.valueCache.Get(pos); ok {
+			return value, nil
+		}
+	}
 
 	// 从文件中读取内容
 	value, err := dataFile.Read(pos.Offset)
 	if err != nil {
 		return nil, err
 	}
+	if db.valueCache != nil {
+		db.valueCache.Put(pos, value)
+	}
 	return value, err
 }
 
+// getValuesByPositions 读取多个位置上的 value，同一个数据文件中没有命中缓存的记录合并为一次批量读取
+func (db *DB) getValuesByPositions(positions []*data.LogRecordPos) ([][]byte, error) {
+	values := make([][]byte, len(positions))
+	misses := make(map[uint32][]int)
+	for i, pos := range positions {
+		if db.valueCache != nil {
+			if value, ok := db.valueCache.Get(pos); ok {
+				values[i] = value
+				continue
+			}
+		}
+		misses[pos.Fid] = append(misses[pos.Fid], i)
+	}
+	for fid, indexes := range misses {
+		dataFile, err := db.getDataFile(fid)
+		if err != nil {
+			return nil, err
+		}
+		filePositions := make([]*data.LogRecordPos, len(indexes))
+		for j, i := range indexes {
+			filePositions[j] = positions[i]
+		}
+		logRecords, err := dataFile.ReadLogRecords(filePositions)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range indexes {
+			values[i] = logRecords[j].Value
+			if db.valueCache != nil {
+				db.valueCache.Put(positions[i], values[i])
+			}
+		}
+	}
+	return values, nil
+}
+
+// evictValueCache key 被覆盖或者删除后淘汰旧位置上的缓存
+func (db *DB) evictValueCache(pos *data.LogRecordPos) {
+	if db.valueCache != nil {
+		db.valueCache.Remove(pos)
+	}
+}
+
 func (db *DB) Delete(key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 
+	unlock := db.lockKeys([][]byte{key})
+	defer unlock()
 	// 先获取key对应的位置信息
 	logRecordPos := db.index.Get(key)
 	if logRecordPos == nil {
@@ -132,110 +296,373 @@ func (db *DB) Delete(key []byte) error {
 		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
 		Type: data.LogRecordDeleted,
 	}
-	// 写入记录
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	// 写入记录，然后删除索引 -- 对用户来说该key已经删除了
+	oldValues, err := db.appendAndApply(func() ([]index.BatchOp, error) {
+		pos, err := db.appendLogRecord(logRecord)
+		if err != nil {
+			return nil, err
+		}
+		return []index.BatchOp{{Key: key, Pos: pos, Delete: true}}, nil
+	})
 	if err != nil {
 		return err
 	}
-	db.reclaimSize += int64(pos.Size)
-	// 删除索引 -- 对用户来说该key已经删除了
-	oldValue, ok := db.index.Delete(key)
-	if !ok {
+	if oldValues[0] == nil {
 		return ErrIndexUpdateFailed
 	}
-	if oldValue != nil {
-		db.reclaimSize += int64(oldValue.Size)
-	}
 	return nil
 }
 
+// lockKeys 获取 db.writeLock 的读锁以及 keys 所在分段的锁，返回释放锁的函数
+// 同一个 key 的写入在追加记录到更新索引的整个过程中互斥，保证索引总是指向最后追加的记录；不同 key 的写入只在追加时互斥
+func (db *DB) lockKeys(keys [][]byte) func() {
+	stripes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		stripes = append(stripes, keyLockStripe(key))
+	}
+	// 按照固定的顺序加锁，避免多个批量写入之间死锁
+	sort.Ints(stripes)
+	db.writeLock.RLock()
+	locked := stripes[:0]
+	for i, stripe := range stripes {
+		if i > 0 && stripe == stripes[i-1] {
+			continue
+		}
+		db.keyLocks[stripe].Lock()
+		locked = append(locked, stripe)
+	}
+	return func() {
+		for _, stripe := range locked {
+			db.keyLocks[stripe].Unlock()
+		}
+		db.writeLock.RUnlock()
+	}
+}
+
+// keyLockStripe 使用 FNV-1a 哈希选择 key 所在的锁分段
+func keyLockStripe(key []byte) int {
+	var h uint32 = 2166136261
+	for _, b := range key {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return int(h % keyLockStripes)
+}
+
+// appendAndApply 持有 db.mu 执行 appendFn 追加记录，然后在 db.mu 之外更新索引，调用方需要通过 lockKeys 锁住所有的 key
+// B+ 树索引在同一个事务中记录日志的位置，需要和追加保持相同的顺序，仍然在 db.mu 中更新
+func (db *DB) appendAndApply(appendFn func() ([]index.BatchOp, error)) ([]*data.LogRecordPos, error) {
+	db.mu.Lock()
+	ops, err := appendFn()
+	if err != nil {
+		db.mu.Unlock()
+		return nil, err
+	}
+	if _, ok := db.index.(*index.BPlusTree); ok {
+		defer db.mu.Unlock()
+		return db.applyIndexOps(ops, true)
+	}
+	db.mu.Unlock()
+	return db.applyIndexOps(ops, true)
+}
+
+// publishIndexOps 依次执行一批索引更新，多个更新在 indexLock 的写锁中完成，读取只能看到整批更新之前或者之后的索引
+func (db *DB) publishIndexOps(ops []index.BatchOp) []*data.LogRecordPos {
+	if len(ops) > 1 {
+		db.indexLock.Lock()
+		defer db.indexLock.Unlock()
+	}
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	for i, op := range ops {
+		if op.Delete {
+			oldPositions[i], _ = db.index.Delete(op.Key)
+		} else {
+			oldPositions[i] = db.index.Put(op.Key, op.Pos)
+		}
+	}
+	return oldPositions
+}
+
+// indexGet 在 indexLock 的读锁中读取 key 在索引中的位置，调用方需要持有 filesLock 的读锁，避免 reload 同时替换索引
+func (db *DB) indexGet(key []byte) *data.LogRecordPos {
+	db.indexLock.RLock()
+	defer db.indexLock.RUnlock()
+	return db.index.Get(key)
+}
+
+// newIndexIterator 根据遍历的配置创建索引迭代器，返回的迭代器按照不确定的顺序遍历时 unordered 为 true
+// 在 filesLock 和 indexLock 的读锁中创建，复制索引的迭代器不会看到只更新了一部分的批量写入
+func (db *DB) newIndexIterator(opts IteratorOptions) (iter index.Iterator, unordered bool) {
+	db.filesLock.RLock()
+	defer db.filesLock.RUnlock()
+	db.indexLock.RLock()
+	defer db.indexLock.RUnlock()
+	if unorderedIndexer, ok := db.index.(index.UnorderedIndexer); ok && opts.Unordered {
+		return unorderedIndexer.UnorderedIterator(opts.Reverse), true
+	}
+	// 索引支持按照前缀遍历时只取出前缀匹配的 key
+	if prefixIndexer, ok := db.index.(index.PrefixIndexer); ok && len(opts.Prefix) > 0 {
+		return prefixIndexer.PrefixIterator(opts.Prefix, opts.Reverse), false
+	}
+	return db.index.Iterator(opts.Reverse), false
+}
+
+// applyIndexOps 依次执行一批索引更新，返回被覆盖或者删除的旧位置，同时统计失效数据的大小
+// B+ 树索引在一个事务中完成整批更新，checkpoint 为 true 时在同一个事务中记录索引覆盖到的日志位置和事务序列号，调用方需要持有 db.mu
+func (db *DB) applyIndexOps(ops []index.BatchOp, checkpoint bool) ([]*data.LogRecordPos, error) {
+	var oldPositions []*data.LogRecordPos
+	if bpt, ok := db.index.(*index.BPlusTree); ok {
+		var cp *index.Checkpoint
+		if checkpoint {
+			cp = db.indexCheckpoint()
+		}
+		var err error
+		if oldPositions, err = bpt.ApplyBatch(ops, cp); err != nil {
+			return nil, err
+		}
+	} else {
+		oldPositions = db.publishIndexOps(ops)
+	}
+	for i, op := range ops {
+		// 删除记录本身也是失效数据
+		if op.Delete {
+			atomic.AddInt64(&db.reclaimSize, int64(op.Pos.Size))
+		}
+		if oldPositions[i] != nil {
+			atomic.AddInt64(&db.reclaimSize, int64(oldPositions[i].Size))
+			db.evictValueCache(oldPositions[i])
+		}
+	}
+	return oldPositions, nil
+}
+
+// indexCheckpoint 返回当前日志的末尾位置，之前写入的记录都已经更新到索引中，调用方需要持有 db.mu
+func (db *DB) indexCheckpoint() *index.Checkpoint {
+	if db.activeFile == nil || db.activeFile.Header == nil {
+		return nil
+	}
+	return &index.Checkpoint{
+		Fid:           db.activeFile.FileId,
+		Offset:        db.activeFile.WriteOff,
+		FileCreatedAt: db.activeFile.Header.CreatedAt.UnixNano(),
+		SeqNo:         atomic.LoadUint64(&db.seqNo),
+		MergeFid:      db.indexMergeFid,
+	}
+}
+
 func Open(options Options) (*DB, error) {
+	options = fillDefaultOptions(options)
 	// 配置项校验
 	if err := checkOptions(options); err != nil {
 		return nil, err
 	}
+	vfs := options.VFS
+	if vfs == nil {
+		vfs = fio.OSFS{}
+	}
+	if options.InMemory {
+		vfs = fio.NewMemFS()
+		if len(options.DirPath) == 0 {
+			options.DirPath = memoryDirPath
+		}
+	}
+	return open(options, vfs)
+}
 
+// open 在 vfs 中打开数据库，merge 使用的临时实例和数据库共用同一个文件系统
+func open(options Options, vfs fio.VFS) (*DB, error) {
 	var isInitial bool
 
 	// 判断目录地址是否存在
-	if _, err := os.Stat(options.DirPath); err != nil {
+	if _, err := vfs.Stat(options.DirPath); err != nil {
 		isInitial = true
-		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+		if err := vfs.MkdirAll(options.DirPath); err != nil {
 			return nil, err
 		}
 
 	}
 
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	hold, err := fileLock.TryLock()
+	fileLock, err := vfs.Lock(filepath.Join(options.DirPath, fileLockName))
 	if err != nil {
+		if errors.Is(err, fio.ErrLocked) {
+			return nil, ErrDatabaseIsUsing
+		}
 		return nil, err
 	}
-	if !hold {
-		return nil, ErrDatabaseIsUsing
-	}
 
-	entries, err := os.ReadDir(options.DirPath)
+	entries, err := vfs.ReadDir(options.DirPath)
 	if err != nil {
+		_ = fileLock.Unlock()
 		return nil, err
 	}
 	if len(entries) == 0 {
 		isInitial = true
 	}
+	idx, err := newIndexer(options)
+	if err != nil {
+		_ = fileLock.Unlock()
+		return nil, err
+	}
 
 	db := &DB{
-		options:    options,
-		mu:         new(sync.RWMutex),
-		activeFile: nil,
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType, options.DirPath),
-		isInitial:  isInitial,
-		fileLock:   fileLock,
+		options:      options,
+		mu:           new(sync.RWMutex),
+		filesLock:    new(sync.RWMutex),
+		writeLock:    new(sync.RWMutex),
+		keyLocks:     make([]sync.Mutex, keyLockStripes),
+		indexLock:    new(sync.RWMutex),
+		activeFile:   nil,
+		olderFiles:   make(map[uint32]*data.DataFile),
+		index:        idx,
+		isInitial:    isInitial,
+		fileLock:     fileLock,
+		vfs:          vfs,
+		bgWg:         new(sync.WaitGroup),
+		fingerprint:  optionsFingerprint(options),
+		snapshotLock: new(sync.Mutex),
+		backupLock:   new(sync.RWMutex),
+		closeCh:      make(chan struct{}),
+		mergeLimiter: utils.NewRateLimiter(options.MergeRateLimit),
 	}
-	if err := db.loadMergeFiles(); err != nil {
+	// 只在启动时删除上次异常退出时遗留的 PutStream 临时文件，merge 之后 reload 时可能有正在写入的临时文件
+	err = db.removeStreamFiles()
+	if err == nil {
+		err = db.load()
+	}
+	if err != nil {
+		// 启动失败时释放已经打开的文件和目录锁，之后可以重新打开
+		db.closeDataFiles()
+		_ = db.index.Close()
+		_ = fileLock.Unlock()
 		return nil, err
 	}
+	db.startIndexSnapshotLoop()
+
+	return db, nil
+}
+
+// newIndexer 根据配置创建内存索引，B+ 树索引不分片
+func newIndexer(options Options) (index.Indexer, error) {
+	shards := options.IndexShards
+	if options.IndexType == index.BPTree {
+		shards = 1
+	}
+	return index.NewShardedIndexer(options.IndexType, options.DirPath, options.SyncWrites, shards)
+}
+
+// load 加载数据文件和索引
+func (db *DB) load() error {
+	options := db.options
+	if options.ValueCacheSize > 0 {
+		db.valueCache = cache.NewValueCache(options.ValueCacheSize, options.ValueCacheShards)
+	}
+	if options.BlockCacheSize > 0 {
+		db.blockCache = fio.NewBlockCache(options.BlockCacheSize, fio.DefaultBlockSize)
+	}
+	if err := db.loadRecycledFiles(); err != nil {
+		return err
+	}
+	if err := db.loadMergeFiles(); err != nil {
+		return err
+	}
+	// 转换旧版本格式的数据文件
+	if err := db.upgradeDataFiles(); err != nil {
+		return err
+	}
 	// 加载数据文件信息
 	if err := db.loadDataFile(); err != nil {
-		return nil, err
-	}
-	if err := db.loadIndexFromHintFile(); err != nil {
-		return nil, err
+		return err
 	}
-	if options.IndexType != index.BPTree {
-		// 加载索引信息（和文件信息对应）
-		if err := db.loadIndexFromDataFiles(); err != nil {
-			return nil, err
-		}
-		if db.options.MMapAtStartup {
-			if err := db.resetIOType(); err != nil {
-				return nil, err
-			}
+	if options.IndexType == index.BPTree {
+		// B+ 树索引持久化在磁盘上，只需要重放 checkpoint 之后写入的记录
+		if err := db.loadBPlusTreeIndex(); err != nil {
+			return err
 		}
 	} else {
-		if err := db.loadSeqNo(); err != nil {
-			return nil, err
+		// 加载索引快照，没有可用的快照时从 merge 生成的 hint 文件中加载
+		loaded, err := db.loadIndexSnapshot()
+		if err != nil {
+			return err
 		}
-		if db.activeFile != nil {
-			size, err := db.activeFile.IoManager.Size()
-			if err != nil {
-				return nil, err
+		if !loaded {
+			if err := db.loadIndexFromHintFile(); err != nil {
+				return err
 			}
-			db.activeFile.WriteOff = size
 		}
 	}
+	// 加载索引信息（和文件信息对应）
+	if err := db.loadIndexFromDataFiles(); err != nil {
+		return err
+	}
+	if db.options.MMapAtStartup && db.options.FileIOType != fio.MemoryMap && db.options.IOManagerFactory == nil && db.onLocalDisk() {
+		if err := db.resetIOType(); err != nil {
+			return err
+		}
+	}
+	if err := db.truncateActiveFileTail(); err != nil {
+		return err
+	}
+	if err := db.loadDiskUsage(); err != nil {
+		return err
+	}
+	return nil
+}
 
-	return db, nil
+// reload 关闭所有数据文件，重新加载数据文件和索引，调用方不能持有 db.mu
+func (db *DB) reload() error {
+	db.backupLock.Lock()
+	defer db.backupLock.Unlock()
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.filesLock.Lock()
+	defer db.filesLock.Unlock()
+	db.closeDataFiles()
+	if err := db.index.Close(); err != nil {
+		return err
+	}
+	db.activeFile = nil
+	db.olderFiles = make(map[uint32]*data.DataFile)
+	db.fileIds = nil
+	idx, err := newIndexer(db.options)
+	if err != nil {
+		return err
+	}
+	db.index = idx
+	atomic.StoreInt64(&db.reclaimSize, 0)
+	db.recycledFiles = nil
+	db.snapshotPos = nil
+	db.lastSnapshotPos = nil
+	return db.load()
+}
+
+// onLocalDisk 判断数据目录是否直接保存在本地磁盘上，只有这时才能预分配文件、检查磁盘剩余空间
+func (db *DB) onLocalDisk() bool {
+	_, ok := db.vfs.(fio.OSFS)
+	return ok
+}
+
+// closeDataFiles 关闭所有已经打开的数据文件，忽略错误
+func (db *DB) closeDataFiles() {
+	if db.activeFile != nil {
+		_ = db.activeFile.Close()
+	}
+	for _, file := range db.olderFiles {
+		_ = file.Close()
+	}
 }
 
 func (db *DB) ListKeys() [][]byte {
-	iterator := db.index.Iterator(false)
+	db.filesLock.RLock()
+	size := db.index.Size()
+	db.filesLock.RUnlock()
+	iterator, _ := db.newIndexIterator(DefaultIteratorOptions)
 	defer iterator.Close()
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	// 遍历期间可能有并发的写入，key 的数量不一定等于之前读到的 Size
+	keys := make([][]byte, 0, size)
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
@@ -244,22 +671,48 @@ func (db *DB) Fold(fn func(key, value []byte) bool) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	iterator := db.index.Iterator(false)
+	iterator, _ := db.newIndexIterator(DefaultIteratorOptions)
 	defer iterator.Close()
-	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		value, err := db.getValueByPosition(iterator.Value())
+	// 每次攒够一批位置之后批量读取 value
+	var keys [][]byte
+	var positions []*data.LogRecordPos
+	var batchBytes uint64
+	flush := func() (bool, error) {
+		values, err := db.getValuesByPositions(positions)
 		if err != nil {
-			return err
+			return false, err
 		}
-		key := iterator.Key()
-		if !fn(key, value) {
-			break
+		for i, key := range keys {
+			if !fn(key, values[i]) {
+				return false, nil
+			}
 		}
+		keys, positions, batchBytes = keys[:0], positions[:0], 0
+		return true, nil
 	}
-	return nil
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		keys = append(keys, iterator.Key())
+		positions = append(positions, pos)
+		batchBytes += pos.Size
+		if len(positions) >= foldBatchSize || batchBytes >= foldBatchBytes {
+			if ok, err := flush(); !ok {
+				return err
+			}
+		}
+	}
+	_, err := flush()
+	return err
 }
 
 func (db *DB) Close() error {
+	// 通知后台任务退出，等待后台触发的 merge 结束
+	select {
+	case <-db.closeCh:
+	default:
+		close(db.closeCh)
+	}
+	db.bgWg.Wait()
 	defer func() {
 		if err := db.fileLock.Unlock(); err != nil {
 			panic(fmt.Sprintf("failed to unlock the directory, %v", err))
@@ -271,11 +724,18 @@ func (db *DB) Close() error {
 	if db.activeFile == nil {
 		return nil
 	}
+	db.snapshotLock.Lock()
+	defer db.snapshotLock.Unlock()
+	// 等待正在更新索引的写入完成
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	db.filesLock.Lock()
+	defer db.filesLock.Unlock()
 
 	if db.seqNo > 0 {
-		seqDataFile, err := data.OpenSeqNoFile(db.options.DirPath)
+		seqDataFile, err := data.OpenSeqNoFile(db.vfs, db.options.DirPath)
 		if err != nil {
 			return err
 		}
@@ -295,6 +755,15 @@ func (db *DB) Close() error {
 		}
 	}
 
+	// 保存索引快照，下次启动时只需要重放快照之后写入的记录
+	if db.indexSnapshotEnabled() {
+		if buf, pos := db.encodeIndexSnapshot(); buf != nil {
+			if err := db.saveIndexSnapshot(buf, pos); err != nil {
+				return err
+			}
+		}
+	}
+
 	err := db.activeFile.Close()
 	if err != nil {
 		return err
@@ -313,7 +782,14 @@ func (db *DB) Sync() error {
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.activeFile.Sync()
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+	// 没有开启同步写入时 B+ 树索引的事务同样没有持久化
+	if bpt, ok := db.index.(*index.BPlusTree); ok {
+		return bpt.Sync()
+	}
+	return nil
 }
 
 func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
@@ -328,45 +804,111 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 			return nil, err
 		}
 	}
-	// 一条记录写入文件中，需要对该记录先进行编码操作
-	encRecord, size := data.EncodeLogRecord(logRecord)
-
-	// 特殊判断：该记录写入当前文件大于配置文件大小，需要重新生成一个新的文件。
-	if db.activeFile.WriteOff+size > db.options.DataFileSize {
-		//
-		if err := db.activeFile.Sync(); err != nil {
+	// merge 等复制已有记录的场景保留原来的写入时间
+	if db.options.RecordTimestamp && logRecord.Timestamp == 0 {
+		logRecord.Timestamp = time.Now().UnixNano()
+	}
+	// 一条记录写入文件中，需要对该记录先进行编码操作，校验算法和格式版本和活跃文件头部中记录的保持一致
+	encRecord, size := db.activeFile.EncodeLogRecord(logRecord)
+	// 删除记录和事务完成记录不受配额限制，保证超出配额后依然可以删除数据
+	if logRecord.Type == data.LogRecordNormal {
+		if err := db.checkDiskQuota(size); err != nil {
 			return nil, err
 		}
+	}
 
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
-
-		if err := db.setActiveDataFile(); err != nil {
+	// 特殊判断：当前文件写满、存在时间过长或者记录数过多时，需要重新生成一个新的文件。
+	if db.needRotate(size) {
+		if err := db.rotateActiveFile(); err != nil {
 			return nil, err
 		}
+		// 新文件可能使用了不同的校验算法和格式版本
+		encRecord, size = db.activeFile.EncodeLogRecord(logRecord)
 	}
 	// 当前文件的偏移值开始写
 	writeOff := db.activeFile.WriteOff
 	if err := db.activeFile.Write(encRecord); err != nil {
 		return nil, err
 	}
-
-	db.bytesWrite += uint(size)
-	var needSync = db.options.SyncWrites
-	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
-		needSync = true
+	if err := db.afterAppend(size); err != nil {
+		return nil, err
 	}
+	// 返回记录所对应的文件信息
+	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: uint64(size)}
+	return pos, nil
+}
 
-	if needSync {
-		if err := db.activeFile.Sync(); err != nil {
+// appendLogRecords 依次追加一批记录，调用方需要持有 db.mu
+// 写入同一个活跃文件的记录合并为一次批量写入，活跃文件支持批量写入时，最后的持久化和写入在一次提交中完成
+func (db *DB) appendLogRecords(logRecords []*data.LogRecord, sync bool) ([]*data.LogRecordPos, error) {
+	if db.activeFile == nil {
+		if err := db.setActiveDataFile(); err != nil {
 			return nil, err
 		}
-		if db.bytesWrite > 0 {
-			db.bytesWrite = 0
+	}
+	positions := make([]*data.LogRecordPos, len(logRecords))
+	bufs := make([][]byte, 0, len(logRecords))
+	writeOff := db.activeFile.WriteOff
+	for i, logRecord := range logRecords {
+		if db.options.RecordTimestamp && logRecord.Timestamp == 0 {
+			logRecord.Timestamp = time.Now().UnixNano()
 		}
+		encRecord, size := db.activeFile.EncodeLogRecord(logRecord)
+		if logRecord.Type == data.LogRecordNormal {
+			if err := db.checkDiskQuota(size); err != nil {
+				return nil, err
+			}
+		}
+		// 轮转之前先写入已经编码的记录
+		if db.needRotateAt(writeOff, size) {
+			if err := db.activeFile.WriteBatch(bufs, false); err != nil {
+				return nil, err
+			}
+			bufs = bufs[:0]
+			if err := db.rotateActiveFile(); err != nil {
+				return nil, err
+			}
+			writeOff = db.activeFile.WriteOff
+			encRecord, size = db.activeFile.EncodeLogRecord(logRecord)
+		}
+		bufs = append(bufs, encRecord)
+		positions[i] = &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: uint64(size)}
+		writeOff += size
+		db.countAppend(size)
 	}
-	// 返回记录所对应的文件信息
-	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: uint32(size)}
-	return pos, nil
+
+	sync = sync || db.needSync()
+	if err := db.activeFile.WriteBatch(bufs, sync); err != nil {
+		return nil, err
+	}
+	if sync {
+		db.bytesWrite = 0
+	}
+	return positions, nil
+}
+
+// afterAppend 更新写入一条 size 字节的记录之后的统计信息，并按照配置持久化活跃文件
+func (db *DB) afterAppend(size int64) error {
+	db.countAppend(size)
+	if db.needSync() {
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+		db.bytesWrite = 0
+	}
+	return nil
+}
+
+// countAppend 更新追加写入 size 字节之后的统计信息
+func (db *DB) countAppend(size int64) {
+	db.activeFileRecords++
+	db.bytesWrite += uint(size)
+	db.addDiskUsage(size)
+}
+
+// needSync 根据配置判断是否需要持久化活跃文件
+func (db *DB) needSync() bool {
+	return db.options.SyncWrites || (db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync)
 }
 
 func (db *DB) setActiveDataFile() error {
@@ -375,18 +917,111 @@ func (db *DB) setActiveDataFile() error {
 	if db.activeFile != nil {
 		initialFileId = db.activeFile.FileId + 1
 	}
+	fileName := data.GetDataFileName(db.options.DirPath, initialFileId)
+	if db.options.RecycleDataFiles {
+		if err := db.reuseRecycledFile(fileName, initialFileId); err != nil {
+			return err
+		}
+	}
+	if db.options.PreallocateDataFile && db.onLocalDisk() {
+		if err := fio.Preallocate(fileName, db.options.DataFileSize); err != nil {
+			return err
+		}
+	}
 	// 创建新的文件，返回相关结构体
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StandardFIO)
+	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, db.options.FileIOType, db.fileOptions())
 	if err != nil {
 		return err
 	}
+	// 复用的回收文件在文件头部之后都是 0，从文件头部之后开始写入
+	if err := dataFile.ResetTail(true); err != nil {
+		_ = dataFile.Close()
+		return err
+	}
+	db.setBlockCache(dataFile)
+	db.addDiskUsage(data.FileHeaderSize)
+	db.filesLock.Lock()
 	db.activeFile = dataFile
+	db.filesLock.Unlock()
+	db.activeFileCreatedAt = time.Now()
+	db.activeFileRecords = 0
+	return nil
+}
+
+// fileOptions 返回创建新数据文件时写入文件头部的信息
+func (db *DB) fileOptions() data.FileOptions {
+	return data.FileOptions{
+		Fingerprint:      db.fingerprint,
+		Checksum:         db.options.Checksum,
+		IOManagerFactory: db.options.IOManagerFactory,
+		VFS:              db.vfs,
+	}
+}
+
+// setBlockCache 为数据文件设置共享的块缓存
+func (db *DB) setBlockCache(dataFile *data.DataFile) {
+	if db.blockCache != nil {
+		dataFile.SetBlockCache(db.blockCache, db.options.ReadAheadSize)
+	}
+}
+
+// needRotate 判断写入 size 字节的记录之前是否需要轮转活跃文件
+func (db *DB) needRotate(size int64) bool {
+	return db.needRotateAt(db.activeFile.WriteOff, size)
+}
+
+// needRotateAt 判断活跃文件写到 writeOff 之后，再写入 size 字节的记录之前是否需要轮转
+func (db *DB) needRotateAt(writeOff int64, size int64) bool {
+	// 空文件不需要轮转，超过文件大小阈值的大记录直接写入空文件中
+	if writeOff <= data.FileHeaderSize {
+		return false
+	}
+	if writeOff+size > db.options.DataFileSize {
+		return true
+	}
+	if db.options.DataFileMaxAge > 0 && time.Since(db.activeFileCreatedAt) >= db.options.DataFileMaxAge {
+		return true
+	}
+	return db.options.DataFileMaxRecords > 0 && db.activeFileRecords >= db.options.DataFileMaxRecords
+}
+
+// rotateActiveFile 持久化并封存当前活跃文件，然后打开一个新的活跃文件，调用方需要持有 db.mu
+func (db *DB) rotateActiveFile() error {
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+	sealedFile := db.activeFile
+	sealedFile.Seal()
+	// 去掉 Direct I/O 和内存映射在文件末尾填充的部分，封存的文件长度和写入的数据一致
+	if err := sealedFile.Truncate(sealedFile.WriteOff); err != nil {
+		return err
+	}
+	db.filesLock.Lock()
+	db.olderFiles[sealedFile.FileId] = sealedFile
+	db.filesLock.Unlock()
+	if err := db.setActiveDataFile(); err != nil {
+		return err
+	}
+	// B+ 树索引持久化在磁盘中，启动时不需要 hint 文件
+	if !db.skipHintFiles && db.options.IndexType != index.BPTree {
+		db.buildHintFileAsync(sealedFile)
+	}
 	return nil
 }
 
+// RotateActiveFile 立即封存当前活跃文件，之后的写入进入新的数据文件，适合在备份或者 merge 之前调用
+func (db *DB) RotateActiveFile() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.activeFile == nil || db.activeFile.WriteOff <= data.FileHeaderSize {
+		return nil
+	}
+	return db.rotateActiveFile()
+}
+
 func (db *DB) loadDataFile() error {
 	// 读取对应目录下的文件集
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+	dirEntries, err := db.vfs.ReadDir(db.options.DirPath)
 	if err != nil {
 		return err
 	}
@@ -410,21 +1045,32 @@ func (db *DB) loadDataFile() error {
 		return fileIds[i] < fileIds[j]
 	})
 	db.fileIds = fileIds
+	db.mismatchedFiles = nil
 	// 遍历文件id，区分历史文件id和正在写入文件id
 	for i, fid := range fileIds {
-		ioType := fio.StandardFIO
+		ioType := db.options.FileIOType
 		if db.options.MMapAtStartup {
 			ioType = fio.MemoryMap
 		}
-		datafile, err := data.OpenDataFile(db.options.DirPath, fid, ioType)
+		datafile, err := data.OpenDataFile(db.options.DirPath, fid, ioType, db.fileOptions())
 		if err != nil {
 			return err
 		}
+		db.setBlockCache(datafile)
 		if i == len(fileIds)-1 {
 			db.activeFile = datafile
+			db.activeFileCreatedAt = time.Now()
 		} else {
+			datafile.Seal()
 			db.olderFiles[fid] = datafile
 		}
+		// 旧版本的文件没有配置指纹
+		if header := datafile.Header; header != nil && header.Version != data.LegacyFileVersion && header.Fingerprint != db.fingerprint {
+			db.mismatchedFiles = append(db.mismatchedFiles, fid)
+		}
+	}
+	if len(db.mismatchedFiles) > 0 && db.options.StrictFingerprint {
+		return fmt.Errorf("%w: data file %d", ErrOptionsMismatch, db.mismatchedFiles[0])
 	}
 	return nil
 }
@@ -436,7 +1082,7 @@ func (db *DB) loadIndexFromDataFiles() error {
 
 	hasMerge, nonMergeFileId := false, uint32(0)
 	mergeFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
-	if _, err := os.Stat(mergeFileName); err == nil {
+	if _, err := db.vfs.Stat(mergeFileName); err == nil {
 		fid, err := db.getNonMergeFileId(db.options.DirPath)
 		if err != nil {
 			return err
@@ -445,90 +1091,146 @@ func (db *DB) loadIndexFromDataFiles() error {
 		hasMerge = true
 	}
 
+	_, isBPTree := db.index.(*index.BPlusTree)
+	var pendingOps []index.BatchOp
 	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+		// B+ 树索引每个数据文件中的更新在一个事务中完成
+		if isBPTree {
+			pendingOps = append(pendingOps, index.BatchOp{Key: key, Pos: pos, Delete: typ == data.LogRecordDeleted})
+			return
+		}
 		var oldPos *data.LogRecordPos
 		//根据类型对记录进行相对应处理
 		if typ == data.LogRecordDeleted {
 			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size)
+			atomic.AddInt64(&db.reclaimSize, int64(pos.Size))
 		} else {
 			oldPos = db.index.Put(key, pos)
 		}
 		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
+			atomic.AddInt64(&db.reclaimSize, int64(oldPos.Size))
 		}
 
 	}
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
-	var currentSeqNo uint64 = nonTransactionSeqNo
-	// 遍历文件id
-	for i, fid := range db.fileIds {
+	// 加载了索引快照时从快照中保存的序列号开始
+	var currentSeqNo = db.seqNo
+	applyRecord := func(logRecord *data.LogRecord, logRecordPos *data.LogRecordPos) error {
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		if seqNo == nonTransactionSeqNo {
+			updateIndex(realKey, logRecord.Type, logRecordPos)
+		} else {
+			if logRecord.Type == data.LogRecordFinished {
+				for _, txnRecord := range transactionRecords[seqNo] {
+					updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+				}
+				delete(transactionRecords, seqNo)
+			} else {
+				transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
+					Record: &data.LogRecord{Key: realKey, Type: logRecord.Type},
+					Pos:    logRecordPos,
+				})
+			}
+		}
+		if seqNo > nonTransactionSeqNo {
+			currentSeqNo = seqNo
+		}
+		return nil
+	}
+	// 需要加载的数据文件，最后一个是活跃文件
+	var dataFiles []*data.DataFile
+	var totalBytes int64
+	for _, fid := range db.fileIds {
 		if hasMerge && fid < nonMergeFileId {
 			continue
 		}
-		var dataFile *data.DataFile
-		// 根据id获取对应的结构体
-		if fid == db.activeFile.FileId {
-			dataFile = db.activeFile
-		} else {
-			dataFile = db.olderFiles[fid]
+		// 索引快照已经覆盖了之前的数据文件
+		if db.snapshotPos != nil && fid < db.snapshotPos.fid {
+			continue
 		}
+		dataFile, err := db.getDataFile(fid)
+		if err != nil {
+			return err
+		}
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		dataFiles = append(dataFiles, dataFile)
+		totalBytes += size
+	}
 
-		var offset int64 = 0
-		// 每一个文件中记录写入索引树中
-		for {
-			// 获取文件中的记录信息
-			logRecord, size, err := dataFile.GetLogRecord(offset)
-			// 截止条件：读到文件末尾
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return err
+	// 并行解码数据文件，按照文件顺序将结果写入索引，保证事务记录的处理顺序和顺序扫描时一致
+	decoder := db.decodeDataFiles(dataFiles)
+	defer decoder.close()
+	progress := IndexLoadProgress{TotalFiles: len(dataFiles), TotalBytes: totalBytes}
+	for i, dataFile := range dataFiles {
+		result := decoder.wait(i)
+		if result.err != nil {
+			return result.err
+		}
+		for _, entry := range result.entries {
+			if db.snapshotPos != nil && entry.pos.Fid == db.snapshotPos.fid && entry.pos.Offset < db.snapshotPos.offset {
+				continue
 			}
-			// 获取记录对应文件信息
-			logRecordPos := &data.LogRecordPos{
-				Fid:    fid,
-				Offset: offset,
-				Size:   uint32(size),
+			_ = applyRecord(&data.LogRecord{Key: entry.key, Type: entry.typ}, entry.pos)
+		}
+		if len(pendingOps) > 0 {
+			if _, err := db.applyIndexOps(pendingOps, false); err != nil {
+				return err
 			}
+			pendingOps = pendingOps[:0]
+		}
+		if i == len(dataFiles)-1 {
+			db.activeFile.SetWriteOff(result.offset)
+			db.activeFileRecords = result.records
+		}
+		decoder.release(i)
 
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
-				updateIndex(realKey, logRecord.Type, logRecordPos)
-			} else {
-				if logRecord.Type == data.LogRecordFinished {
-					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
-					}
-					delete(transactionRecords, seqNo)
-				} else {
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
-				}
-			}
-			if seqNo > nonTransactionSeqNo {
-				currentSeqNo = seqNo
-			}
-			offset += size
+		progress.LoadedFiles++
+		if size, err := dataFile.IoManager.Size(); err == nil {
+			progress.LoadedBytes += size
 		}
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+		if db.options.OnIndexLoadProgress != nil {
+			db.options.OnIndexLoadProgress(progress)
+		}
+	}
+	db.seqNo = currentSeqNo
+	// 重放完成之后更新 checkpoint，下次启动时不需要再次重放
+	if isBPTree {
+		if _, err := db.applyIndexOps(nil, true); err != nil {
+			return err
 		}
-		db.seqNo = currentSeqNo
 	}
 	return nil
 }
 
+// truncateActiveFileTail 活跃文件尾部存在全 0 的数据时将其截断，保证追加写入紧接在最后一条有效记录之后
+func (db *DB) truncateActiveFileTail() error {
+	if db.activeFile == nil {
+		return nil
+	}
+	size, err := db.activeFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if size <= db.activeFile.WriteOff {
+		return nil
+	}
+	// 之后的位置会被重新写入，之前缓存的块不再有效
+	if db.blockCache != nil {
+		db.blockCache.RemoveFile(db.activeFile.FileId)
+	}
+	// 复用的回收文件清零尾部并保留磁盘空间，其他文件直接截断
+	return db.activeFile.ResetTail(false)
+}
+
 func (db *DB) loadSeqNo() error {
 	fileName := filepath.Join(db.options.DirPath, data.SeqNoFileName)
-	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+	if _, err := db.vfs.Stat(fileName); errors.Is(err, fs.ErrNotExist) {
 		return nil
 	}
-	file, err := data.OpenSeqNoFile(db.options.DirPath)
+	file, err := data.OpenSeqNoFile(db.vfs, db.options.DirPath)
 	if err != nil {
 		return err
 	}
@@ -540,20 +1242,56 @@ func (db *DB) loadSeqNo() error {
 	if err != nil {
 		return err
 	}
-	db.seqNoFileExists = true
-	db.seqNo = seqNo
-	return os.Remove(fileName)
+	if seqNo > db.seqNo {
+		db.seqNo = seqNo
+	}
+	return db.vfs.Remove(fileName)
+}
+
+// loadBPlusTreeIndex 读取 B+ 树索引的 checkpoint，之后只需要重放 checkpoint 之后的记录
+// 没有 checkpoint、还没有包含最近一次 merge 的结果，或者 checkpoint 超出了数据文件（没有同步写入时崩溃）时重建索引
+func (db *DB) loadBPlusTreeIndex() error {
+	bpt := db.index.(*index.BPlusTree)
+	checkpoint, err := bpt.Checkpoint()
+	if err != nil {
+		return err
+	}
+	// 兼容关闭时写入的序列号文件
+	if err := db.loadSeqNo(); err != nil {
+		return err
+	}
+	var nonMergeFileId uint32
+	if _, err := db.vfs.Stat(filepath.Join(db.options.DirPath, data.MergeFinishedFileName)); err == nil {
+		if nonMergeFileId, err = db.getNonMergeFileId(db.options.DirPath); err != nil {
+			return err
+		}
+	}
+	if checkpoint != nil && checkpoint.SeqNo > db.seqNo {
+		db.seqNo = checkpoint.SeqNo
+	}
+	db.indexMergeFid = nonMergeFileId
+	if checkpoint != nil && checkpoint.MergeFid == nonMergeFileId {
+		pos := &indexSnapshotPos{fid: checkpoint.Fid, offset: checkpoint.Offset, fileCreatedAt: checkpoint.FileCreatedAt}
+		if db.validSnapshotPos(pos) {
+			db.snapshotPos = pos
+			return nil
+		}
+	}
+	if err := bpt.Reset(); err != nil {
+		return err
+	}
+	return db.loadIndexFromHintFile()
 }
 
 func (db *DB) resetIOType() error {
 	if db.activeFile == nil {
 		return nil
 	}
-	if err := db.activeFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+	if err := db.activeFile.SetIOManager(db.vfs, db.options.DirPath, db.options.FileIOType); err != nil {
 		return err
 	}
 	for _, dataFile := range db.olderFiles {
-		if err := dataFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+		if err := dataFile.SetIOManager(db.vfs, db.options.DirPath, db.options.FileIOType); err != nil {
 			return err
 		}
 	}
@@ -568,20 +1306,128 @@ func (db *DB) Stat() *Stat {
 	if db.activeFile != nil {
 		dataFileNum += 1
 	}
-	dirSize, err := utils.DirSize(db.options.DirPath)
+	dirSize, err := fio.DirSize(db.vfs, db.options.DirPath)
 	if err != nil {
 		panic(fmt.Sprintf("failed to get dir size :%v", err))
 	}
-	return &Stat{
+	stat := &Stat{
 		keyNum:          uint(db.index.Size()),
 		DataFileNum:     dataFileNum,
-		reclaimableSize: db.reclaimSize,
+		reclaimableSize: atomic.LoadInt64(&db.reclaimSize),
 		DiskSize:        dirSize,
+		MismatchedFiles: db.mismatchedFiles,
+	}
+	if db.valueCache != nil {
+		stat.ValueCacheHits, stat.ValueCacheMisses = db.valueCache.Stats()
 	}
+	if db.blockCache != nil {
+		stat.BlockCacheHits, stat.BlockCacheMisses = db.blockCache.Stats()
+	}
+	if reporter, ok := db.index.(index.MemoryReporter); ok {
+		stat.IndexMemory = reporter.MemoryUsage()
+	}
+	return stat
 }
 
+// Backup 将数据库备份到 dir 中，备份包含开始时已经写入的数据，复制期间不阻塞写入
 func (db *DB) Backup(dir string) error {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
+	// 复制期间 merge 之后的 reload 等待备份完成，不会删除还没有复制的文件
+	db.backupLock.RLock()
+	defer db.backupLock.RUnlock()
+	entries, bptSnapshot, err := db.backupFiles()
+	if err != nil {
+		return err
+	}
+	if err := db.vfs.MkdirAll(dir); err != nil {
+		if bptSnapshot != nil {
+			_ = bptSnapshot.Close()
+		}
+		return err
+	}
+	// 打开的只读事务会阻塞 B+ 树索引文件扩容，先不限速写出索引，尽快结束事务
+	if bptSnapshot != nil {
+		err := db.backupBPlusTreeIndex(bptSnapshot, filepath.Join(dir, index.BPlusTreeIndexFileName))
+		_ = bptSnapshot.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// 复制时不持有 db.mu，按照 merge 的限速读写，不阻塞写入
+	for _, entry := range entries {
+		src, dest := filepath.Join(db.options.DirPath, entry.Name()), filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			err = utils.CopyDir(db.vfs, src, db.vfs, dest, nil, db.mergeLimiter)
+		} else {
+			err = utils.CopyFile(db.vfs, src, db.vfs, dest, db.mergeLimiter)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupFiles 封存活跃文件并返回备份需要复制的文件，封存之后这些文件中的数据不再变化
+// B+ 树索引文件会被并发的写入修改，返回此时索引的快照，和封存的数据文件对应
+func (db *DB) backupFiles() ([]fs.DirEntry, *index.BPlusTreeSnapshot, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.activeFile != nil && db.activeFile.WriteOff > data.FileHeaderSize {
+		if err := db.rotateActiveFile(); err != nil {
+			return nil, nil, err
+		}
+	}
+	dirEntries, err := db.vfs.ReadDir(db.options.DirPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 不复制文件锁、回收文件、写入中的临时文件、新的活跃文件以及单独写出的 B+ 树索引文件
+	exclude := []string{
+		fileLockName,
+		"*" + data.RecycleFileNameSuffix,
+		"*" + streamFileSuffix,
+		"*" + tmpFileSuffix,
+		index.BPlusTreeIndexFileName,
+	}
+	if db.activeFile != nil {
+		exclude = append(exclude, filepath.Base(data.GetDataFileName(db.options.DirPath, db.activeFile.FileId)))
+	}
+	var entries []fs.DirEntry
+	for _, entry := range dirEntries {
+		excluded := false
+		for _, pattern := range exclude {
+			if matched, _ := filepath.Match(pattern, entry.Name()); matched {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			entries = append(entries, entry)
+		}
+	}
+
+	var bptSnapshot *index.BPlusTreeSnapshot
+	if bpt, ok := db.index.(*index.BPlusTree); ok {
+		if bptSnapshot, err = bpt.Snapshot(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return entries, bptSnapshot, nil
+}
+
+// backupBPlusTreeIndex 将 B+ 树索引的快照写入备份目录
+func (db *DB) backupBPlusTreeIndex(snapshot *index.BPlusTreeSnapshot, dest string) error {
+	if err := db.vfs.Remove(dest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	destFile, err := db.vfs.OpenFile(dest, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+	if _, err := snapshot.WriteTo(destFile); err != nil {
+		return err
+	}
+	return destFile.Sync()
 }
@@ -1,10 +1,18 @@
 package bitcask_go
 
 import (
+	"bytes"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/fio"
+	"github.com/Tuanzi-bug/TuanKV/index"
 	"github.com/Tuanzi-bug/TuanKV/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // 测试完成之后销毁 DB 数据目录
@@ -34,6 +42,28 @@ func TestOpen(t *testing.T) {
 
 }
 
+func TestOpen_ZeroOptions(t *testing.T) {
+	// 不从 DefaultOptions 修改得到的配置，没有设置的配置项使用默认值
+	dir, _ := os.MkdirTemp("", "bitcask-go-zero-options")
+	opts := Options{
+		DirPath:        dir,
+		DataFileSize:   64 * 1024,
+		IndexType:      index.Btree,
+		MaxDiskBytes:   1024 * 1024,
+		ValueCacheSize: 1024 * 1024,
+	}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, runtime.NumCPU(), db.options.IndexLoadParallelism)
+	assert.Equal(t, DefaultOptions.ValueCacheShards, db.options.ValueCacheShards)
+	assert.Equal(t, DefaultOptions.DiskSoftWatermark, db.options.DiskSoftWatermark)
+	assert.Equal(t, DefaultOptions.DiskHardWatermark, db.options.DiskHardWatermark)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(64)))
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+}
+
 func TestDB_Put(t *testing.T) {
 	opts := DefaultOptions
 	dir, _ := os.MkdirTemp("", "bitcask-go-put")
@@ -383,3 +413,819 @@ func TestDB_Backup(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db2)
 }
+
+func TestDB_DiskQuota(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-disk-quota")
+	opts.DirPath = dir
+	opts.MaxDiskBytes = 1024 * 1024
+	opts.DataFileMergeRatio = 1
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 写满到硬水位之后写入失败
+	var i int
+	for ; i < 100000; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		if err != nil {
+			break
+		}
+	}
+	assert.Equal(t, ErrDiskQuotaExceeded, err)
+	assert.True(t, db.diskSize <= int64(float32(opts.MaxDiskBytes)*opts.DiskHardWatermark))
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	err = wb.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Equal(t, ErrDiskQuotaExceeded, err)
+
+	// 超过配额后依然可以删除数据
+	err = db.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	// 重启后配额依然生效
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	err = db2.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+	assert.Equal(t, ErrDiskQuotaExceeded, err)
+}
+
+func TestDB_DiskQuotaMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-disk-quota-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.MaxDiskBytes = 1024 * 1024
+	opts.DataFileMergeRatio = 0.5
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 写入不同的 key 直到超过硬水位，这时 merge 不能释放空间
+	var i int
+	for ; i < 100000; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		if err != nil {
+			break
+		}
+	}
+	assert.Equal(t, ErrDiskQuotaExceeded, err)
+	// 超过配额后依然可以删除，删除之后只保留 10 个 key
+	for j := 10; j < i; j++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(j)))
+	}
+
+	// merge 之后立即释放磁盘空间，不需要重新打开数据库（后台可能已经自动 merge 过）
+	for {
+		err = db.Merge()
+		if err != ErrMergeIsProgress {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, err == nil || err == ErrMergeRatioUnreached)
+	for atomic.LoadInt32(&db.quotaMerging) != 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	softLimit, _ := db.diskWatermarks()
+	assert.True(t, atomic.LoadInt64(&db.diskSize) < softLimit)
+	assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(1024)))
+	assert.Equal(t, 11, len(db.ListKeys()))
+
+	// PutStream 的临时文件同样计入配额，写入失败之后释放
+	used := atomic.LoadInt64(&db.diskSize)
+	err = db.PutStream([]byte("stream"), bytes.NewReader(utils.RandomValue(int(opts.MaxDiskBytes))))
+	assert.Equal(t, ErrDiskQuotaExceeded, err)
+	assert.Equal(t, used, atomic.LoadInt64(&db.diskSize))
+}
+
+func TestDB_RotateActiveFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-rotate")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 没有数据时不进行轮转
+	err = db.RotateActiveFile()
+	assert.Nil(t, err)
+	assert.Nil(t, db.activeFile)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+	err = db.RotateActiveFile()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(db.olderFiles))
+	assert.Equal(t, int64(data.FileHeaderSize), db.activeFile.WriteOff)
+
+	// 活跃文件为空时重复轮转不会产生新文件
+	err = db.RotateActiveFile()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(db.olderFiles))
+
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDB_RotateByRecordsAndAge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-rotate-policy")
+	opts.DirPath = dir
+	opts.DataFileMaxRecords = 100
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 按记录数轮转
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 9, len(db.olderFiles))
+
+	// 重启之后记录数依然生效
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.DataFileMaxRecords = 0
+	opts.DataFileMaxAge = 50 * time.Millisecond
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(100), db2.activeFileRecords)
+
+	// 按时间轮转
+	time.Sleep(60 * time.Millisecond)
+	err = db2.Put(utils.GetTestKey(1000), utils.RandomValue(24))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(db2.olderFiles))
+	assert.Equal(t, uint(1), db2.activeFileRecords)
+
+	for i := 0; i <= 1000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+}
+
+func TestDB_ValueCache(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-value-cache")
+	opts.DirPath = dir
+	opts.ValueCacheSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	val1 := utils.RandomValue(24)
+	err = db.Put(utils.GetTestKey(1), val1)
+	assert.Nil(t, err)
+
+	// 第一次读取未命中，第二次读取命中缓存
+	res1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, res1)
+	res2, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, res2)
+	stat := db.Stat()
+	assert.Equal(t, uint64(1), stat.ValueCacheHits)
+	assert.Equal(t, uint64(1), stat.ValueCacheMisses)
+
+	// 覆盖写之后读到新的值
+	val2 := utils.RandomValue(24)
+	err = db.Put(utils.GetTestKey(1), val2)
+	assert.Nil(t, err)
+	res3, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val2, res3)
+
+	// 删除之后缓存被淘汰
+	err = db.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, int64(0), db.valueCache.Size())
+}
+
+func TestDB_BlockCache(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-block-cache")
+	opts.DirPath = dir
+	opts.BlockCacheSize = 8 * 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	// 顺序写入的数据在全量扫描时大部分读取命中块缓存
+	var count int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		assert.NotNil(t, value)
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 10000, count)
+	stat := db.Stat()
+	assert.True(t, stat.BlockCacheHits > stat.BlockCacheMisses)
+
+	// 重启之后通过块缓存加载索引
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	for i := 0; i < 10000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+}
+
+func TestDB_Checksum(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checksum")
+	opts.DirPath = dir
+	opts.Checksum = data.ChecksumCRC32C
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, data.ChecksumCRC32C, db.activeFile.ChecksumType())
+
+	// 更换校验算法后重启，已有的文件沿用原来的算法，新文件使用新的算法
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.Checksum = data.ChecksumXXHash64
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, data.ChecksumCRC32C, db2.activeFile.ChecksumType())
+	err = db2.RotateActiveFile()
+	assert.Nil(t, err)
+	assert.Equal(t, data.ChecksumXXHash64, db2.activeFile.ChecksumType())
+	for i := 1000; i < 2000; i++ {
+		err := db2.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	err = db2.Close()
+	assert.Nil(t, err)
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, 2000, len(db3.ListKeys()))
+	for i := 0; i < 2000; i++ {
+		val, err := db3.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+}
+
+func TestDB_GetWithMeta(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-meta")
+	opts.DirPath = dir
+	opts.RecordTimestamp = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	before := time.Now()
+	putValue := utils.RandomValue(24)
+	err = db.Put(utils.GetTestKey(1), putValue)
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Nil(t, wb.Commit())
+	after := time.Now()
+
+	value, meta, err := db.GetWithMeta(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, putValue, value)
+	assert.Equal(t, nonTransactionSeqNo, meta.SeqNo)
+	assert.False(t, meta.Timestamp.Before(before))
+	assert.False(t, meta.Timestamp.After(after))
+	assert.True(t, meta.Size > int64(len(putValue)))
+
+	_, meta2, err := db.GetWithMeta(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.True(t, meta2.SeqNo > nonTransactionSeqNo)
+	assert.False(t, meta2.Timestamp.Before(meta.Timestamp))
+
+	_, _, err = db.GetWithMeta(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	iter := db.NewIterator(DefaultIteratorOptions)
+	count := 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		m, err := iter.Meta()
+		assert.Nil(t, err)
+		assert.False(t, m.Timestamp.IsZero())
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 2, count)
+
+	// 重启之后时间戳保持不变，关闭选项之后新写入的记录不带时间戳
+	assert.Nil(t, db.Close())
+	opts.RecordTimestamp = false
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	_, meta3, err := db2.GetWithMeta(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, meta.Timestamp.Equal(meta3.Timestamp))
+	err = db2.Put(utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+	_, meta4, err := db2.GetWithMeta(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, meta4.Timestamp.IsZero())
+}
+
+func TestDB_DirectIO(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-direct-io")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.FileIOType = fio.DirectIO
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 2000; i++ {
+		values[i] = utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	for i := 0; i < 2000; i += 3 {
+		value, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], value)
+	}
+
+	// 没有关闭时复制数据目录，活跃文件末尾带有填充的 0，启动时截断之后继续写入
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-direct-io-crash")
+	assert.Nil(t, db.Backup(backupDir))
+	opts2 := opts
+	opts2.DirPath = backupDir
+	db2, err := Open(opts2)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Nil(t, db2.Put([]byte("after-crash"), []byte("value")))
+	assert.Nil(t, db2.Close())
+	db2, err = Open(opts2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2001, len(db2.ListKeys()))
+	value, err := db2.Get([]byte("after-crash"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	// 正常关闭之后可以使用标准文件 IO 打开
+	assert.Nil(t, db.Close())
+	opts.FileIOType = fio.StandardFIO
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		value, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], value)
+	}
+}
+
+func TestDB_MMapIO(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-mmap-io")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.FileIOType = fio.MemoryMap
+	opts.MMapAtStartup = true
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 2000; i++ {
+		values[i] = utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Sync())
+
+	// 没有关闭时复制数据目录，活跃文件末尾带有扩展出来的 0，启动时截断之后继续写入
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-mmap-io-crash")
+	assert.Nil(t, db.Backup(backupDir))
+	opts2 := opts
+	opts2.DirPath = backupDir
+	db2, err := Open(opts2)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Nil(t, db2.Put([]byte("after-crash"), []byte("value")))
+	assert.Nil(t, db2.Close())
+	db2, err = Open(opts2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1501, len(db2.ListKeys()))
+
+	// 重启之后继续使用内存映射读写
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("after-restart"), []byte("value")))
+	for i := 500; i < 2000; i++ {
+		value, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], value)
+	}
+	assert.Nil(t, db.Merge())
+	value, err := db.Get([]byte("after-restart"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+}
+
+func TestDB_IOUring(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-io-uring")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.FileIOType = fio.IOUring
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 事务中的记录跨越多个数据文件，写入和持久化批量提交
+	values := make(map[string][]byte)
+	wbOpts := DefaultWriteBatchOptions
+	wbOpts.SyncWrites = true
+	for n := 0; n < 4; n++ {
+		wb := db.NewWriteBatch(wbOpts)
+		for i := n * 500; i < (n+1)*500; i++ {
+			value := utils.RandomValue(64)
+			values[string(utils.GetTestKey(i))] = value
+			assert.Nil(t, wb.Put(utils.GetTestKey(i), value))
+		}
+		assert.Nil(t, wb.Commit())
+	}
+	assert.True(t, len(db.olderFiles) > 1)
+
+	check := func(db *DB) {
+		count := 0
+		assert.Nil(t, db.Fold(func(key, value []byte) bool {
+			assert.Equal(t, values[string(key)], value)
+			count++
+			return true
+		}))
+		assert.Equal(t, len(values), count)
+
+		iterOpts := DefaultIteratorOptions
+		iterOpts.Prefetch = 100
+		iter := db.NewIterator(iterOpts)
+		defer iter.Close()
+		count = 0
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			value, err := iter.Value()
+			assert.Nil(t, err)
+			assert.Equal(t, values[string(iter.Key())], value)
+			count++
+		}
+		assert.Equal(t, len(values), count)
+		iter.Seek(utils.GetTestKey(1500))
+		assert.True(t, iter.Valid())
+		assert.Equal(t, utils.GetTestKey(1500), iter.Key())
+	}
+	check(db)
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+}
+
+func TestDB_IndexShards(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-shards")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexShards = 8
+	opts.BlockCacheSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	value := func(i int) []byte {
+		return bytes.Repeat(utils.GetTestKey(i), 8)
+	}
+	// 读取和写入并发进行，读到的 value 必须是完整写入的数据
+	wg := new(sync.WaitGroup)
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := w * 1000; i < (w+1)*1000; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), value(i)))
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := w * 1000; i < (w+1)*1000; i++ {
+				v, err := db.Get(utils.GetTestKey(i))
+				if err == ErrKeyNotFound {
+					continue
+				}
+				assert.Nil(t, err)
+				assert.Equal(t, value(i), v)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// 分片之后依然按照 key 的顺序遍历
+	keys := db.ListKeys()
+	assert.Equal(t, 4000, len(keys))
+	for i := 1; i < len(keys); i++ {
+		assert.True(t, bytes.Compare(keys[i-1], keys[i]) < 0)
+	}
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	for i := 0; i < 4000; i++ {
+		v, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value(i), v)
+	}
+
+	opts.IndexShards = -1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+// blockingIndexer 写入 blockKey 时阻塞，直到 release 被关闭
+type blockingIndexer struct {
+	index.Indexer
+	blockKey []byte
+	blocked  chan struct{}
+	release  chan struct{}
+}
+
+func (bi *blockingIndexer) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	if bytes.Equal(key, bi.blockKey) {
+		close(bi.blocked)
+		<-bi.release
+	}
+	return bi.Indexer.Put(key, pos)
+}
+
+// 更新索引时不持有 db.mu，一个写入更新索引时其他 key 的写入不会被阻塞
+func TestDB_IndependentKeyWrites(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-independent-writes")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	blocking := &blockingIndexer{
+		Indexer:  db.index,
+		blockKey: []byte("slow"),
+		blocked:  make(chan struct{}),
+		release:  make(chan struct{}),
+	}
+	db.index = blocking
+	slowDone := make(chan error)
+	go func() {
+		slowDone <- db.Put([]byte("slow"), []byte("slow value"))
+	}()
+	<-blocking.blocked
+
+	fastDone := make(chan error)
+	go func() {
+		fastDone <- db.Put([]byte("fast"), []byte("fast value"))
+	}()
+	select {
+	case err := <-fastDone:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("write to an independent key was blocked by another index update")
+	}
+	value, err := db.Get([]byte("fast"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("fast value"), value)
+
+	close(blocking.release)
+	assert.Nil(t, <-slowDone)
+	value, err = db.Get([]byte("slow"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("slow value"), value)
+}
+
+// 并发写入同一个 key 时索引指向最后追加的记录，和重启之后重放数据文件的结果一致
+func TestDB_SameKeyWritesKeepLogOrder(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-same-key-writes")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	wg := new(sync.WaitGroup)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 300; i++ {
+				key := keys[i%len(keys)]
+				switch i % 7 {
+				case 0:
+					assert.Nil(t, db.Delete(key))
+				case 1:
+					wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+					_ = wb.Put(keys[0], utils.RandomValue(16))
+					_ = wb.Delete(keys[1])
+					assert.Nil(t, wb.Commit())
+				default:
+					assert.Nil(t, db.Put(key, utils.RandomValue(16)))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		if value, err := db.Get(key); err == nil {
+			values[string(key)] = value
+		}
+	}
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	for _, key := range keys {
+		value, err := db2.Get(key)
+		if expected, ok := values[string(key)]; ok {
+			assert.Nil(t, err)
+			assert.Equal(t, expected, value)
+		} else {
+			assert.Equal(t, ErrKeyNotFound, err)
+		}
+	}
+}
+
+func TestDB_HashIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-hash-index")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexType = index.Hash
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	// 有序遍历时对所有 key 排序
+	keys := db.ListKeys()
+	assert.Equal(t, 1000, len(keys))
+	for i := 1; i < len(keys); i++ {
+		assert.True(t, bytes.Compare(keys[i-1], keys[i]) < 0)
+	}
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		value, err := db2.Get(utils.GetTestKey(i))
+		if i < 1000 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), value)
+		}
+	}
+}
+
+func TestDB_SkipListIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-skiplist-index")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexType = index.Skiplist
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	// 读取不加索引的锁，和写入并发执行
+	wg := new(sync.WaitGroup)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 1000; i < 2000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			value, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), value)
+		}
+	}()
+	wg.Wait()
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	keys := db.ListKeys()
+	assert.Equal(t, 1000, len(keys))
+	for i := 1; i < len(keys); i++ {
+		assert.True(t, bytes.Compare(keys[i-1], keys[i]) < 0)
+	}
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		value, err := db2.Get(utils.GetTestKey(i))
+		if i < 1000 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), value)
+		}
+	}
+}
+
+func TestDB_CompactIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compact-index")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexType = index.Compact
+	opts.IndexShards = 4
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	stat := db.Stat()
+	assert.Equal(t, 1000, stat.IndexMemory.Keys)
+	assert.True(t, stat.IndexMemory.Total() > 0)
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+	for i := 0; i < 2000; i++ {
+		value, err := db2.Get(utils.GetTestKey(i))
+		if i < 1000 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), value)
+		}
+	}
+}
@@ -13,4 +13,7 @@ var (
 	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
 	ErrMergeRatioUnreached    = errors.New("the merge ratio do not reach the option")
 	ErrNoEnoughSpaceForMerge  = errors.New("no enough disk space for merge")
+	ErrDiskQuotaExceeded      = errors.New("the disk quota of database is exceeded")
+	ErrUpgradeNotSupported    = errors.New("cannot upgrade data files of the b+tree index")
+	ErrOptionsMismatch        = errors.New("the data file was written with different options")
 )
@@ -0,0 +1,209 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"github.com/Tuanzi-bug/TuanKV/fio"
+	"github.com/Tuanzi-bug/TuanKV/utils"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// openFaultyDB 打开一个数据文件通过 FaultInjector 读写的数据库
+func openFaultyDB(t *testing.T, name string) (*DB, *fio.FaultInjector, Options) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-fault-"+name)
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	injector := fio.NewFaultInjector()
+	opts.IOManagerFactory = injector.Factory(fio.StandardFIO)
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	// 重新打开时不再注入故障
+	opts.IOManagerFactory = nil
+	return db, injector, opts
+}
+
+// reopenDB 关闭（可能已经崩溃的）数据库并重新打开，检查 expected 中的 key 都存在，并且没有多余的 key
+func reopenDB(t *testing.T, db *DB, opts Options, expected map[string][]byte) *DB {
+	_ = db.Close()
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, len(expected), len(db.ListKeys()))
+	for key, value := range expected {
+		v, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, v)
+	}
+	// 恢复之后可以继续写入，并且再次重启之后依然可以读取
+	assert.Nil(t, db.Put([]byte("after-recovery"), []byte("value")))
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	value, err := db.Get([]byte("after-recovery"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	return db
+}
+
+func putValues(t *testing.T, db *DB, expected map[string][]byte, from, to int) {
+	for i := from; i < to; i++ {
+		value := utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		expected[string(utils.GetTestKey(i))] = value
+	}
+}
+
+func TestDB_FaultShortWrite(t *testing.T) {
+	db, injector, opts := openFaultyDB(t, "short-write")
+	expected := make(map[string][]byte)
+	putValues(t, db, expected, 0, 1000)
+
+	// 写入失败的记录被截断，之后的写入紧接在上一条完整的记录之后
+	injector.ShortWrites(1)
+	err := db.Put(utils.GetTestKey(5000), utils.RandomValue(64))
+	assert.Equal(t, io.ErrShortWrite, err)
+	_, err = db.Get(utils.GetTestKey(5000))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	injector.ShortWrites(1)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(5001), utils.RandomValue(64))
+	_ = wb.Put(utils.GetTestKey(5002), utils.RandomValue(64))
+	assert.Equal(t, io.ErrShortWrite, wb.Commit())
+
+	putValues(t, db, expected, 1000, 1500)
+	db = reopenDB(t, db, opts, expected)
+	destroyDB(db)
+}
+
+func TestDB_FaultReadError(t *testing.T) {
+	db, injector, opts := openFaultyDB(t, "read-error")
+	expected := make(map[string][]byte)
+	putValues(t, db, expected, 0, 1000)
+
+	injector.FailReads(true)
+	_, err := db.Get(utils.GetTestKey(10))
+	assert.Equal(t, syscall.EIO, err)
+	injector.FailReads(false)
+	value, err := db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, expected[string(utils.GetTestKey(10))], value)
+	assert.Nil(t, db.Close())
+
+	// 启动时读取数据文件失败返回错误，而不是加载不完整的索引
+	opts2 := opts
+	opts2.IOManagerFactory = injector.Factory(fio.StandardFIO)
+	injector.FailReads(true)
+	_, err = Open(opts2)
+	assert.Equal(t, syscall.EIO, err)
+	injector.FailReads(false)
+
+	db, err = Open(opts2)
+	assert.Nil(t, err)
+	db = reopenDB(t, db, opts, expected)
+	destroyDB(db)
+}
+
+func TestDB_FaultSyncError(t *testing.T) {
+	db, injector, opts := openFaultyDB(t, "sync-error")
+	db.options.SyncWrites = true
+	expected := make(map[string][]byte)
+	putValues(t, db, expected, 0, 200)
+
+	injector.FailSyncs(true)
+	// 持久化失败时写入返回错误，索引没有更新
+	failedKey := utils.GetTestKey(5000)
+	failedValue := utils.RandomValue(64)
+	assert.Equal(t, syscall.EIO, db.Put(failedKey, failedValue))
+	_, err := db.Get(failedKey)
+	assert.Equal(t, ErrKeyNotFound, err)
+	batchValue := utils.RandomValue(64)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(5001), batchValue)
+	assert.Equal(t, syscall.EIO, wb.Commit())
+	injector.FailSyncs(false)
+
+	putValues(t, db, expected, 200, 400)
+	// 持久化失败的记录已经写入了文件，重启之后是否可见取决于是否真正落盘，这里文件依然完整，因此可见
+	expected[string(failedKey)] = failedValue
+	expected[string(utils.GetTestKey(5001))] = batchValue
+	db = reopenDB(t, db, opts, expected)
+	destroyDB(db)
+}
+
+func TestDB_FaultPowerLoss(t *testing.T) {
+	db, injector, opts := openFaultyDB(t, "power-loss")
+	expected := make(map[string][]byte)
+	// 写入的数据跨越多个数据文件，轮转时封存的文件已经持久化
+	putValues(t, db, expected, 0, 2000)
+	assert.Nil(t, db.Sync())
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 2000; i < 2100; i++ {
+		_ = wb.Put(utils.GetTestKey(i), utils.RandomValue(64))
+	}
+	assert.Nil(t, wb.Commit())
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 断电之后没有持久化的事务和删除都丢失
+	assert.Nil(t, injector.PowerLoss())
+	assert.Equal(t, fio.ErrPowerLoss, db.Put(utils.GetTestKey(9999), utils.RandomValue(64)))
+	db = reopenDB(t, db, opts, expected)
+	destroyDB(db)
+}
+
+func TestDB_FaultTornWrite(t *testing.T) {
+	db, injector, opts := openFaultyDB(t, "torn-write")
+	expected := make(map[string][]byte)
+	putValues(t, db, expected, 0, 1000)
+
+	// 进程在写入事务时崩溃，已经写入页缓存的数据保留，事务只写入了一半
+	injector.TornWrite()
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 1100; i++ {
+		_ = wb.Put(utils.GetTestKey(i), utils.RandomValue(64))
+	}
+	assert.Equal(t, fio.ErrPowerLoss, wb.Commit())
+	assert.True(t, injector.Crashed())
+	db = reopenDB(t, db, opts, expected)
+
+	// 单条记录写入一半时崩溃
+	assert.Nil(t, db.Close())
+	injector = fio.NewFaultInjector()
+	opts.IOManagerFactory = injector.Factory(fio.StandardFIO)
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	opts.IOManagerFactory = nil
+	putValues(t, db, expected, 2000, 2100)
+	injector.TornWrite()
+	assert.Equal(t, fio.ErrPowerLoss, db.Put(utils.GetTestKey(3000), utils.RandomValue(64)))
+	expected["after-recovery"] = []byte("value")
+	db = reopenDB(t, db, opts, expected)
+	destroyDB(db)
+}
+
+func TestDB_FaultStreamWrite(t *testing.T) {
+	db, injector, opts := openFaultyDB(t, "stream-write")
+	expected := make(map[string][]byte)
+	putValues(t, db, expected, 0, 1000)
+
+	// 大 value 分块写入，第一块写入之后失败，已经写入的分块被截断
+	injector.FailWriteAfter(1)
+	largeValue := utils.RandomValue(3 * 1024 * 1024)
+	err := db.PutStream(utils.GetTestKey(5000), bytes.NewReader(largeValue))
+	assert.Equal(t, syscall.EIO, err)
+	_, err = db.Get(utils.GetTestKey(5000))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 之后的写入在重启之后依然可以读取
+	putValues(t, db, expected, 1000, 1500)
+	assert.Nil(t, db.PutStream(utils.GetTestKey(5001), bytes.NewReader(largeValue)))
+	expected[string(utils.GetTestKey(5001))] = largeValue
+	putValues(t, db, expected, 1500, 1600)
+	db = reopenDB(t, db, opts, expected)
+	destroyDB(db)
+}
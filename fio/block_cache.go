@@ -0,0 +1,148 @@
+package fio
+
+import (
+	"container/list"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBlockSize 块缓存中每个块的大小
+const DefaultBlockSize = 32 * 1024
+
+// BlockCache is an LRU cache of fixed-size file blocks shared by all data files.
+// 数据文件只追加写入，已经写入的数据不会再发生变化，文件末尾不完整的块在读取范围超出已缓存的部分时重新读取
+type BlockCache struct {
+	lock      *sync.Mutex
+	blockSize int64
+	capacity  int // 最多缓存的块数量
+	ll        *list.List
+	items     map[blockKey]*list.Element
+	hits      uint64
+	misses    uint64
+}
+
+type blockKey struct {
+	fileId uint32
+	index  int64
+}
+
+type block struct {
+	key  blockKey
+	data []byte
+}
+
+// NewBlockCache 创建一个最多缓存 capacity 字节、块大小为 blockSize 的块缓存
+func NewBlockCache(capacity int64, blockSize int64) *BlockCache {
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	blocks := int(capacity / blockSize)
+	if blocks <= 0 {
+		blocks = 1
+	}
+	return &BlockCache{
+		lock:      new(sync.Mutex),
+		blockSize: blockSize,
+		capacity:  blocks,
+		ll:        list.New(),
+		items:     make(map[blockKey]*list.Element),
+	}
+}
+
+// ReadAt 通过块缓存读取文件 fileId 中从 offset 开始的数据，语义和 io.ReaderAt 相同
+// 缓存未命中时至少读取 readAhead 字节，顺序扫描时可以用更少的 IO 读取整个文件
+func (bc *BlockCache) ReadAt(ioManager IOManager, fileId uint32, b []byte, offset int64, readAhead int64) (int, error) {
+	var n int
+	for n < len(b) {
+		cur := offset + int64(n)
+		index := cur / bc.blockSize
+		start := index * bc.blockSize
+
+		buf, ok := bc.get(blockKey{fileId: fileId, index: index})
+		if ok && int64(len(buf)) < bc.blockSize && offset+int64(len(b)) > start+int64(len(buf)) {
+			ok = false
+		}
+		if !ok {
+			atomic.AddUint64(&bc.misses, 1)
+			// 从当前块开始一次性读取本次需要的数据以及预读的数据
+			readSize := offset + int64(len(b)) - start
+			if readSize < readAhead {
+				readSize = readAhead
+			}
+			readSize = (readSize + bc.blockSize - 1) / bc.blockSize * bc.blockSize
+			buf = make([]byte, readSize)
+			m, err := ioManager.Read(buf, start)
+			if err != nil && err != io.EOF {
+				return n, err
+			}
+			buf = buf[:m]
+			for i := int64(0); i*bc.blockSize < int64(m); i++ {
+				end := (i + 1) * bc.blockSize
+				if end > int64(m) {
+					end = int64(m)
+				}
+				bc.put(blockKey{fileId: fileId, index: index + i}, buf[i*bc.blockSize:end])
+			}
+		} else {
+			atomic.AddUint64(&bc.hits, 1)
+		}
+
+		if cur-start >= int64(len(buf)) {
+			return n, io.EOF
+		}
+		copied := copy(b[n:], buf[cur-start:])
+		n += copied
+		// 读到的数据不足一个完整的块，说明已经到了文件末尾
+		if n < len(b) && int64(len(buf))%bc.blockSize != 0 {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+// RemoveFile 淘汰文件 fileId 的所有缓存块，在文件被截断或者删除时调用
+func (bc *BlockCache) RemoveFile(fileId uint32) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	for key, elem := range bc.items {
+		if key.fileId == fileId {
+			bc.ll.Remove(elem)
+			delete(bc.items, key)
+		}
+	}
+}
+
+// Stats 返回块缓存命中和未命中的次数
+func (bc *BlockCache) Stats() (hits uint64, misses uint64) {
+	return atomic.LoadUint64(&bc.hits), atomic.LoadUint64(&bc.misses)
+}
+
+func (bc *BlockCache) get(key blockKey) ([]byte, bool) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	elem, ok := bc.items[key]
+	if !ok {
+		return nil, false
+	}
+	bc.ll.MoveToFront(elem)
+	return elem.Value.(*block).data, true
+}
+
+func (bc *BlockCache) put(key blockKey, data []byte) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+	if elem, ok := bc.items[key]; ok {
+		// 文件末尾的块追加了新的数据
+		if blk := elem.Value.(*block); len(data) > len(blk.data) {
+			blk.data = data
+		}
+		bc.ll.MoveToFront(elem)
+		return
+	}
+	bc.items[key] = bc.ll.PushFront(&block{key: key, data: data})
+	for bc.ll.Len() > bc.capacity {
+		oldest := bc.ll.Remove(bc.ll.Back()).(*block)
+		delete(bc.items, oldest.key)
+	}
+}
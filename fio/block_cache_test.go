@@ -0,0 +1,84 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlockCache_ReadAt(t *testing.T) {
+	path := filepath.Join("block-cache-a.data")
+	defer destroyFile(path)
+
+	fio, err := NewFileIOManager(path)
+	assert.Nil(t, err)
+	defer fio.Close()
+	content := make([]byte, 10*1024+100)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	_, err = fio.Write(content)
+	assert.Nil(t, err)
+
+	bc := NewBlockCache(64*1024, 1024)
+
+	// 跨越多个块读取
+	b1 := make([]byte, 3000)
+	n, err := bc.ReadAt(fio, 1, b1, 500, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 3000, n)
+	assert.Equal(t, content[500:3500], b1)
+
+	// 再次读取命中缓存
+	b2 := make([]byte, 100)
+	n, err = bc.ReadAt(fio, 1, b2, 1024, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, content[1024:1124], b2)
+	hits, _ := bc.Stats()
+	assert.Equal(t, uint64(1), hits)
+
+	// 读取到文件末尾
+	b3 := make([]byte, 200)
+	n, err = bc.ReadAt(fio, 1, b3, int64(len(content))-100, 0)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, content[len(content)-100:], b3[:100])
+
+	// 淘汰文件的缓存之后重新从文件读取
+	bc.RemoveFile(1)
+	_, misses := bc.Stats()
+	n, err = bc.ReadAt(fio, 1, b2, 1024, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, n)
+	_, misses2 := bc.Stats()
+	assert.Equal(t, misses+1, misses2)
+}
+
+func TestBlockCache_ReadAhead(t *testing.T) {
+	path := filepath.Join("block-cache-b.data")
+	defer destroyFile(path)
+
+	fio, err := NewFileIOManager(path)
+	assert.Nil(t, err)
+	defer fio.Close()
+	content := make([]byte, 16*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	_, err = fio.Write(content)
+	assert.Nil(t, err)
+
+	// 一次预读整个文件，之后的读取全部命中缓存
+	bc := NewBlockCache(64*1024, 1024)
+	b := make([]byte, 100)
+	for off := 0; off+100 <= len(content); off += 100 {
+		n, err := bc.ReadAt(fio, 1, b, int64(off), 16*1024)
+		assert.Nil(t, err)
+		assert.Equal(t, 100, n)
+		assert.Equal(t, content[off:off+100], b)
+	}
+	_, misses := bc.Stats()
+	assert.Equal(t, uint64(1), misses)
+}
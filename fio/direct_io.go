@@ -0,0 +1,168 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// directIOAlignment Direct I/O 要求读写的偏移、长度以及内存地址按照该大小对齐
+const directIOAlignment = 4096
+
+// DirectFileIO is an IOManager that bypasses the page cache with O_DIRECT.
+// 所有读写都按块对齐：追加写入时连同文件末尾不完整的块一起写入，不足一块的部分用 0 填充，
+// Size 返回的是实际写入的长度，关闭时截断填充的部分。异常退出后文件末尾可能残留填充的 0，由上层截断
+type DirectFileIO struct {
+	fd   *os.File
+	lock *sync.Mutex // 保护 tail 和 buf，写入之间互斥
+	size atomic.Int64
+	tail []byte // 文件末尾不完整的块
+	buf  []byte // 写入使用的对齐缓冲区
+}
+
+// NewDirectIOManager 使用 O_DIRECT 打开文件，文件系统不支持 O_DIRECT 时（例如 tmpfs）退化为经过页缓存的读写
+func NewDirectIOManager(filename string) (*DirectFileIO, error) {
+	fd, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|directIOFlag, DataFilePerm)
+	if err != nil && directIOFlag != 0 && errors.Is(err, syscall.EINVAL) {
+		fd, err = os.OpenFile(filename, os.O_CREATE|os.O_RDWR, DataFilePerm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	d := &DirectFileIO{
+		fd:   fd,
+		lock: new(sync.Mutex),
+		tail: alignedBuffer(directIOAlignment),
+	}
+	if err := d.loadTail(stat.Size()); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *DirectFileIO) Read(b []byte, offset int64) (int, error) {
+	size := d.size.Load()
+	if offset >= size {
+		return 0, io.EOF
+	}
+	end := offset + int64(len(b))
+	if end > size {
+		end = size
+	}
+	start := alignDown(offset)
+	buf := alignedBuffer(int(alignUp(end) - start))
+	// 关闭时截断了填充的部分，文件末尾的块可能只能读到一部分
+	n, err := d.fd.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if int64(n) < end-start {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n = copy(b, buf[offset-start:end-start])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (d *DirectFileIO) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	size := d.size.Load()
+	start := alignDown(size)
+	tailSize := int(size - start)
+	total := tailSize + len(b)
+	bufSize := int(alignUp(int64(total)))
+	if cap(d.buf) < bufSize {
+		d.buf = alignedBuffer(bufSize)
+	}
+	buf := d.buf[:bufSize]
+	copy(buf, d.tail[:tailSize])
+	copy(buf[tailSize:], b)
+	clear(buf[total:])
+	if _, err := d.fd.WriteAt(buf, start); err != nil {
+		return 0, err
+	}
+	copy(d.tail, buf[alignDown(int64(total)):total])
+	d.size.Store(size + int64(len(b)))
+	return len(b), nil
+}
+
+// Sync can persist data to the disk
+func (d *DirectFileIO) Sync() error {
+	return d.fd.Sync()
+}
+
+// Close 截断文件末尾填充的部分之后关闭文件
+func (d *DirectFileIO) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.fd.Truncate(d.size.Load()); err != nil {
+		_ = d.fd.Close()
+		return err
+	}
+	return d.fd.Close()
+}
+
+func (d *DirectFileIO) Size() (int64, error) {
+	return d.size.Load(), nil
+}
+
+// Truncate 截断文件，之后的写入从 size 处开始
+func (d *DirectFileIO) Truncate(size int64) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.fd.Truncate(size); err != nil {
+		return err
+	}
+	return d.loadTail(size)
+}
+
+// loadTail 读取文件末尾不完整的块，之后的追加写入会连同这部分数据一起写入
+func (d *DirectFileIO) loadTail(size int64) error {
+	start := alignDown(size)
+	if tailSize := size - start; tailSize > 0 {
+		n, err := d.fd.ReadAt(d.tail, start)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if int64(n) < tailSize {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	d.size.Store(size)
+	return nil
+}
+
+func alignDown(n int64) int64 {
+	return n &^ (directIOAlignment - 1)
+}
+
+func alignUp(n int64) int64 {
+	return alignDown(n + directIOAlignment - 1)
+}
+
+// alignedBuffer 分配起始地址按照 directIOAlignment 对齐的缓冲区
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	shift := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1)); rem != 0 {
+		shift = directIOAlignment - rem
+	}
+	return buf[shift : shift+size : shift+size]
+}
@@ -0,0 +1,8 @@
+//go:build linux
+
+package fio
+
+import "syscall"
+
+// directIOFlag 打开文件时绕过页缓存
+const directIOFlag = syscall.O_DIRECT
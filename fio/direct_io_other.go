@@ -0,0 +1,6 @@
+//go:build !linux
+
+package fio
+
+// directIOFlag 其他平台不支持 O_DIRECT，读写依然按块对齐，但是会经过页缓存
+const directIOFlag = 0
@@ -0,0 +1,88 @@
+package fio
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectFileIO_ReadWrite(t *testing.T) {
+	path := filepath.Join("direct-a.data")
+	defer destroyFile(path)
+
+	directIO, err := NewDirectIOManager(path)
+	assert.Nil(t, err)
+
+	// 写入跨越多个块、长度不对齐的数据
+	var expected []byte
+	for i := 0; i < 50; i++ {
+		b := bytes.Repeat([]byte{byte('a' + i%26)}, 100*i+7)
+		n, err := directIO.Write(b)
+		assert.Nil(t, err)
+		assert.Equal(t, len(b), n)
+		expected = append(expected, b...)
+	}
+	size, err := directIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(expected)), size)
+
+	b := make([]byte, 5000)
+	n, err := directIO.Read(b, 3000)
+	assert.Nil(t, err)
+	assert.Equal(t, 5000, n)
+	assert.Equal(t, expected[3000:8000], b)
+
+	// 读取超出文件末尾
+	n, err = directIO.Read(b, size-10)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 10, n)
+	_, err = directIO.Read(b, size)
+	assert.Equal(t, io.EOF, err)
+
+	// 关闭时截断填充的部分，重新打开之后继续追加
+	assert.Nil(t, directIO.Sync())
+	assert.Nil(t, directIO.Close())
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, size, stat.Size())
+
+	directIO, err = NewDirectIOManager(path)
+	assert.Nil(t, err)
+	defer directIO.Close()
+	_, err = directIO.Write([]byte("tail"))
+	assert.Nil(t, err)
+	expected = append(expected, []byte("tail")...)
+	all := make([]byte, len(expected))
+	_, err = directIO.Read(all, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, all)
+}
+
+func TestDirectFileIO_Truncate(t *testing.T) {
+	path := filepath.Join("direct-b.data")
+	defer destroyFile(path)
+
+	directIO, err := NewDirectIOManager(path)
+	assert.Nil(t, err)
+	defer directIO.Close()
+	_, err = directIO.Write(bytes.Repeat([]byte("x"), 5000))
+	assert.Nil(t, err)
+
+	// 没有关闭时文件末尾带有填充的 0
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(8192), stat.Size())
+
+	assert.Nil(t, directIO.Truncate(4100))
+	_, err = directIO.Write([]byte("yy"))
+	assert.Nil(t, err)
+	size, _ := directIO.Size()
+	assert.Equal(t, int64(4102), size)
+	b := make([]byte, 4)
+	_, err = directIO.Read(b, 4098)
+	assert.Nil(t, err)
+	assert.Equal(t, "xxyy", string(b))
+}
@@ -0,0 +1,228 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"syscall"
+)
+
+// ErrPowerLoss 模拟断电或者进程崩溃之后，FaultyIO 的所有操作都返回该错误
+var ErrPowerLoss = errors.New("simulated power loss")
+
+// IOManagerFactory 创建数据文件使用的 IOManager
+type IOManagerFactory func(filename string) (IOManager, error)
+
+// FaultInjector 控制一组 FaultyIO 注入的故障，可以在运行时修改，并发安全
+type FaultInjector struct {
+	lock        *sync.Mutex
+	files       map[*FaultyIO]struct{}
+	shortWrites int  // 之后的 n 次写入只写入一半，并返回 io.ErrShortWrite
+	tornWrite   bool // 下一次写入只写入一半，然后模拟进程崩溃
+	failWrite   bool // 再成功写入 writesLeft 次之后，下一次写入返回 EIO
+	writesLeft  int
+	failReads   bool // 读取返回 EIO
+	failSyncs   bool // 持久化返回 EIO
+	crashed     bool
+}
+
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{
+		lock:  new(sync.Mutex),
+		files: make(map[*FaultyIO]struct{}),
+	}
+}
+
+// Factory 返回创建 FaultyIO 的工厂函数，被包装的 IOManager 使用 ioType 创建
+func (f *FaultInjector) Factory(ioType FileIOType) IOManagerFactory {
+	return func(filename string) (IOManager, error) {
+		ioManager, err := NewIOManager(filename, ioType)
+		if err != nil {
+			return nil, err
+		}
+		return f.Wrap(ioManager)
+	}
+}
+
+// Wrap 包装一个 IOManager，当前文件的长度视为已经持久化
+func (f *FaultInjector) Wrap(ioManager IOManager) (*FaultyIO, error) {
+	size, err := ioManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	file := &FaultyIO{inner: ioManager, injector: f, synced: size}
+	f.lock.Lock()
+	f.files[file] = struct{}{}
+	f.lock.Unlock()
+	return file, nil
+}
+
+// ShortWrites 之后的 n 次写入只写入一半的数据
+func (f *FaultInjector) ShortWrites(n int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.shortWrites = n
+}
+
+// TornWrite 下一次写入只写入一半的数据，然后模拟进程崩溃，已经写入但是没有持久化的数据保留
+func (f *FaultInjector) TornWrite() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.tornWrite = true
+}
+
+// FailWriteAfter 之后的 n 次写入正常完成，再下一次写入不写入任何数据并返回 EIO
+func (f *FaultInjector) FailWriteAfter(n int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.failWrite, f.writesLeft = true, n
+}
+
+// FailReads 设置读取是否返回 EIO
+func (f *FaultInjector) FailReads(fail bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.failReads = fail
+}
+
+// FailSyncs 设置持久化是否返回 EIO
+func (f *FaultInjector) FailSyncs(fail bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.failSyncs = fail
+}
+
+// PowerLoss 模拟断电：丢弃所有文件中最近一次持久化之后写入的数据，之后的操作都返回 ErrPowerLoss
+func (f *FaultInjector) PowerLoss() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.crashed = true
+	for file := range f.files {
+		truncater, ok := file.inner.(Truncater)
+		if !ok {
+			return errors.New("the io manager does not support truncate")
+		}
+		if err := truncater.Truncate(file.synced); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Crashed 判断是否已经模拟了断电或者进程崩溃
+func (f *FaultInjector) Crashed() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.crashed
+}
+
+// FaultyIO is an IOManager wrapper that injects the failures configured in its FaultInjector.
+type FaultyIO struct {
+	inner    IOManager
+	injector *FaultInjector
+	synced   int64 // 最近一次持久化时文件的长度，由 injector.lock 保护
+}
+
+func (fio *FaultyIO) Read(b []byte, offset int64) (int, error) {
+	f := fio.injector
+	f.lock.Lock()
+	crashed, failReads := f.crashed, f.failReads
+	f.lock.Unlock()
+	if crashed {
+		return 0, ErrPowerLoss
+	}
+	if failReads {
+		return 0, syscall.EIO
+	}
+	return fio.inner.Read(b, offset)
+}
+
+func (fio *FaultyIO) Write(b []byte) (int, error) {
+	f := fio.injector
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.crashed {
+		return 0, ErrPowerLoss
+	}
+	if f.tornWrite {
+		f.tornWrite = false
+		n, err := fio.inner.Write(b[:len(b)/2])
+		f.crashed = true
+		if err != nil {
+			return n, err
+		}
+		return n, ErrPowerLoss
+	}
+	if f.shortWrites > 0 {
+		f.shortWrites--
+		n, err := fio.inner.Write(b[:len(b)/2])
+		if err != nil {
+			return n, err
+		}
+		return n, io.ErrShortWrite
+	}
+	if f.failWrite {
+		if f.writesLeft == 0 {
+			f.failWrite = false
+			return 0, syscall.EIO
+		}
+		f.writesLeft--
+	}
+	return fio.inner.Write(b)
+}
+
+// Sync can persist data to the disk
+func (fio *FaultyIO) Sync() error {
+	f := fio.injector
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.crashed {
+		return ErrPowerLoss
+	}
+	if f.failSyncs {
+		return syscall.EIO
+	}
+	if err := fio.inner.Sync(); err != nil {
+		return err
+	}
+	size, err := fio.inner.Size()
+	if err != nil {
+		return err
+	}
+	fio.synced = size
+	return nil
+}
+
+// Close 关闭被包装的 IOManager，模拟崩溃之后依然释放文件资源
+func (fio *FaultyIO) Close() error {
+	f := fio.injector
+	f.lock.Lock()
+	delete(f.files, fio)
+	f.lock.Unlock()
+	return fio.inner.Close()
+}
+
+func (fio *FaultyIO) Size() (int64, error) {
+	return fio.inner.Size()
+}
+
+// Truncate 截断被包装的文件
+func (fio *FaultyIO) Truncate(size int64) error {
+	f := fio.injector
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.crashed {
+		return ErrPowerLoss
+	}
+	truncater, ok := fio.inner.(Truncater)
+	if !ok {
+		return errors.New("the io manager does not support truncate")
+	}
+	if err := truncater.Truncate(size); err != nil {
+		return err
+	}
+	if fio.synced > size {
+		fio.synced = size
+	}
+	return nil
+}
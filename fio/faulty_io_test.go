@@ -0,0 +1,90 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFaultyIO(t *testing.T) {
+	path := filepath.Join("faulty-a.data")
+	defer destroyFile(path)
+
+	injector := NewFaultInjector()
+	ioManager, err := injector.Factory(StandardFIO)(path)
+	assert.Nil(t, err)
+	defer ioManager.Close()
+
+	_, err = ioManager.Write([]byte("synced"))
+	assert.Nil(t, err)
+	assert.Nil(t, ioManager.Sync())
+
+	injector.ShortWrites(1)
+	n, err := ioManager.Write([]byte("1234"))
+	assert.Equal(t, 2, n)
+	assert.Equal(t, io.ErrShortWrite, err)
+	n, err = ioManager.Write([]byte("56"))
+	assert.Equal(t, 2, n)
+	assert.Nil(t, err)
+
+	injector.FailReads(true)
+	_, err = ioManager.Read(make([]byte, 6), 0)
+	assert.Equal(t, syscall.EIO, err)
+	injector.FailReads(false)
+	injector.FailSyncs(true)
+	assert.Equal(t, syscall.EIO, ioManager.Sync())
+	injector.FailSyncs(false)
+
+	// 断电之后没有持久化的数据丢失
+	assert.Nil(t, injector.PowerLoss())
+	assert.True(t, injector.Crashed())
+	_, err = ioManager.Write([]byte("78"))
+	assert.Equal(t, ErrPowerLoss, err)
+	size, err := ioManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+}
+
+func TestFaultyIO_TornWrite(t *testing.T) {
+	path := filepath.Join("faulty-b.data")
+	defer destroyFile(path)
+
+	injector := NewFaultInjector()
+	ioManager, err := injector.Factory(StandardFIO)(path)
+	assert.Nil(t, err)
+	defer ioManager.Close()
+
+	injector.TornWrite()
+	n, err := ioManager.Write([]byte("1234"))
+	assert.Equal(t, 2, n)
+	assert.Equal(t, ErrPowerLoss, err)
+	assert.True(t, injector.Crashed())
+	// 进程崩溃时已经写入的数据保留
+	size, err := ioManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), size)
+}
+
+func TestFaultyIO_FailWriteAfter(t *testing.T) {
+	path := filepath.Join("faulty-c.data")
+	defer destroyFile(path)
+
+	injector := NewFaultInjector()
+	ioManager, err := injector.Factory(StandardFIO)(path)
+	assert.Nil(t, err)
+	defer ioManager.Close()
+
+	injector.FailWriteAfter(1)
+	_, err = ioManager.Write([]byte("12"))
+	assert.Nil(t, err)
+	n, err := ioManager.Write([]byte("34"))
+	assert.Equal(t, syscall.EIO, err)
+	assert.Equal(t, 0, n)
+	_, err = ioManager.Write([]byte("56"))
+	assert.Nil(t, err)
+	size, err := ioManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), size)
+}
@@ -6,21 +6,30 @@ import "os"
 type FileIO struct {
 	// fd is a file descriptor that represents the file.
 	fd *os.File
+	// offset 下一次写入的位置，打开时为文件末尾，复用回收文件时可以通过 SetWriteOffset 修改
+	offset int64
 }
 
 func NewFileIOManager(filename string) (*FileIO, error) {
-	fd, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, DataFilePerm)
+	fd, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, DataFilePerm)
 	if err != nil {
 		return nil, err
 	}
-	return &FileIO{fd: fd}, nil
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return &FileIO{fd: fd, offset: stat.Size()}, nil
 }
 
 func (fio *FileIO) Read(b []byte, offset int64) (int, error) {
 	return fio.fd.ReadAt(b, offset)
 }
 func (fio *FileIO) Write(b []byte) (int, error) {
-	return fio.fd.Write(b)
+	n, err := fio.fd.WriteAt(b, fio.offset)
+	fio.offset += int64(n)
+	return n, err
 }
 
 // Sync can persist data to the disk
@@ -38,3 +47,40 @@ func (fio *FileIO) Size() (int64, error) {
 	}
 	return stat.Size(), nil
 }
+
+// Truncate 截断文件，之后的写入从新的末尾开始
+func (fio *FileIO) Truncate(size int64) error {
+	if err := fio.fd.Truncate(size); err != nil {
+		return err
+	}
+	fio.offset = size
+	return nil
+}
+
+// SetWriteOffset 之后的写入从 offset 开始，offset 之后已有的数据不会被截断
+func (fio *FileIO) SetWriteOffset(offset int64) error {
+	fio.offset = offset
+	return nil
+}
+
+// ZeroRange 把 [offset, offset+size) 的数据清零，保留已经分配的磁盘空间，不改变文件大小
+func (fio *FileIO) ZeroRange(offset, size int64) error {
+	return zeroRange(fio.fd, offset, size)
+}
+
+// writeZeros 文件系统不支持直接清零时写入全 0 的数据
+func writeZeros(fd *os.File, offset, size int64) error {
+	zeros := make([]byte, 64*1024)
+	for size > 0 {
+		n := int64(len(zeros))
+		if size < n {
+			n = size
+		}
+		if _, err := fd.WriteAt(zeros[:n], offset); err != nil {
+			return err
+		}
+		offset += n
+		size -= n
+	}
+	return nil
+}
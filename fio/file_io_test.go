@@ -83,3 +83,27 @@ func TestFileIO_Close(t *testing.T) {
 	err = fio.Close()
 	assert.Nil(t, err)
 }
+
+func TestFileIO_ZeroRange(t *testing.T) {
+	path := filepath.Join("zero-a.data")
+	defer destroyFile(path)
+	fio, err := NewFileIOManager(path)
+	assert.Nil(t, err)
+	defer fio.Close()
+
+	_, err = fio.Write([]byte("abcdef"))
+	assert.Nil(t, err)
+	// 清零之后文件大小不变，写入从指定的位置开始
+	assert.Nil(t, fio.ZeroRange(2, 4))
+	assert.Nil(t, fio.SetWriteOffset(2))
+	size, err := fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+
+	_, err = fio.Write([]byte("x"))
+	assert.Nil(t, err)
+	b := make([]byte, 6)
+	_, err = fio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{'a', 'b', 'x', 0, 0, 0}, b)
+}
@@ -8,6 +8,12 @@ const (
 	StandardFIO FileIOType = iota
 
 	MemoryMap
+
+	// DirectIO 使用 O_DIRECT 读写，不占用页缓存
+	DirectIO
+
+	// IOUring 使用 io_uring 批量提交读写，内核不支持时退化为标准文件 IO
+	IOUring
 )
 
 // IOManager is an interface that represents the file I/O operations.
@@ -21,12 +27,46 @@ type IOManager interface {
 	Size() (int64, error)
 }
 
+// Truncater is implemented by IOManagers that can drop data at the end of the file.
+type Truncater interface {
+	Truncate(size int64) error
+}
+
+// OffsetWriter is implemented by IOManagers that can zero a range in place and move the write position,
+// so a recycled file can be reused without giving up the disk space already allocated to it.
+type OffsetWriter interface {
+	SetWriteOffset(offset int64) error
+	ZeroRange(offset, size int64) error
+}
+
+// ReadRequest 批量读取中的一个请求，读取之后 N 为读到的字节数，读到文件末尾时 Err 为 io.EOF
+type ReadRequest struct {
+	Buf    []byte
+	Offset int64
+	N      int
+	Err    error
+}
+
+// BatchReader is implemented by IOManagers that can submit several reads at once.
+type BatchReader interface {
+	ReadBatch(reqs []*ReadRequest)
+}
+
+// BatchWriter is implemented by IOManagers that can append several buffers, optionally followed by a sync, in one submission.
+type BatchWriter interface {
+	WriteBatch(bufs [][]byte, sync bool) (int, error)
+}
+
 func NewIOManager(filename string, ioType FileIOType) (IOManager, error) {
 	switch ioType {
 	case StandardFIO:
 		return NewFileIOManager(filename)
 	case MemoryMap:
 		return NewMMapIOManager(filename)
+	case DirectIO:
+		return NewDirectIOManager(filename)
+	case IOUring:
+		return NewUringIOManager(filename)
 	default:
 		panic("unsupported io type")
 	}
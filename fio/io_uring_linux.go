@@ -0,0 +1,163 @@
+//go:build linux
+
+package fio
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// UringIO is an IOManager that submits batched reads and writes through io_uring.
+// 单次的读写直接使用 pread/pwrite，ReadBatch 和 WriteBatch 在一次提交中完成多个操作，
+// 批量写入之后的 fsync 链接在最后一次写入之后，和写入一起提交
+type UringIO struct {
+	fd   *os.File
+	pool *ringPool
+	lock *sync.Mutex // 写入之间互斥
+	size atomic.Int64
+}
+
+// NewUringIOManager 打开使用 io_uring 的文件，内核不支持或者禁用了 io_uring 时返回标准文件 IO
+func NewUringIOManager(filename string) (IOManager, error) {
+	pool, err := getRingPool()
+	if err != nil {
+		return NewFileIOManager(filename)
+	}
+	fd, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	u := &UringIO{fd: fd, pool: pool, lock: new(sync.Mutex)}
+	u.size.Store(stat.Size())
+	return u, nil
+}
+
+func (u *UringIO) Read(b []byte, offset int64) (int, error) {
+	return u.fd.ReadAt(b, offset)
+}
+
+func (u *UringIO) Write(b []byte) (int, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	n, err := u.fd.WriteAt(b, u.size.Load())
+	u.size.Add(int64(n))
+	return n, err
+}
+
+// Sync can persist data to the disk
+func (u *UringIO) Sync() error {
+	return u.fd.Sync()
+}
+
+func (u *UringIO) Close() error {
+	return u.fd.Close()
+}
+
+func (u *UringIO) Size() (int64, error) {
+	return u.size.Load(), nil
+}
+
+// Truncate 截断文件，之后的写入从 size 处开始
+func (u *UringIO) Truncate(size int64) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if err := u.fd.Truncate(size); err != nil {
+		return err
+	}
+	u.size.Store(size)
+	return nil
+}
+
+// ReadBatch 在一次提交中完成所有读取，没有读满的请求使用 pread 读取剩余的部分
+func (u *UringIO) ReadBatch(reqs []*ReadRequest) {
+	ops := make([]*uringOp, len(reqs))
+	for i, req := range reqs {
+		ops[i] = &uringOp{opcode: uringOpRead, fd: int(u.fd.Fd()), buf: req.Buf, offset: req.Offset}
+	}
+	if err := u.pool.submit(ops); err != nil {
+		for _, op := range ops {
+			op.res = 0
+		}
+	}
+	for i, req := range reqs {
+		req.N, req.Err = 0, nil
+		if res := ops[i].res; res > 0 {
+			req.N = int(res)
+		}
+		if req.N < len(req.Buf) {
+			n, err := u.fd.ReadAt(req.Buf[req.N:], req.Offset+int64(req.N))
+			req.N += n
+			req.Err = err
+		}
+	}
+}
+
+// WriteBatch 在一次提交中依次追加写入所有数据，sync 为 true 时同时提交 fsync
+// 写入没有全部完成时（短写或者链接被取消），使用 pwrite 写入剩余的部分，返回真实的错误
+func (u *UringIO) WriteBatch(bufs [][]byte, sync bool) (int, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	fd := int(u.fd.Fd())
+	offset := u.size.Load()
+	ops := make([]*uringOp, 0, len(bufs)+1)
+	for _, buf := range bufs {
+		if len(buf) == 0 {
+			continue
+		}
+		ops = append(ops, &uringOp{opcode: uringOpWrite, fd: fd, buf: buf, offset: offset, link: true})
+		offset += int64(len(buf))
+	}
+	if sync {
+		ops = append(ops, &uringOp{opcode: uringOpFsync, fd: fd})
+	} else if len(ops) > 0 {
+		ops[len(ops)-1].link = false
+	}
+	if len(ops) == 0 {
+		return 0, nil
+	}
+	if err := u.pool.submit(ops); err != nil {
+		for _, op := range ops {
+			op.res = -int32(syscall.ECANCELED)
+		}
+	}
+
+	written := 0
+	synced := !sync
+	for _, op := range ops {
+		if op.opcode == uringOpFsync {
+			synced = op.res == 0
+			continue
+		}
+		done := 0
+		if op.res > 0 {
+			done = int(op.res)
+		}
+		if done < len(op.buf) {
+			n, err := u.fd.WriteAt(op.buf[done:], op.offset+int64(done))
+			done += n
+			if err != nil {
+				u.size.Add(int64(written + done))
+				return written + done, err
+			}
+			if done < len(op.buf) {
+				u.size.Add(int64(written + done))
+				return written + done, io.ErrShortWrite
+			}
+		}
+		written += done
+	}
+	u.size.Add(int64(written))
+	if !synced {
+		return written, u.fd.Sync()
+	}
+	return written, nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package fio
+
+// NewUringIOManager 当前平台不支持 io_uring，退化为标准文件 IO
+func NewUringIOManager(filename string) (IOManager, error) {
+	return NewFileIOManager(filename)
+}
@@ -0,0 +1,62 @@
+//go:build linux
+
+package fio
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestUringIO_Batch(t *testing.T) {
+	path := filepath.Join("uring-a.data")
+	defer destroyFile(path)
+
+	ioManager, err := NewUringIOManager(path)
+	assert.Nil(t, err)
+	defer ioManager.Close()
+	if _, ok := ioManager.(*UringIO); !ok {
+		t.Skip("io_uring is not available")
+	}
+	writer := ioManager.(BatchWriter)
+	reader := ioManager.(BatchReader)
+
+	// 超过 ring 容量的批量写入分多次提交
+	var bufs [][]byte
+	var expected []byte
+	for i := 0; i < 200; i++ {
+		buf := bytes.Repeat([]byte{byte('a' + i%26)}, i+1)
+		bufs = append(bufs, buf)
+		expected = append(expected, buf...)
+	}
+	n, err := writer.WriteBatch(bufs, true)
+	assert.Nil(t, err)
+	assert.Equal(t, len(expected), n)
+	_, err = ioManager.Write([]byte("tail"))
+	assert.Nil(t, err)
+	expected = append(expected, []byte("tail")...)
+	size, err := ioManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(expected)), size)
+
+	var reqs []*ReadRequest
+	for offset := 0; offset < len(expected); offset += 97 {
+		reqs = append(reqs, &ReadRequest{Buf: make([]byte, 50), Offset: int64(offset)})
+	}
+	// 超出文件末尾的读取
+	reqs = append(reqs, &ReadRequest{Buf: make([]byte, 10), Offset: size - 4})
+	reader.ReadBatch(reqs)
+	for _, req := range reqs[:len(reqs)-1] {
+		end := req.Offset + int64(len(req.Buf))
+		if end > size {
+			end = size
+		}
+		assert.Equal(t, expected[req.Offset:end], req.Buf[:req.N])
+	}
+	last := reqs[len(reqs)-1]
+	assert.Equal(t, 4, last.N)
+	assert.Equal(t, io.EOF, last.Err)
+	assert.Equal(t, "tail", string(last.Buf[:last.N]))
+}
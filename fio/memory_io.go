@@ -0,0 +1,276 @@
+package fio
+
+import (
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFS 保存在内存中的文件系统，不创建任何磁盘文件，进程退出或者不再引用之后数据丢失
+type MemFS struct {
+	lock  *sync.RWMutex
+	files map[string]*memFile
+	dirs  map[string]time.Time
+	locks map[string]struct{}
+}
+
+// memFile 内存中的文件内容，删除或者重命名之后已经打开的 MemoryIO 依然可以读写
+type memFile struct {
+	lock    *sync.RWMutex
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{
+		lock:  new(sync.RWMutex),
+		files: make(map[string]*memFile),
+		dirs:  map[string]time.Time{string(filepath.Separator): time.Now(), ".": time.Now()},
+		locks: make(map[string]struct{}),
+	}
+}
+
+func (m *MemFS) OpenFile(name string, _ FileIOType) (IOManager, error) {
+	name = filepath.Clean(name)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if file, ok := m.files[name]; ok {
+		return &MemoryIO{file: file}, nil
+	}
+	if _, ok := m.dirs[name]; ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, ok := m.dirs[filepath.Dir(name)]; !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	file := &memFile{lock: new(sync.RWMutex), modTime: time.Now()}
+	m.files[name] = file
+	return &MemoryIO{file: file}, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	name = filepath.Clean(name)
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if file, ok := m.files[name]; ok {
+		return file.info(filepath.Base(name)), nil
+	}
+	if modTime, ok := m.dirs[name]; ok {
+		return &memFileInfo{name: filepath.Base(name), modTime: modTime, dir: true}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) ReadDir(dir string) ([]fs.DirEntry, error) {
+	dir = filepath.Clean(dir)
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if _, ok := m.dirs[dir]; !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: fs.ErrNotExist}
+	}
+	var entries []fs.DirEntry
+	for name, file := range m.files {
+		if filepath.Dir(name) == dir {
+			entries = append(entries, fs.FileInfoToDirEntry(file.info(filepath.Base(name))))
+		}
+	}
+	for name, modTime := range m.dirs {
+		if name != dir && filepath.Dir(name) == dir {
+			entries = append(entries, fs.FileInfoToDirEntry(&memFileInfo{name: filepath.Base(name), modTime: modTime, dir: true}))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (m *MemFS) MkdirAll(dir string) error {
+	dir = filepath.Clean(dir)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for ; ; dir = filepath.Dir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+		}
+		if _, ok := m.dirs[dir]; ok {
+			return nil
+		}
+		m.dirs[dir] = time.Now()
+	}
+}
+
+func (m *MemFS) Rename(oldName, newName string) error {
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	file, ok := m.files[oldName]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	if _, ok := m.dirs[filepath.Dir(newName)]; !ok {
+		return &fs.PathError{Op: "rename", Path: newName, Err: fs.ErrNotExist}
+	}
+	delete(m.files, oldName)
+	m.files[newName] = file
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+	if _, ok := m.dirs[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	prefix := name + string(filepath.Separator)
+	for child := range m.files {
+		if strings.HasPrefix(child, prefix) {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+		}
+	}
+	for child := range m.dirs {
+		if strings.HasPrefix(child, prefix) {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+		}
+	}
+	delete(m.dirs, name)
+	return nil
+}
+
+func (m *MemFS) RemoveAll(path string) error {
+	path = filepath.Clean(path)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	prefix := path + string(filepath.Separator)
+	for name := range m.files {
+		if name == path || strings.HasPrefix(name, prefix) {
+			delete(m.files, name)
+		}
+	}
+	for name := range m.dirs {
+		if name == path || strings.HasPrefix(name, prefix) {
+			delete(m.dirs, name)
+		}
+	}
+	return nil
+}
+
+// Lock 获取进程内的锁，不创建文件
+func (m *MemFS) Lock(name string) (FileLock, error) {
+	name = filepath.Clean(name)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.locks[name]; ok {
+		return nil, ErrLocked
+	}
+	m.locks[name] = struct{}{}
+	return &memLock{fs: m, name: name}, nil
+}
+
+type memLock struct {
+	fs   *MemFS
+	name string
+}
+
+func (l *memLock) Unlock() error {
+	l.fs.lock.Lock()
+	defer l.fs.lock.Unlock()
+	delete(l.fs.locks, l.name)
+	return nil
+}
+
+func (f *memFile) info(name string) *memFileInfo {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return &memFileInfo{name: name, size: int64(len(f.data)), modTime: f.modTime}
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.dir }
+func (fi *memFileInfo) Sys() any           { return nil }
+
+func (fi *memFileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0755
+	}
+	return DataFilePerm
+}
+
+// MemoryIO is an IOManager over a file kept in a MemFS.
+type MemoryIO struct {
+	file *memFile
+}
+
+func (mio *MemoryIO) Read(b []byte, offset int64) (int, error) {
+	f := mio.file
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	if offset >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.data[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (mio *MemoryIO) Write(b []byte) (int, error) {
+	f := mio.file
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.data = append(f.data, b...)
+	f.modTime = time.Now()
+	return len(b), nil
+}
+
+// Sync 内存中的文件不需要持久化
+func (mio *MemoryIO) Sync() error {
+	return nil
+}
+
+func (mio *MemoryIO) Close() error {
+	return nil
+}
+
+func (mio *MemoryIO) Size() (int64, error) {
+	f := mio.file
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return int64(len(f.data)), nil
+}
+
+// Truncate 截断或者使用 0 扩展文件
+func (mio *MemoryIO) Truncate(size int64) error {
+	f := mio.file
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if size == 0 {
+		// 清空文件时释放内存
+		f.data = nil
+	} else if size <= int64(len(f.data)) {
+		f.data = f.data[:size]
+	} else {
+		f.data = append(f.data, make([]byte, size-int64(len(f.data)))...)
+	}
+	f.modTime = time.Now()
+	return nil
+}
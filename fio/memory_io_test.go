@@ -0,0 +1,82 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemFS(t *testing.T) {
+	memFS := NewMemFS()
+	dir := filepath.Join("/tmp", "memfs")
+	_, err := memFS.OpenFile(filepath.Join(dir, "a.data"), StandardFIO)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.Nil(t, memFS.MkdirAll(dir))
+
+	ioManager, err := memFS.OpenFile(filepath.Join(dir, "a.data"), StandardFIO)
+	assert.Nil(t, err)
+	_, err = ioManager.Write([]byte("12345"))
+	assert.Nil(t, err)
+	assert.Nil(t, memFS.MkdirAll(filepath.Join(dir, "sub")))
+
+	entries, err := memFS.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "a.data", entries[0].Name())
+	assert.True(t, entries[1].IsDir())
+	info, err := memFS.Stat(filepath.Join(dir, "a.data"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), info.Size())
+
+	// 重命名之后已经打开的文件依然可以读写
+	assert.Nil(t, memFS.Rename(filepath.Join(dir, "a.data"), filepath.Join(dir, "b.data")))
+	_, err = memFS.Stat(filepath.Join(dir, "a.data"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = ioManager.Write([]byte("6"))
+	assert.Nil(t, err)
+	buf, err := ReadFile(memFS, filepath.Join(dir, "b.data"))
+	assert.Nil(t, err)
+	assert.Equal(t, "123456", string(buf))
+
+	size, err := DirSize(memFS, "/tmp")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+	assert.NotNil(t, memFS.Remove(dir))
+	assert.Nil(t, memFS.RemoveAll(dir))
+	_, err = memFS.ReadDir(dir)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestMemoryIO(t *testing.T) {
+	memFS := NewMemFS()
+	ioManager, err := memFS.OpenFile("a.data", StandardFIO)
+	assert.Nil(t, err)
+
+	_, err = ioManager.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	_, err = ioManager.Write([]byte("key-b"))
+	assert.Nil(t, err)
+
+	b := make([]byte, 5)
+	n, err := ioManager.Read(b, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "key-b", string(b[:n]))
+	n, err = ioManager.Read(b, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "-b", string(b[:n]))
+
+	truncater := ioManager.(Truncater)
+	assert.Nil(t, truncater.Truncate(5))
+	size, err := ioManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), size)
+	_, err = ioManager.Write([]byte("key-c"))
+	assert.Nil(t, err)
+	n, err = ioManager.Read(b, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "key-c", string(b[:n]))
+	assert.Nil(t, ioManager.Sync())
+	assert.Nil(t, ioManager.Close())
+}
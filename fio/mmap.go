@@ -1,44 +1,150 @@
+//go:build unix
+
 package fio
 
 import (
-	"golang.org/x/exp/mmap"
+	"golang.org/x/sys/unix"
+	"io"
 	"os"
+	"sync"
 )
 
+// mmapGrowSize 追加写入超出映射区域时，文件和映射按照该大小成块扩展
+const mmapGrowSize = 1024 * 1024
+
+// MMap is a memory-mapped implementation of the IOManager interface that supports appends.
+// 写入超出映射区域时先扩展文件，再重新映射，Size 返回的是实际写入的长度，关闭时截断扩展出来的部分。
+// 异常退出后文件末尾可能残留扩展出来的 0，由上层截断
 type MMap struct {
-	readerAt *mmap.ReaderAt
+	fd    *os.File
+	lock  *sync.RWMutex // 重新映射时阻塞读取
+	data  []byte        // 映射区域，长度等于文件的实际大小
+	size  int64         // 已写入的长度
+	grown bool          // 是否扩展过文件
 }
 
 func NewMMapIOManager(filename string) (*MMap, error) {
-	f, err := os.OpenFile(filename, os.O_CREATE, DataFilePerm)
-	if err := f.Close(); err != nil {
+	fd, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, DataFilePerm)
+	if err != nil {
 		return nil, err
 	}
+	stat, err := fd.Stat()
 	if err != nil {
+		_ = fd.Close()
 		return nil, err
 	}
-	readerAt, err := mmap.Open(filename)
-	if err != nil {
+	m := &MMap{fd: fd, lock: new(sync.RWMutex), size: stat.Size()}
+	if err := m.remap(stat.Size()); err != nil {
+		_ = fd.Close()
 		return nil, err
 	}
-	return &MMap{readerAt: readerAt}, nil
+	return m, nil
 }
 
 func (m *MMap) Read(b []byte, offset int64) (int, error) {
-	return m.readerAt.ReadAt(b, offset)
-
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if offset >= m.size {
+		return 0, io.EOF
+	}
+	n := copy(b, m.data[offset:m.size])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
 }
-func (m *MMap) Write([]byte) (int, error) {
-	panic("not implemented")
+
+func (m *MMap) Write(b []byte) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	end := m.size + int64(len(b))
+	if end > int64(len(m.data)) {
+		length := (end + mmapGrowSize - 1) / mmapGrowSize * mmapGrowSize
+		if err := m.fd.Truncate(length); err != nil {
+			return 0, err
+		}
+		m.grown = true
+		if err := m.remap(length); err != nil {
+			return 0, err
+		}
+	}
+	copy(m.data[m.size:], b)
+	m.size = end
+	return len(b), nil
 }
 
 // Sync can persist data to the disk
+// 先通过 msync 刷新映射区域中的数据，扩展文件修改了元数据，因此还需要 fsync
 func (m *MMap) Sync() error {
-	panic("not implemented")
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if len(m.data) > 0 {
+		if err := unix.Msync(m.data, unix.MS_SYNC); err != nil {
+			return err
+		}
+	}
+	return m.fd.Sync()
 }
+
 func (m *MMap) Close() error {
-	return m.readerAt.Close()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err := m.unmap(); err != nil {
+		_ = m.fd.Close()
+		return err
+	}
+	if m.grown {
+		if err := m.fd.Truncate(m.size); err != nil {
+			_ = m.fd.Close()
+			return err
+		}
+	}
+	return m.fd.Close()
 }
+
 func (m *MMap) Size() (int64, error) {
-	return int64(m.readerAt.Len()), nil
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.size, nil
+}
+
+// Truncate 截断文件并重新映射，之后的写入从 size 处开始
+func (m *MMap) Truncate(size int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err := m.fd.Truncate(size); err != nil {
+		return err
+	}
+	if err := m.remap(size); err != nil {
+		return err
+	}
+	m.size = size
+	return nil
+}
+
+// remap 解除原来的映射，重新映射文件的前 length 个字节，调用方需要持有写锁
+func (m *MMap) remap(length int64) error {
+	if err := m.unmap(); err != nil {
+		return err
+	}
+	if length == 0 {
+		return nil
+	}
+	data, err := unix.Mmap(int(m.fd.Fd()), 0, int(length), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	m.data = data
+	return nil
+}
+
+func (m *MMap) unmap() error {
+	if m.data == nil {
+		return nil
+	}
+	if err := unix.Munmap(m.data); err != nil {
+		return err
+	}
+	m.data = nil
+	return nil
 }
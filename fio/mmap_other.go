@@ -0,0 +1,8 @@
+//go:build !unix
+
+package fio
+
+// NewMMapIOManager 当前平台不支持内存映射，退化为标准文件 IO
+func NewMMapIOManager(filename string) (*FileIO, error) {
+	return NewFileIOManager(filename)
+}
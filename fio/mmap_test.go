@@ -1,8 +1,12 @@
+//go:build unix
+
 package fio
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"os"
 	"path/filepath"
 	"testing"
 )
@@ -45,3 +49,51 @@ func TestMMap_Read(t *testing.T) {
 	assert.Equal(t, 2, n2)
 
 }
+
+func TestMMap_Write(t *testing.T) {
+	path := filepath.Join("mmap-b.data")
+	defer destroyFile(path)
+
+	mmapIO, err := NewMMapIOManager(path)
+	assert.Nil(t, err)
+
+	// 写入超出映射区域时扩展文件并重新映射
+	var expected []byte
+	for i := 0; i < 300; i++ {
+		b := bytes.Repeat([]byte{byte('a' + i%26)}, 10*1024+i)
+		n, err := mmapIO.Write(b)
+		assert.Nil(t, err)
+		assert.Equal(t, len(b), n)
+		expected = append(expected, b...)
+	}
+	size, err := mmapIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(expected)), size)
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.True(t, stat.Size() > size)
+
+	b := make([]byte, 100)
+	n, err := mmapIO.Read(b, 2*mmapGrowSize-50)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, expected[2*mmapGrowSize-50:2*mmapGrowSize+50], b)
+	assert.Nil(t, mmapIO.Sync())
+
+	// 关闭时截断扩展出来的部分
+	assert.Nil(t, mmapIO.Close())
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, size, stat.Size())
+
+	mmapIO, err = NewMMapIOManager(path)
+	assert.Nil(t, err)
+	defer mmapIO.Close()
+	assert.Nil(t, mmapIO.Truncate(10))
+	_, err = mmapIO.Write([]byte("xyz"))
+	assert.Nil(t, err)
+	all := make([]byte, 13)
+	_, err = mmapIO.Read(all, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, append(expected[:10:10], []byte("xyz")...), all)
+}
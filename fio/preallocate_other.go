@@ -0,0 +1,22 @@
+//go:build !linux
+
+package fio
+
+import "os"
+
+// Preallocate 在不支持 fallocate 的平台上只创建文件，不做预分配
+func Preallocate(filename string, size int64) error {
+	fd, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return err
+	}
+	return fd.Close()
+}
+
+// zeroRange 在不支持 fallocate 的平台上写入全 0 的数据
+func zeroRange(fd *os.File, offset, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	return writeZeros(fd, offset, size)
+}
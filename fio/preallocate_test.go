@@ -0,0 +1,30 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreallocate(t *testing.T) {
+	path := filepath.Join("prealloc-a.data")
+	defer destroyFile(path)
+
+	err := Preallocate(path, 1024*1024)
+	assert.Nil(t, err)
+
+	// 预分配不改变文件的逻辑大小，追加写入依然从头开始
+	fio, err := NewFileIOManager(path)
+	assert.Nil(t, err)
+	defer fio.Close()
+	size, err := fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), size)
+
+	_, err = fio.Write([]byte("abc"))
+	assert.Nil(t, err)
+	b := make([]byte, 3)
+	_, err = fio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", string(b))
+}
@@ -0,0 +1,278 @@
+//go:build linux
+
+package fio
+
+import (
+	"golang.org/x/sys/unix"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// io_uring 相关的内核常量，见 include/uapi/linux/io_uring.h
+const (
+	uringEntries = 64
+
+	uringOffSQRing = 0
+	uringOffCQRing = 0x8000000
+	uringOffSQEs   = 0x10000000
+
+	uringOpFsync = 3
+	uringOpRead  = 22
+	uringOpWrite = 23
+
+	uringSQELink        = 1 << 2
+	uringEnterGetEvents = 1
+)
+
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  uringSQOffsets
+	cqOff                                                                  uringCQOffsets
+}
+
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uringOp 提交给 ring 的一次读、写或者 fsync
+type uringOp struct {
+	opcode uint8
+	fd     int
+	buf    []byte
+	offset int64
+	link   bool // 和下一个操作链接，前一个操作完成之后才开始下一个
+	res    int32
+}
+
+// ring 一个 io_uring 实例，同一时间只能被一个 goroutine 使用
+type ring struct {
+	fd      int
+	sqRing  []byte
+	cqRing  []byte
+	sqes    []uringSQE
+	sqHead  *uint32
+	sqTail  *uint32
+	sqMask  uint32
+	sqArray []uint32
+	cqHead  *uint32
+	cqTail  *uint32
+	cqMask  uint32
+	cqes    []uringCQE
+}
+
+func newRing(entries uint32) (*ring, error) {
+	params := &uringParams{}
+	fd, _, errno := syscall.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(params)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	r := &ring{fd: int(fd)}
+	var err error
+	sqSize := params.sqOff.array + params.sqEntries*4
+	if r.sqRing, err = unix.Mmap(r.fd, uringOffSQRing, int(sqSize), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		r.close()
+		return nil, err
+	}
+	cqSize := params.cqOff.cqes + params.cqEntries*uint32(unsafe.Sizeof(uringCQE{}))
+	if r.cqRing, err = unix.Mmap(r.fd, uringOffCQRing, int(cqSize), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		r.close()
+		return nil, err
+	}
+	sqesSize := int(params.sqEntries) * int(unsafe.Sizeof(uringSQE{}))
+	sqes, err := unix.Mmap(r.fd, uringOffSQEs, sqesSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		r.close()
+		return nil, err
+	}
+	r.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&sqes[0])), params.sqEntries)
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqRing[params.sqOff.array])), params.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cqRing[params.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*uringCQE)(unsafe.Pointer(&r.cqRing[params.cqOff.cqes])), params.cqEntries)
+	return r, nil
+}
+
+// submit 提交一批操作并等待全部完成，操作数不能超过 ring 的容量，结果写入每个操作的 res
+func (r *ring) submit(ops []*uringOp) error {
+	tail := atomic.LoadUint32(r.sqTail)
+	for i, op := range ops {
+		index := (tail + uint32(i)) & r.sqMask
+		sqe := &r.sqes[index]
+		*sqe = uringSQE{opcode: op.opcode, fd: int32(op.fd), off: uint64(op.offset), userData: uint64(i)}
+		if len(op.buf) > 0 {
+			sqe.addr = uint64(uintptr(unsafe.Pointer(&op.buf[0])))
+			sqe.len = uint32(len(op.buf))
+		}
+		if op.link {
+			sqe.flags = uringSQELink
+		}
+		r.sqArray[index] = index
+	}
+	atomic.StoreUint32(r.sqTail, tail+uint32(len(ops)))
+
+	submitted, completed := 0, 0
+	for completed < len(ops) {
+		n, _, errno := syscall.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(len(ops)-submitted),
+			uintptr(len(ops)-completed), uringEnterGetEvents, 0, 0)
+		if errno != 0 && errno != syscall.EINTR {
+			return errno
+		}
+		submitted += int(n)
+		head := atomic.LoadUint32(r.cqHead)
+		for ; head != atomic.LoadUint32(r.cqTail); head++ {
+			cqe := &r.cqes[head&r.cqMask]
+			ops[cqe.userData].res = cqe.res
+			completed++
+		}
+		atomic.StoreUint32(r.cqHead, head)
+	}
+	// 内核完成之前 buf 的地址一直被使用
+	runtime.KeepAlive(ops)
+	return nil
+}
+
+func (r *ring) close() {
+	if r.sqes != nil {
+		_ = unix.Munmap(unsafe.Slice((*byte)(unsafe.Pointer(&r.sqes[0])), len(r.sqes)*int(unsafe.Sizeof(uringSQE{}))))
+	}
+	if r.cqRing != nil {
+		_ = unix.Munmap(r.cqRing)
+	}
+	if r.sqRing != nil {
+		_ = unix.Munmap(r.sqRing)
+	}
+	_ = syscall.Close(r.fd)
+}
+
+// ringPool 进程内共享的 ring，最多创建 GOMAXPROCS 个，避免每个数据文件各自占用一个 ring
+type ringPool struct {
+	rings   chan *ring
+	lock    *sync.Mutex
+	created int
+	limit   int
+}
+
+var (
+	uringPool     *ringPool
+	uringInitErr  error
+	uringInitOnce sync.Once
+)
+
+// getRingPool 返回共享的 ring 池，内核不支持或者禁用了 io_uring 时返回错误
+func getRingPool() (*ringPool, error) {
+	uringInitOnce.Do(func() {
+		r, err := newRing(uringEntries)
+		if err != nil {
+			uringInitErr = err
+			return
+		}
+		limit := runtime.GOMAXPROCS(0)
+		uringPool = &ringPool{rings: make(chan *ring, limit), lock: new(sync.Mutex), created: 1, limit: limit}
+		uringPool.rings <- r
+	})
+	return uringPool, uringInitErr
+}
+
+func (p *ringPool) get() (*ring, error) {
+	select {
+	case r := <-p.rings:
+		return r, nil
+	default:
+	}
+	p.lock.Lock()
+	if p.created < p.limit {
+		p.created++
+		p.lock.Unlock()
+		r, err := newRing(uringEntries)
+		if err != nil {
+			p.lock.Lock()
+			p.created--
+			p.lock.Unlock()
+			return nil, err
+		}
+		return r, nil
+	}
+	p.lock.Unlock()
+	return <-p.rings, nil
+}
+
+func (p *ringPool) put(r *ring) {
+	p.rings <- r
+}
+
+// submit 按照 ring 的容量分批提交操作，链接只在同一批次内生效，批次之间按顺序执行
+func (p *ringPool) submit(ops []*uringOp) error {
+	r, err := p.get()
+	if err != nil {
+		return err
+	}
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > uringEntries {
+			n = uringEntries
+		}
+		batch := ops[:n]
+		// 批次中最后一个操作不能带有链接标记
+		lastLink := batch[n-1].link
+		batch[n-1].link = false
+		err := r.submit(batch)
+		batch[n-1].link = lastLink
+		if err != nil {
+			// 出错时可能还有没有完成的操作，丢弃这个 ring
+			p.discard(r)
+			return err
+		}
+		ops = ops[n:]
+	}
+	p.put(r)
+	return nil
+}
+
+// discard 关闭出错的 ring 并创建一个新的放回池中，避免等待 ring 的 goroutine 一直阻塞
+func (p *ringPool) discard(r *ring) {
+	r.close()
+	if r, err := newRing(uringEntries); err == nil {
+		p.put(r)
+		return
+	}
+	p.lock.Lock()
+	p.created--
+	p.lock.Unlock()
+}
@@ -0,0 +1,148 @@
+package fio
+
+import (
+	"errors"
+	"github.com/gofrs/flock"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrLocked 文件锁已经被其他进程或者实例持有
+var ErrLocked = errors.New("the file is locked")
+
+// VFS is the file system a database keeps its directory in. The operating system's file system is used
+// by default, MemFS keeps everything in memory, and other implementations can encrypt the files or store
+// them in an object store.
+type VFS interface {
+	// OpenFile 以 ioType 打开文件，文件不存在时创建
+	OpenFile(name string, ioType FileIOType) (IOManager, error)
+	Stat(name string) (fs.FileInfo, error)
+	// ReadDir 返回目录下的文件，按照文件名排序
+	ReadDir(dir string) ([]fs.DirEntry, error)
+	MkdirAll(dir string) error
+	// Rename 重命名文件，目标文件存在时将其替换
+	Rename(oldName, newName string) error
+	Remove(name string) error
+	RemoveAll(path string) error
+	// Lock 获取文件锁，保证同一时刻只有一个实例使用数据目录，锁已经被持有时返回 ErrLocked
+	Lock(name string) (FileLock, error)
+}
+
+// FileLock 通过 VFS.Lock 获取的文件锁
+type FileLock interface {
+	Unlock() error
+}
+
+// OSFS 操作系统的文件系统
+type OSFS struct{}
+
+func (OSFS) OpenFile(name string, ioType FileIOType) (IOManager, error) {
+	return NewIOManager(name, ioType)
+}
+
+func (OSFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) ReadDir(dir string) ([]fs.DirEntry, error) {
+	return os.ReadDir(dir)
+}
+
+func (OSFS) MkdirAll(dir string) error {
+	return os.MkdirAll(dir, os.ModePerm)
+}
+
+func (OSFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+// Lock 使用 flock 获取文件锁，其他进程持有锁时返回 ErrLocked
+func (OSFS) Lock(name string) (FileLock, error) {
+	fileLock := flock.New(name)
+	hold, err := fileLock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !hold {
+		return nil, ErrLocked
+	}
+	return fileLock, nil
+}
+
+// ReadFile 读取文件的全部内容，文件不存在时返回 fs.ErrNotExist
+func ReadFile(vfs VFS, name string) ([]byte, error) {
+	info, err := vfs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := vfs.OpenFile(name, StandardFIO)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buf := make([]byte, info.Size())
+	n, err := file.Read(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// WriteFile 创建文件并写入 data，持久化之后返回，文件已经存在时覆盖原来的内容
+func WriteFile(vfs VFS, name string, data []byte) error {
+	if err := vfs.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	file, err := vfs.OpenFile(name, StandardFIO)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// DirSize 统计目录下所有文件的大小，包括子目录中的文件
+func DirSize(vfs VFS, dir string) (int64, error) {
+	entries, err := vfs.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, entry := range entries {
+		if entry.IsDir() {
+			n, err := DirSize(vfs, filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return 0, err
+			}
+			size += n
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// 统计期间被删除的临时文件
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
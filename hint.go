@@ -0,0 +1,213 @@
+package bitcask_go
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/fio"
+	"io"
+	"io/fs"
+)
+
+// 每个封存的数据文件对应一个 <id>.hint 文件，按照写入顺序保存文件中每条记录的 key、类型和位置，
+// 启动时从 hint 文件中加载封存文件的索引，只需要完整扫描活跃文件
+
+const (
+	// 生成 hint 文件和索引快照时使用的临时文件后缀，完成之后重命名
+	tmpFileSuffix = ".tmp"
+
+	// hint 文件写入缓冲区的大小
+	hintBufferSize = 64 * 1024
+)
+
+// hintEntry hint 文件中的一条记录
+type hintEntry struct {
+	key []byte // 带有事务序列号的 key
+	typ data.LogRecordType
+	pos *data.LogRecordPos
+}
+
+// hintMeta hint 文件的第一条记录，保存对应数据文件的长度和创建时间，不一致时说明 hint 已经失效
+func hintMeta(dataFile *data.DataFile) ([]byte, error) {
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.MaxVarintLen64*2)
+	index := binary.PutVarint(buf, size)
+	index += binary.PutVarint(buf[index:], dataFile.Header.CreatedAt.UnixNano())
+	return buf[:index], nil
+}
+
+type hintWriter struct {
+	vfs      fio.VFS
+	file     *data.DataFile
+	fileName string
+	buf      []byte
+}
+
+func newHintWriter(vfs fio.VFS, dirPath string, dataFile *data.DataFile) (*hintWriter, error) {
+	meta, err := hintMeta(dataFile)
+	if err != nil {
+		return nil, err
+	}
+	fileName := data.GetHintFileName(dirPath, dataFile.FileId)
+	// 清理上次生成时遗留的临时文件
+	if err := vfs.Remove(fileName + tmpFileSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	file, err := data.OpenHintFileByName(vfs, fileName+tmpFileSuffix)
+	if err != nil {
+		return nil, err
+	}
+	w := &hintWriter{vfs: vfs, file: file, fileName: fileName}
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Value: meta})
+	w.buf = append(make([]byte, 0, hintBufferSize), encRecord...)
+	return w, nil
+}
+
+func (w *hintWriter) add(logRecord *data.LogRecord, pos *data.LogRecordPos) error {
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecord.Key,
+		Value: data.EncodeLogRecordPos(pos),
+		Type:  logRecord.Type,
+	})
+	w.buf = append(w.buf, encRecord...)
+	if len(w.buf) >= hintBufferSize {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *hintWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.file.Write(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// commit 持久化临时文件之后重命名为正式的 hint 文件
+func (w *hintWriter) commit() error {
+	if err := w.flush(); err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		_ = w.vfs.Remove(w.fileName + tmpFileSuffix)
+		return err
+	}
+	return w.vfs.Rename(w.fileName+tmpFileSuffix, w.fileName)
+}
+
+func (w *hintWriter) abort() {
+	_ = w.file.Close()
+	_ = w.vfs.Remove(w.fileName + tmpFileSuffix)
+}
+
+// scanDataFile 从 offset 开始读取数据文件中的每一条记录，返回最后一条有效记录之后的偏移以及记录数
+func scanDataFile(dataFile *data.DataFile, offset int64, fn func(logRecord *data.LogRecord, pos *data.LogRecordPos) error) (int64, uint, error) {
+	var records uint = 0
+	for {
+		// 获取文件中的记录信息，大 value 分块读取校验，不读入内存
+		logRecord, valueReader, size, err := dataFile.ReadLogRecord(offset, data.StreamValueThreshold)
+		if err == nil && valueReader != nil {
+			err = valueReader.Verify()
+		}
+		// 截止条件：读到文件末尾
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, 0, err
+		}
+		// 获取记录对应文件信息
+		logRecordPos := &data.LogRecordPos{
+			Fid:    dataFile.FileId,
+			Offset: offset,
+			Size:   uint64(size),
+		}
+		if err := fn(logRecord, logRecordPos); err != nil {
+			return 0, 0, err
+		}
+		offset += size
+		records++
+	}
+	return offset, records, nil
+}
+
+// buildHintFile 扫描封存的数据文件生成对应的 hint 文件
+func (db *DB) buildHintFile(dataFile *data.DataFile) error {
+	writer, err := newHintWriter(db.vfs, db.options.DirPath, dataFile)
+	if err != nil {
+		return err
+	}
+	if _, _, err := scanDataFile(dataFile, data.FileHeaderSize, writer.add); err != nil {
+		writer.abort()
+		return err
+	}
+	return writer.commit()
+}
+
+// buildHintFileAsync 活跃文件封存之后在后台生成 hint 文件，失败时下次启动会重新生成
+func (db *DB) buildHintFileAsync(dataFile *data.DataFile) {
+	db.bgWg.Add(1)
+	go func() {
+		defer db.bgWg.Done()
+		_ = db.buildHintFile(dataFile)
+	}()
+}
+
+// readHintFile 读取数据文件对应的 hint 文件，hint 文件不存在或者已经失效时返回 false
+func (db *DB) readHintFile(dataFile *data.DataFile) ([]*hintEntry, bool, error) {
+	fileName := data.GetHintFileName(db.options.DirPath, dataFile.FileId)
+	if _, err := db.vfs.Stat(fileName); errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	hintFile, err := data.OpenHintFileByName(db.vfs, fileName)
+	if err != nil {
+		return nil, false, err
+	}
+	defer hintFile.Close()
+
+	meta, err := hintMeta(dataFile)
+	if err != nil {
+		return nil, false, err
+	}
+	record, offset, err := hintFile.GetLogRecord(0)
+	if err != nil || string(record.Value) != string(meta) {
+		return nil, false, nil
+	}
+	var entries []*hintEntry
+	for {
+		record, size, err := hintFile.GetLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			// hint 文件损坏时重新扫描数据文件
+			return nil, false, nil
+		}
+		entries = append(entries, &hintEntry{
+			key: record.Key,
+			typ: record.Type,
+			pos: data.DecodeLogRecordPos(record.Value),
+		})
+		offset += size
+	}
+	return entries, true, nil
+}
+
+// removeHintFile 删除数据文件对应的 hint 文件
+func (db *DB) removeHintFile(fileId uint32) error {
+	err := db.vfs.Remove(data.GetHintFileName(db.options.DirPath, fileId))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
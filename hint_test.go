@@ -0,0 +1,77 @@
+package bitcask_go
+
+import (
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_HintFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	// 事务中的记录跨越多个数据文件
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 2000; i < 3000; i++ {
+		_ = wb.Put(utils.GetTestKey(i), utils.RandomValue(64))
+	}
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Close())
+
+	// 封存的数据文件都生成了 hint 文件
+	assert.True(t, len(db.olderFiles) > 3)
+	for fid := range db.olderFiles {
+		_, err := os.Stat(data.GetHintFileName(dir, fid))
+		assert.Nil(t, err)
+	}
+	_, err = os.Stat(data.GetHintFileName(dir, db.activeFile.FileId))
+	assert.True(t, os.IsNotExist(err))
+
+	checkDB := func() {
+		db2, err := Open(opts)
+		assert.Nil(t, err)
+		defer func() {
+			_ = db2.Close()
+		}()
+		assert.Equal(t, 2500, len(db2.ListKeys()))
+		for i := 0; i < 3000; i++ {
+			_, err := db2.Get(utils.GetTestKey(i))
+			if i < 500 {
+				assert.Equal(t, ErrKeyNotFound, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		}
+		assert.Equal(t, db.seqNo, db2.seqNo)
+		// 写入位置和原来的一致
+		assert.Equal(t, db.activeFile.WriteOff, db2.activeFile.WriteOff)
+	}
+	checkDB()
+
+	// 缺失的 hint 文件在启动时重新生成，和数据文件不匹配的 hint 文件被忽略
+	assert.Nil(t, os.Remove(data.GetHintFileName(dir, 1)))
+	stale, err := os.ReadFile(data.GetHintFileName(dir, 3))
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(data.GetHintFileName(dir, 2), stale, 0644))
+	checkDB()
+	_, err = os.Stat(data.GetHintFileName(dir, 1))
+	assert.Nil(t, err)
+	rebuilt, err := os.ReadFile(data.GetHintFileName(dir, 2))
+	assert.Nil(t, err)
+	assert.NotEqual(t, stale, rebuilt)
+}
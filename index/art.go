@@ -3,133 +3,306 @@ package index
 import (
 	"bytes"
 	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/google/btree"
 	goart "github.com/plar/go-adaptive-radix-tree"
-	"sort"
 	"sync"
 )
 
+// AdaptiveRadixTree 自适应基数树索引
+// 依赖的 ART 实现不支持复制，写入之后原来的遍历也不能继续，因此有正向迭代器在遍历时 tree 保持不变（写时复制），
+// 之后的写入暂存在 delta 中，读取时先查找 delta，最后一个迭代器关闭时再把 delta 合并到 tree 中
 type AdaptiveRadixTree struct {
-	tree goart.Tree
-	lock *sync.RWMutex
+	tree      goart.Tree
+	lock      *sync.RWMutex
+	delta     *btree.BTree // tree 被冻结之后的写入，位置为 nil 表示 key 已经被删除
+	iterators int          // 正在遍历 tree 的迭代器数量，大于 0 时 tree 不能修改
+	size      int
 }
 
 func NewART() *AdaptiveRadixTree {
 	return &AdaptiveRadixTree{
-		tree: goart.New(),
-		lock: new(sync.RWMutex),
+		tree:  goart.New(),
+		lock:  new(sync.RWMutex),
+		delta: btree.New(32),
 	}
 }
 
 func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	art.lock.Lock()
 	defer art.lock.Unlock()
-	oldValue, _ := art.tree.Insert(key, pos)
-	if oldValue == nil {
-		return nil
+	var oldPos *data.LogRecordPos
+	if art.iterators > 0 {
+		oldPos = art.get(key)
+		art.delta.ReplaceOrInsert(&Item{key: key, pos: pos})
+	} else if oldValue, _ := art.tree.Insert(key, pos); oldValue != nil {
+		oldPos = oldValue.(*data.LogRecordPos)
+	}
+	if oldPos == nil {
+		art.size++
 	}
-	return oldValue.(*data.LogRecordPos)
+	return oldPos
 }
 func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
 	art.lock.RLock()
 	defer art.lock.RUnlock()
-
-	value, found := art.tree.Search(key)
-	if !found {
-		return nil
-	}
-	return value.(*data.LogRecordPos)
+	return art.get(key)
 }
 func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	art.lock.Lock()
 	defer art.lock.Unlock()
-
-	oldValue, deleted := art.tree.Delete(key)
-	if oldValue == nil {
-		return nil, deleted
+	var oldPos *data.LogRecordPos
+	if art.iterators > 0 {
+		if oldPos = art.get(key); oldPos != nil {
+			art.delta.ReplaceOrInsert(&Item{key: key})
+		}
+	} else if oldValue, _ := art.tree.Delete(key); oldValue != nil {
+		oldPos = oldValue.(*data.LogRecordPos)
 	}
-	return oldValue.(*data.LogRecordPos), deleted
+	if oldPos == nil {
+		return nil, false
+	}
+	art.size--
+	return oldPos, true
 }
 func (art *AdaptiveRadixTree) Size() int {
 	art.lock.RLock()
 	defer art.lock.RUnlock()
 
-	return art.tree.Size()
+	return art.size
 }
 func (art *AdaptiveRadixTree) Close() error {
 	return nil
 }
+
+// get 先查找 delta 再查找 tree，调用方需要持有锁
+func (art *AdaptiveRadixTree) get(key []byte) *data.LogRecordPos {
+	if art.delta.Len() > 0 {
+		if item := art.delta.Get(&Item{key: key}); item != nil {
+			return item.(*Item).pos
+		}
+	}
+	value, found := art.tree.Search(key)
+	if !found {
+		return nil
+	}
+	return value.(*data.LogRecordPos)
+}
+
+// Iterator 正向遍历时在冻结的 tree 上流式遍历，不需要复制 key，迭代器看到的是创建时的数据，关闭之前 tree 不会被修改
+// 依赖的 ART 实现不支持反向遍历，反向遍历时仍然需要复制所有的 key
 func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
 	if art.tree == nil {
 		return nil
 	}
-	art.lock.RLock()
-	defer art.lock.RUnlock()
-
-	return newArtIterator(art.tree, reverse)
+	if reverse {
+		art.lock.RLock()
+		defer art.lock.RUnlock()
+		return newArtIterator(art.items(nil), reverse)
+	}
+	// delta 的 Clone 是写时复制的，之后的写入不影响迭代器
+	art.lock.Lock()
+	art.iterators++
+	delta := art.delta.Clone()
+	art.lock.Unlock()
+	it := &artStreamIterator{art: art, delta: newBTreeIterator(delta, false)}
+	it.Rewind()
+	return it
 }
 
-type artIterator struct {
-	curIndex int     //当前遍历的下标位置
-	reverse  bool    //是否反向
-	values   []*Item // 所有key+位置索引信息
+// PrefixIterator 只复制前缀为 prefix 的 key
+func (art *AdaptiveRadixTree) PrefixIterator(prefix []byte, reverse bool) Iterator {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+	return newArtIterator(art.items(prefix), reverse)
 }
 
-func newArtIterator(tree goart.Tree, reverse bool) *artIterator {
-	var idx int
-	values := make([]*Item, tree.Size())
-
-	if reverse {
-		idx = tree.Size() - 1
+// items 按照 key 的顺序返回 tree 和 delta 中前缀为 prefix 的数据，prefix 为 nil 时返回所有数据，调用方需要持有锁
+func (art *AdaptiveRadixTree) items(prefix []byte) []*Item {
+	var values []*Item
+	collect := func(node goart.Node) bool {
+		// ForEachPrefix 会访问中间节点
+		if node.Kind() == goart.Leaf {
+			values = append(values, &Item{key: node.Key(), pos: node.Value().(*data.LogRecordPos)})
+		}
+		return true
+	}
+	if prefix == nil {
+		values = make([]*Item, 0, art.size)
+		art.tree.ForEach(collect)
+	} else {
+		art.tree.ForEachPrefix(prefix, collect)
+	}
+	if art.delta.Len() == 0 {
+		return values
 	}
 
-	saveItems := func(node goart.Node) bool {
-		values[idx] = &Item{
-			key: node.Key(),
-			pos: node.Value().(*data.LogRecordPos),
+	// 合并 delta 中的数据，相同的 key 使用 delta 中的位置，跳过已经删除的 key
+	merged := make([]*Item, 0, len(values))
+	i := 0
+	art.delta.AscendGreaterOrEqual(&Item{key: prefix}, func(it btree.Item) bool {
+		item := it.(*Item)
+		if !bytes.HasPrefix(item.key, prefix) {
+			return false
 		}
-		if reverse {
-			idx--
-		} else {
-			idx++
+		for ; i < len(values) && bytes.Compare(values[i].key, item.key) < 0; i++ {
+			merged = append(merged, values[i])
+		}
+		if i < len(values) && bytes.Equal(values[i].key, item.key) {
+			i++
+		}
+		if item.pos != nil {
+			merged = append(merged, item)
 		}
 		return true
+	})
+	return append(merged, values[i:]...)
+}
+
+// releaseIterator 关闭一个正向迭代器，最后一个迭代器关闭时把 delta 合并到 tree 中
+func (art *AdaptiveRadixTree) releaseIterator() {
+	art.lock.Lock()
+	defer art.lock.Unlock()
+	art.iterators--
+	if art.iterators > 0 || art.delta.Len() == 0 {
+		return
 	}
+	art.delta.Ascend(func(it btree.Item) bool {
+		item := it.(*Item)
+		if item.pos == nil {
+			art.tree.Delete(item.key)
+		} else {
+			art.tree.Insert(item.key, item.pos)
+		}
+		return true
+	})
+	art.delta = btree.New(32)
+}
 
-	tree.ForEach(saveItems)
-	return &artIterator{
-		curIndex: 0,
-		reverse:  reverse,
-		values:   values,
+func newArtIterator(values []*Item, reverse bool) *sliceIterator {
+	if reverse {
+		for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+			values[i], values[j] = values[j], values[i]
+		}
 	}
+	return &sliceIterator{reverse: reverse, values: values}
 }
 
-func (arti *artIterator) Rewind() {
-	arti.curIndex = 0
+// artStreamIterator 合并冻结的 tree 和创建时 delta 的副本，相同的 key 使用 delta 中的位置
+// tree 在迭代器关闭之前不会被修改，遍历 tree 时不需要加锁
+type artStreamIterator struct {
+	art     *AdaptiveRadixTree
+	base    goart.Iterator
+	baseKey []byte // tree 中的当前数据，遍历完时为 nil
+	basePos *data.LogRecordPos
+	delta   *btreeIterator
+
+	key       []byte
+	pos       *data.LogRecordPos
+	fromBase  bool // 当前数据来自 tree，Next 时需要前进
+	fromDelta bool // 当前数据来自 delta，Next 时需要前进
+	closed    bool
 }
-func (arti *artIterator) Seek(key []byte) {
-	if arti.reverse {
-		arti.curIndex = sort.Search(len(arti.values), func(i int) bool {
-			return bytes.Compare(arti.values[i].key, key) <= 0
-		})
-	} else {
-		arti.curIndex = sort.Search(len(arti.values), func(i int) bool {
-			return bytes.Compare(arti.values[i].key, key) >= 0
-		})
+
+func (ai *artStreamIterator) Rewind() {
+	if ai.closed {
+		return
 	}
+	ai.base = ai.art.tree.Iterator()
+	ai.nextBase()
+	ai.delta.Rewind()
+	ai.settle()
+}
+
+// Seek ART 实现不支持从指定 key 开始遍历，从头跳过 tree 中小于 key 的数据
+func (ai *artStreamIterator) Seek(key []byte) {
+	if ai.closed {
+		return
+	}
+	ai.base = ai.art.tree.Iterator()
+	for ai.nextBase(); ai.baseKey != nil && bytes.Compare(ai.baseKey, key) < 0; {
+		ai.nextBase()
+	}
+	ai.delta.Seek(key)
+	ai.settle()
+}
+
+func (ai *artStreamIterator) Next() {
+	ai.advance()
+	ai.settle()
 }
 
-func (arti *artIterator) Next() {
-	arti.curIndex += 1
+func (ai *artStreamIterator) Valid() bool {
+	return ai.fromBase || ai.fromDelta
 }
-func (arti *artIterator) Valid() bool {
-	return arti.curIndex < len(arti.values)
+
+func (ai *artStreamIterator) Key() []byte {
+	return ai.key
 }
-func (arti *artIterator) Key() []byte {
-	return arti.values[arti.curIndex].key
+
+func (ai *artStreamIterator) Value() *data.LogRecordPos {
+	return ai.pos
+}
+
+func (ai *artStreamIterator) Close() {
+	if ai.closed {
+		return
+	}
+	ai.closed = true
+	ai.base, ai.baseKey, ai.basePos = nil, nil, nil
+	ai.fromBase, ai.fromDelta = false, false
+	ai.delta.Close()
+	ai.art.releaseIterator()
 }
-func (arti *artIterator) Value() *data.LogRecordPos {
-	return arti.values[arti.curIndex].pos
+
+// nextBase 取出 tree 中的下一条数据
+func (ai *artStreamIterator) nextBase() {
+	ai.baseKey, ai.basePos = nil, nil
+	if ai.base == nil || !ai.base.HasNext() {
+		return
+	}
+	// tree 被冻结，不会出现并发修改的错误
+	node, err := ai.base.Next()
+	if err != nil {
+		return
+	}
+	ai.baseKey, ai.basePos = node.Key(), node.Value().(*data.LogRecordPos)
 }
-func (arti *artIterator) Close() {
-	arti.values = nil
+
+// advance 越过当前数据，两边有相同的 key 时同时前进
+func (ai *artStreamIterator) advance() {
+	if ai.fromBase {
+		ai.nextBase()
+	}
+	if ai.fromDelta {
+		ai.delta.Next()
+	}
+}
+
+// settle 定位到 tree 和 delta 中较小的 key，跳过在 delta 中被删除的 key
+func (ai *artStreamIterator) settle() {
+	for {
+		baseValid, deltaValid := ai.baseKey != nil, ai.delta.Valid()
+		ai.fromBase, ai.fromDelta = false, false
+		switch {
+		case !baseValid && !deltaValid:
+			ai.key, ai.pos = nil, nil
+			return
+		case !deltaValid:
+			ai.fromBase = true
+		case !baseValid:
+			ai.fromDelta = true
+		default:
+			cmp := bytes.Compare(ai.baseKey, ai.delta.Key())
+			ai.fromBase, ai.fromDelta = cmp <= 0, cmp >= 0
+		}
+		if ai.fromDelta {
+			ai.key, ai.pos = ai.delta.Key(), ai.delta.Value()
+		} else {
+			ai.key, ai.pos = ai.baseKey, ai.basePos
+		}
+		if ai.pos != nil {
+			return
+		}
+		ai.advance()
+	}
 }
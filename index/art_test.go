@@ -1,8 +1,12 @@
 package index
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/Tuanzi-bug/TuanKV/data"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
 	"testing"
 )
 
@@ -76,3 +80,165 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestAdaptiveRadixTree_PrefixIterator(t *testing.T) {
+	art := NewART()
+	for _, key := range []string{"a", "ab", "abc", "abd", "b", "bab"} {
+		art.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 12})
+	}
+
+	var keys []string
+	iter := art.PrefixIterator([]byte("ab"), false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.Close()
+	assert.Equal(t, []string{"ab", "abc", "abd"}, keys)
+
+	keys = nil
+	iter = art.PrefixIterator([]byte("ab"), true)
+	for iter.Seek([]byte("abc")); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.Close()
+	assert.Equal(t, []string{"abc", "ab"}, keys)
+
+	iter = art.PrefixIterator([]byte("c"), false)
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
+
+func TestAdaptiveRadixTree_IteratorSnapshot(t *testing.T) {
+	art := NewART()
+	for i := 0; i < 1000; i++ {
+		art.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// 遍历期间的写入暂存在 delta 中，迭代器只看到创建时的数据，读取可以看到新的数据
+	iter := art.Iterator(false)
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%04d", count), string(iter.Key()))
+		assert.Equal(t, int64(count), iter.Value().Offset)
+		if count%2 == 0 {
+			art.Delete([]byte(fmt.Sprintf("key-%04d", count+1)))
+			art.Put([]byte(fmt.Sprintf("key-%04d", count)), &data.LogRecordPos{Fid: 2, Offset: int64(count)})
+			art.Put([]byte(fmt.Sprintf("key-%04d-new", count)), &data.LogRecordPos{Fid: 2})
+		}
+		count++
+	}
+	assert.Equal(t, 1000, count)
+	assert.Equal(t, 1000, art.Size())
+	assert.Nil(t, art.Get([]byte("key-0001")))
+	assert.Equal(t, uint32(2), art.Get([]byte("key-0000")).Fid)
+
+	// Seek 跳过小于 key 的数据
+	iter.Seek([]byte("key-0990"))
+	count = 0
+	for ; iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, 10, count)
+	iter.Close()
+
+	// 关闭之后 delta 合并到 tree 中
+	assert.Equal(t, 0, art.delta.Len())
+	assert.Equal(t, 1000, art.Size())
+	iter = art.Iterator(false)
+	count = 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, uint32(2), iter.Value().Fid)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 1000, count)
+}
+
+func TestAdaptiveRadixTree_IteratorModel(t *testing.T) {
+	art := NewART()
+	model := make(map[string]int64)
+	rnd := rand.New(rand.NewSource(1))
+	randomKey := func() []byte {
+		// 包含 0 字节以及互为前缀的 key
+		key := make([]byte, 1+rnd.Intn(3))
+		for i := range key {
+			key[i] = byte(rnd.Intn(4))
+		}
+		return key
+	}
+	check := func() {
+		var expected []string
+		for key := range model {
+			expected = append(expected, key)
+		}
+		sort.Strings(expected)
+		var keys []string
+		iter := art.Iterator(false)
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			assert.Equal(t, model[string(iter.Key())], iter.Value().Offset)
+			keys = append(keys, string(iter.Key()))
+		}
+		iter.Close()
+		assert.Equal(t, expected, keys)
+
+		keys = keys[:0]
+		iter = art.Iterator(true)
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		for i := range keys {
+			assert.Equal(t, expected[len(expected)-1-i], keys[i])
+		}
+		assert.Equal(t, len(model), art.Size())
+
+		prefix := []byte{1}
+		keys = keys[:0]
+		iter = art.PrefixIterator(prefix, false)
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		var expectedPrefix []string
+		for _, key := range expected {
+			if bytes.HasPrefix([]byte(key), prefix) {
+				expectedPrefix = append(expectedPrefix, key)
+			}
+		}
+		assert.Equal(t, len(expectedPrefix), len(keys))
+	}
+
+	var open []Iterator
+	for i := 0; i < 2000; i++ {
+		key := randomKey()
+		switch rnd.Intn(10) {
+		case 0, 1, 2:
+			_, deleted := art.Delete(key)
+			_, ok := model[string(key)]
+			assert.Equal(t, ok, deleted)
+			delete(model, string(key))
+		case 3:
+			// 打开和关闭正向迭代器，写入在暂存和直接写入 tree 之间切换
+			if len(open) < 3 {
+				open = append(open, art.Iterator(false))
+			} else {
+				open[0].Close()
+				open = open[1:]
+			}
+		case 4:
+			check()
+		default:
+			art.Put(key, &data.LogRecordPos{Offset: int64(i)})
+			model[string(key)] = int64(i)
+		}
+		if pos := art.Get(key); pos != nil {
+			assert.Equal(t, model[string(key)], pos.Offset)
+		} else {
+			_, ok := model[string(key)]
+			assert.False(t, ok)
+		}
+	}
+	for _, iter := range open {
+		iter.Close()
+	}
+	check()
+	assert.Equal(t, 0, art.delta.Len())
+}
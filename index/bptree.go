@@ -1,8 +1,11 @@
 package index
 
 import (
+	"bytes"
+	"encoding/binary"
 	"github.com/Tuanzi-bug/TuanKV/data"
 	"go.etcd.io/bbolt"
+	"io"
 	"path/filepath"
 )
 
@@ -10,83 +13,156 @@ const (
 	BPlusTreeIndexFileName = "bptree-index"
 )
 
-var indexBucketName = []byte("bitcask-index")
+var (
+	indexBucketName = []byte("bitcask-index")
+	metaBucketName  = []byte("bitcask-meta")
+	checkpointKey   = []byte("checkpoint")
+)
+
+const checkpointSize = 4 + 8 + 8 + 8 + 4
 
+// BPlusTree 使用 bbolt 持久化在磁盘上的索引，启动时不需要扫描数据文件重建索引
+// Put、Get 和 Delete 出错时返回空结果，需要知道错误时使用 ApplyBatch
 type BPlusTree struct {
 	tree *bbolt.DB
 }
 
-func NewBPlusTree(dirPath string) *BPlusTree {
-	// todo: 解决bptree多个参数传递
-	opts := bbolt.DefaultOptions
-	opts.NoSync = true
-	bpTree, err := bbolt.Open(filepath.Join(dirPath, BPlusTreeIndexFileName), 0644, opts)
+// Checkpoint 和索引在同一个事务中更新，记录索引已经包含的日志位置，启动时从这里开始重放数据文件
+type Checkpoint struct {
+	Fid           uint32 // 索引覆盖到的数据文件
+	Offset        int64  // 该文件中已经写入索引的数据末尾
+	FileCreatedAt int64  // 数据文件的创建时间，用来判断数据文件是否被 merge 或者回收替换
+	SeqNo         uint64 // 最近一次提交的事务序列号
+	MergeFid      uint32 // 索引中已经包含的 merge 结果对应的 nonMergeFileId，没有 merge 时为 0
+}
+
+// BatchOp 批量更新索引中的一个操作，Delete 为 true 时删除 key，Pos 为删除记录自身的位置
+type BatchOp struct {
+	Key    []byte
+	Pos    *data.LogRecordPos
+	Delete bool
+}
+
+// NewBPlusTree 打开 dirPath 中的索引文件，syncWrites 为 false 时提交事务不持久化，需要调用 Sync
+func NewBPlusTree(dirPath string, syncWrites bool) (*BPlusTree, error) {
+	opts := *bbolt.DefaultOptions
+	opts.NoSync = !syncWrites
+	bpTree, err := bbolt.Open(filepath.Join(dirPath, BPlusTreeIndexFileName), 0644, &opts)
 	if err != nil {
-		panic("failed to open bpTree")
+		return nil, err
 	}
 
 	if err := bpTree.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(indexBucketName)
+		if _, err := tx.CreateBucketIfNotExists(indexBucketName); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(metaBucketName)
 		return err
 	}); err != nil {
-		panic("failed to create bucket in bpTree")
+		_ = bpTree.Close()
+		return nil, err
 	}
 
-	return &BPlusTree{tree: bpTree}
+	return &BPlusTree{tree: bpTree}, nil
 }
 func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
-	var oldVal []byte
-	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(indexBucketName)
-		oldVal = b.Get(key)
-		err := b.Put(key, data.EncodeLogRecordPos(pos))
-		return err
-	}); err != nil {
-		panic("filed to put value in bpTree")
+	oldPositions, err := bpt.ApplyBatch([]BatchOp{{Key: key, Pos: pos}}, nil)
+	if err != nil {
+		return nil
 	}
-
-	return data.DecodeLogRecordPos(oldVal)
+	return oldPositions[0]
 }
 func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 	var pos *data.LogRecordPos
-	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
+	_ = bpt.tree.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(indexBucketName)
 		v := b.Get(key)
 		if len(v) != 0 {
 			pos = data.DecodeLogRecordPos(v)
 		}
 		return nil
-	}); err != nil {
-		panic("failed to put value in bpTree")
-	}
+	})
 	return pos
 }
 func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
-	var ok bool
-	var oldValue []byte
-	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
+	oldPositions, err := bpt.ApplyBatch([]BatchOp{{Key: key, Delete: true}}, nil)
+	if err != nil || oldPositions[0] == nil {
+		return nil, false
+	}
+	return oldPositions[0], true
+}
+
+// ApplyBatch 在一个事务中依次执行 ops，返回每个 key 被覆盖或者删除的旧位置
+// checkpoint 不为 nil 时在同一个事务中更新，保证索引和 checkpoint 一致
+func (bpt *BPlusTree) ApplyBatch(ops []BatchOp, checkpoint *Checkpoint) ([]*data.LogRecordPos, error) {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(indexBucketName)
-		if v := b.Get(key); len(v) != 0 {
-			oldValue = v
-			ok = true
-			return b.Delete(key)
+		for i, op := range ops {
+			// bolt 返回的数据只在事务内有效，解码之后再修改
+			oldPositions[i] = data.DecodeLogRecordPos(b.Get(op.Key))
+			var err error
+			if op.Delete {
+				if oldPositions[i] != nil {
+					err = b.Delete(op.Key)
+				}
+			} else {
+				err = b.Put(op.Key, data.EncodeLogRecordPos(op.Pos))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if checkpoint != nil {
+			return tx.Bucket(metaBucketName).Put(checkpointKey, encodeCheckpoint(checkpoint))
 		}
 		return nil
-	}); err != nil {
-		panic("failed to delete value in bpTree")
+	})
+	if err != nil {
+		return nil, err
 	}
-	return data.DecodeLogRecordPos(oldValue), ok
+	return oldPositions, nil
+}
+
+// Checkpoint 返回最近一次保存的 checkpoint，没有时返回 nil
+func (bpt *BPlusTree) Checkpoint() (*Checkpoint, error) {
+	var checkpoint *Checkpoint
+	err := bpt.tree.View(func(tx *bbolt.Tx) error {
+		if buf := tx.Bucket(metaBucketName).Get(checkpointKey); len(buf) == checkpointSize {
+			checkpoint = decodeCheckpoint(buf)
+		}
+		return nil
+	})
+	return checkpoint, err
+}
+
+// Reset 清空索引和 checkpoint，之后需要重新加载所有数据
+func (bpt *BPlusTree) Reset() error {
+	return bpt.tree.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{indexBucketName, metaBucketName} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Sync 持久化没有同步写入的事务
+func (bpt *BPlusTree) Sync() error {
+	return bpt.tree.Sync()
 }
 
 func (bpt *BPlusTree) Size() int {
 	var size int
-	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
+	_ = bpt.tree.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(indexBucketName)
 		size = b.Stats().KeyN
 		return nil
-	}); err != nil {
-		panic("failed to get size in bpTree")
-	}
+	})
 	return size
 }
 
@@ -94,10 +170,53 @@ func (bpt *BPlusTree) Close() error {
 	return bpt.tree.Close()
 }
 
+// BPlusTreeSnapshot 索引文件在某一时刻的只读视图，用于在线备份，使用之后需要 Close
+type BPlusTreeSnapshot struct {
+	tx *bbolt.Tx
+}
+
+// Snapshot 开始一个只读事务，之后的写入不影响快照的内容
+func (bpt *BPlusTree) Snapshot() (*BPlusTreeSnapshot, error) {
+	tx, err := bpt.tree.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &BPlusTreeSnapshot{tx: tx}, nil
+}
+
+// WriteTo 写出快照对应的完整索引文件
+func (s *BPlusTreeSnapshot) WriteTo(w io.Writer) (int64, error) {
+	return s.tx.WriteTo(w)
+}
+
+func (s *BPlusTreeSnapshot) Close() error {
+	return s.tx.Rollback()
+}
+
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
 	return newBPlusTreeIterator(bpt.tree, reverse)
 }
 
+func encodeCheckpoint(checkpoint *Checkpoint) []byte {
+	buf := make([]byte, checkpointSize)
+	binary.LittleEndian.PutUint32(buf[0:4], checkpoint.Fid)
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(checkpoint.Offset))
+	binary.LittleEndian.PutUint64(buf[12:20], uint64(checkpoint.FileCreatedAt))
+	binary.LittleEndian.PutUint64(buf[20:28], checkpoint.SeqNo)
+	binary.LittleEndian.PutUint32(buf[28:32], checkpoint.MergeFid)
+	return buf
+}
+
+func decodeCheckpoint(buf []byte) *Checkpoint {
+	return &Checkpoint{
+		Fid:           binary.LittleEndian.Uint32(buf[0:4]),
+		Offset:        int64(binary.LittleEndian.Uint64(buf[4:12])),
+		FileCreatedAt: int64(binary.LittleEndian.Uint64(buf[12:20])),
+		SeqNo:         binary.LittleEndian.Uint64(buf[20:28]),
+		MergeFid:      binary.LittleEndian.Uint32(buf[28:32]),
+	}
+}
+
 type bPlusTreeIterator struct {
 	reverse  bool
 	tx       *bbolt.Tx
@@ -106,21 +225,23 @@ type bPlusTreeIterator struct {
 	curValue []byte
 }
 
+// newBPlusTreeIterator 在只读事务中遍历索引，开启事务失败时返回一个没有数据的迭代器
 func newBPlusTreeIterator(tree *bbolt.DB, reverse bool) *bPlusTreeIterator {
+	bpi := &bPlusTreeIterator{reverse: reverse}
 	tx, err := tree.Begin(false)
 	if err != nil {
-		panic("failed to begin a transaction")
-	}
-	bpi := &bPlusTreeIterator{
-		reverse: reverse,
-		tx:      tx,
-		cursor:  tx.Bucket(indexBucketName).Cursor(),
+		return bpi
 	}
+	bpi.tx = tx
+	bpi.cursor = tx.Bucket(indexBucketName).Cursor()
 	bpi.Rewind()
 	return bpi
 }
 
 func (bpt *bPlusTreeIterator) Rewind() {
+	if bpt.cursor == nil {
+		return
+	}
 	if bpt.reverse {
 		bpt.curKey, bpt.curValue = bpt.cursor.Last()
 	} else {
@@ -128,7 +249,18 @@ func (bpt *bPlusTreeIterator) Rewind() {
 	}
 }
 func (bpt *bPlusTreeIterator) Seek(key []byte) {
+	if bpt.cursor == nil {
+		return
+	}
 	bpt.curKey, bpt.curValue = bpt.cursor.Seek(key)
+	// 反向遍历时定位到最后一个不大于 key 的位置
+	if bpt.reverse {
+		if bpt.curKey == nil {
+			bpt.curKey, bpt.curValue = bpt.cursor.Last()
+		} else if !bytes.Equal(bpt.curKey, key) {
+			bpt.curKey, bpt.curValue = bpt.cursor.Prev()
+		}
+	}
 }
 
 func (bpt *bPlusTreeIterator) Next() {
@@ -149,5 +281,7 @@ func (bpt *bPlusTreeIterator) Value() *data.LogRecordPos {
 	return data.DecodeLogRecordPos(bpt.curValue)
 }
 func (bpt *bPlusTreeIterator) Close() {
-	_ = bpt.tx.Rollback()
+	if bpt.tx != nil {
+		_ = bpt.tx.Rollback()
+	}
 }
@@ -14,7 +14,8 @@ func TestBPlusTree_Put(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 	defer tree.Close()
 	res1 := tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
 	assert.Nil(t, res1)
@@ -35,7 +36,8 @@ func TestBPlusTree_Get(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 	defer tree.Close()
 	pos := tree.Get([]byte("not exist"))
 	assert.Nil(t, pos)
@@ -55,7 +57,8 @@ func TestBPlusTree_Delete(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 	defer tree.Close()
 	res1, ok1 := tree.Delete([]byte("not exist"))
 	assert.False(t, ok1)
@@ -77,7 +80,8 @@ func TestBPlusTree_Size(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 	defer tree.Close()
 	assert.Equal(t, 0, tree.Size())
 
@@ -94,7 +98,8 @@ func TestBPlusTree_Iterator(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 	defer tree.Close()
 	tree.Put([]byte("caac"), &data.LogRecordPos{Fid: 123, Offset: 999})
 	tree.Put([]byte("bbca"), &data.LogRecordPos{Fid: 123, Offset: 999})
@@ -109,3 +114,80 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestBPlusTree_ApplyBatch(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-batch")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree, err := NewBPlusTree(path, true)
+	assert.Nil(t, err)
+	checkpoint, err := tree.Checkpoint()
+	assert.Nil(t, err)
+	assert.Nil(t, checkpoint)
+
+	tree.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	ops := []BatchOp{
+		{Key: []byte("aaa"), Pos: &data.LogRecordPos{Fid: 2, Offset: 20}},
+		{Key: []byte("bbb"), Pos: &data.LogRecordPos{Fid: 2, Offset: 30}},
+		{Key: []byte("aaa"), Pos: &data.LogRecordPos{Fid: 2, Offset: 40}, Delete: true},
+		{Key: []byte("ccc"), Pos: &data.LogRecordPos{Fid: 2, Offset: 50}, Delete: true},
+	}
+	cp := &Checkpoint{Fid: 2, Offset: 60, FileCreatedAt: 123, SeqNo: 7, MergeFid: 1}
+	oldPositions, err := tree.ApplyBatch(ops, cp)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), oldPositions[0].Offset)
+	assert.Nil(t, oldPositions[1])
+	assert.Equal(t, int64(20), oldPositions[2].Offset)
+	assert.Nil(t, oldPositions[3])
+	assert.Nil(t, tree.Get([]byte("aaa")))
+	assert.Equal(t, int64(30), tree.Get([]byte("bbb")).Offset)
+	assert.Nil(t, tree.Close())
+
+	// 重新打开之后 checkpoint 和索引一致
+	tree, err = NewBPlusTree(path, true)
+	assert.Nil(t, err)
+	defer tree.Close()
+	checkpoint, err = tree.Checkpoint()
+	assert.Nil(t, err)
+	assert.Equal(t, cp, checkpoint)
+	assert.Equal(t, 1, tree.Size())
+
+	assert.Nil(t, tree.Reset())
+	checkpoint, err = tree.Checkpoint()
+	assert.Nil(t, err)
+	assert.Nil(t, checkpoint)
+	assert.Equal(t, 0, tree.Size())
+}
+
+func TestBPlusTree_IteratorSeek(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-seek")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
+	defer tree.Close()
+	for _, key := range []string{"aa", "bb", "cc"} {
+		tree.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 1})
+	}
+
+	iter := tree.Iterator(false)
+	iter.Seek([]byte("b"))
+	assert.Equal(t, "bb", string(iter.Key()))
+	iter.Close()
+
+	// 反向遍历时定位到最后一个不大于 key 的位置
+	iter = tree.Iterator(true)
+	iter.Seek([]byte("bc"))
+	assert.Equal(t, "bb", string(iter.Key()))
+	iter.Seek([]byte("bb"))
+	assert.Equal(t, "bb", string(iter.Key()))
+	iter.Seek([]byte("zz"))
+	assert.Equal(t, "cc", string(iter.Key()))
+	iter.Seek([]byte("a"))
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"github.com/Tuanzi-bug/TuanKV/data"
 	"github.com/google/btree"
-	"sort"
 	"sync"
 )
 
@@ -37,6 +36,8 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 }
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	btreeItem := bt.tree.Get(it)
 	if btreeItem == nil {
 		return nil
@@ -54,16 +55,22 @@ func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	return oldItem.(*Item).pos, true
 }
 
+// Iterator 在树的副本上流式遍历，不需要复制所有的 key
+// Clone 是写时复制的，创建时只复制根节点，之后的写入只复制修改过的节点，迭代器看到的是创建时的数据
 func (bt *BTree) Iterator(reverse bool) Iterator {
 	if bt.tree == nil {
 		return nil
 	}
-	bt.lock.RLock()
-	defer bt.lock.RUnlock()
-	return newBTreeIterator(bt.tree, reverse)
+	// Clone 会修改原来的树，需要加写锁
+	bt.lock.Lock()
+	tree := bt.tree.Clone()
+	bt.lock.Unlock()
+	return newBTreeIterator(tree, reverse)
 }
 
 func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	return bt.tree.Len()
 }
 
@@ -71,59 +78,75 @@ func (bt *BTree) Close() error {
 	return nil
 }
 
+// btreeIteratorBatch 迭代器每次从树中取出的数据条数
+const btreeIteratorBatch = 64
+
 type btreeIterator struct {
-	curIndex int     //当前遍历的下标位置
-	reverse  bool    //是否反向
-	values   []*Item // 所有key+位置索引信息
+	tree    *btree.BTree // 创建迭代器时复制的树，不会再被修改
+	reverse bool         //是否反向
+	batch   []*Item      // 当前从树中取出的一批数据
+	index   int          // 当前遍历到 batch 中的位置
+	more    bool         // batch 之后树中是否还有数据
 }
 
 func newBTreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
-	var idx int
-	values := make([]*Item, tree.Len())
-	SearchItem := func(it btree.Item) bool {
-		values[idx] = it.(*Item)
-		idx++
-		return true
-	}
-	if reverse {
-		tree.Descend(SearchItem)
-	} else {
-		tree.Ascend(SearchItem)
-	}
-	return &btreeIterator{
-		curIndex: 0,
-		reverse:  reverse,
-		values:   values,
+	it := &btreeIterator{
+		tree:    tree,
+		reverse: reverse,
+		batch:   make([]*Item, 0, btreeIteratorBatch),
 	}
+	it.Rewind()
+	return it
 }
 
-func (bpt *btreeIterator) Rewind() {
-	bpt.curIndex = 0
-}
-func (bpt *btreeIterator) Seek(key []byte) {
-	if bpt.reverse {
-		bpt.curIndex = sort.Search(len(bpt.values), func(i int) bool {
-			return bytes.Compare(bpt.values[i].key, key) <= 0
-		})
-	} else {
-		bpt.curIndex = sort.Search(len(bpt.values), func(i int) bool {
-			return bytes.Compare(bpt.values[i].key, key) >= 0
-		})
+func (bti *btreeIterator) Rewind() {
+	bti.fill(nil, true)
+}
+func (bti *btreeIterator) Seek(key []byte) {
+	bti.fill(key, true)
+}
+func (bti *btreeIterator) Next() {
+	bti.index += 1
+	if bti.index >= len(bti.batch) && bti.more {
+		bti.fill(bti.batch[len(bti.batch)-1].key, false)
 	}
 }
-
-func (bpt *btreeIterator) Next() {
-	bpt.curIndex += 1
+func (bti *btreeIterator) Valid() bool {
+	return bti.index < len(bti.batch)
 }
-func (bpt *btreeIterator) Valid() bool {
-	return bpt.curIndex < len(bpt.values)
+func (bti *btreeIterator) Key() []byte {
+	return bti.batch[bti.index].key
 }
-func (bpt *btreeIterator) Key() []byte {
-	return bpt.values[bpt.curIndex].key
+func (bti *btreeIterator) Value() *data.LogRecordPos {
+	return bti.batch[bti.index].pos
 }
-func (bpt *btreeIterator) Value() *data.LogRecordPos {
-	return bpt.values[bpt.curIndex].pos
+func (bti *btreeIterator) Close() {
+	bti.tree, bti.batch = nil, nil
 }
-func (bpt *btreeIterator) Close() {
-	bpt.values = nil
+
+// fill 从 from 开始取出下一批数据，from 为 nil 时从头开始，inclusive 为 false 时跳过 from 本身
+func (bti *btreeIterator) fill(from []byte, inclusive bool) {
+	bti.batch, bti.index, bti.more = bti.batch[:0], 0, false
+	collect := func(it btree.Item) bool {
+		item := it.(*Item)
+		if !inclusive && bytes.Equal(item.key, from) {
+			return true
+		}
+		if len(bti.batch) == btreeIteratorBatch {
+			bti.more = true
+			return false
+		}
+		bti.batch = append(bti.batch, item)
+		return true
+	}
+	switch {
+	case from == nil && bti.reverse:
+		bti.tree.Descend(collect)
+	case from == nil:
+		bti.tree.Ascend(collect)
+	case bti.reverse:
+		bti.tree.DescendLessOrEqual(&Item{key: from}, collect)
+	default:
+		bti.tree.AscendGreaterOrEqual(&Item{key: from}, collect)
+	}
 }
@@ -1,6 +1,7 @@
 package index
 
 import (
+	"fmt"
 	"github.com/Tuanzi-bug/TuanKV/data"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -99,3 +100,55 @@ func TestBTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter6.Key())
 	}
 }
+
+func TestBTree_IteratorSnapshot(t *testing.T) {
+	bt := NewBTree()
+	for i := 0; i < 1000; i++ {
+		bt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	iter := bt.Iterator(false)
+	reverseIter := bt.Iterator(true)
+	// 创建迭代器之后的写入对迭代器不可见
+	for i := 0; i < 1000; i += 2 {
+		bt.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+	}
+	bt.Put([]byte("key-0001"), &data.LogRecordPos{Fid: 2, Offset: 1})
+	bt.Put([]byte("key-5000"), &data.LogRecordPos{Fid: 2, Offset: 5000})
+
+	// 遍历的数据超过一批
+	var i int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%04d", i), string(iter.Key()))
+		assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: int64(i)}, iter.Value())
+		i++
+	}
+	assert.Equal(t, 1000, i)
+	iter.Seek([]byte("key-0500"))
+	assert.Equal(t, "key-0500", string(iter.Key()))
+	iter.Seek([]byte("key-05005"))
+	assert.Equal(t, "key-0501", string(iter.Key()))
+	iter.Seek([]byte("key-1000"))
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	i = 999
+	for reverseIter.Rewind(); reverseIter.Valid(); reverseIter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%04d", i), string(reverseIter.Key()))
+		i--
+	}
+	assert.Equal(t, -1, i)
+	reverseIter.Seek([]byte("key-05005"))
+	assert.Equal(t, "key-0500", string(reverseIter.Key()))
+	reverseIter.Next()
+	assert.Equal(t, "key-0499", string(reverseIter.Key()))
+	reverseIter.Close()
+
+	// 新的迭代器可以看到之前的写入
+	iter = bt.Iterator(false)
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, 501, count)
+	iter.Close()
+}
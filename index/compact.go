@@ -0,0 +1,285 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"sort"
+	"sync"
+)
+
+const (
+	compactChunkSize = 1 << 20 // arena 中每个内存块的大小
+	compactBlockSize = 512     // 每个有序块最多保存的 key 数量，超过之后分裂
+)
+
+// CompactIndex 紧凑的内存索引，key 和变长编码的位置信息连续保存在大块的 arena 中
+// 有序结构中只保存条目在 arena 中的引用（块号 << 32 | 块内偏移），不包含指针，
+// GC 只需要扫描很少的对象，适合 key 数量非常多的场景
+// 更新和删除时旧的条目不会立刻回收，失效的数据超过一半时重新整理 arena
+type CompactIndex struct {
+	lock      *sync.RWMutex
+	chunks    [][]byte   // arena 的内存块，已经写入的数据不会再修改
+	blocks    [][]uint64 // 按 key 有序排列的引用块，每个块内部有序，前一个块的 key 都小于后一个块
+	size      int
+	arenaSize int64 // arena 中已经使用的字节数
+	garbage   int64 // arena 中已经失效的字节数
+}
+
+// MemoryUsage 索引占用的内存
+type MemoryUsage struct {
+	Keys         int
+	ArenaBytes   int64 // arena 分配的内存
+	GarbageBytes int64 // arena 中已经失效、等待整理的数据
+	RefBytes     int64 // 有序引用块占用的内存
+}
+
+func (m MemoryUsage) Total() int64 {
+	return m.ArenaBytes + m.RefBytes
+}
+
+// MemoryReporter 可以报告自身内存占用的索引
+type MemoryReporter interface {
+	MemoryUsage() MemoryUsage
+}
+
+func NewCompactIndex() *CompactIndex {
+	return &CompactIndex{lock: new(sync.RWMutex)}
+}
+
+func (c *CompactIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	bi, i, found := c.search(key)
+	ref := c.appendEntry(key, pos)
+	if found {
+		oldRef := c.blocks[bi][i]
+		_, oldPos, n := decodeCompactEntry(c.chunks, oldRef)
+		c.blocks[bi][i] = ref
+		c.garbage += int64(n)
+		c.maybeCompact()
+		return oldPos
+	}
+
+	c.size++
+	if len(c.blocks) == 0 {
+		c.blocks = append(c.blocks, []uint64{ref})
+		return nil
+	}
+	block := append(c.blocks[bi], 0)
+	copy(block[i+1:], block[i:])
+	block[i] = ref
+	c.blocks[bi] = block
+	// 块满了之后分裂成两个块
+	if len(block) > compactBlockSize {
+		half := len(block) / 2
+		left := append(make([]uint64, 0, compactBlockSize), block[:half]...)
+		right := append(make([]uint64, 0, compactBlockSize), block[half:]...)
+		c.blocks = append(c.blocks, nil)
+		copy(c.blocks[bi+2:], c.blocks[bi+1:])
+		c.blocks[bi], c.blocks[bi+1] = left, right
+	}
+	return nil
+}
+
+func (c *CompactIndex) Get(key []byte) *data.LogRecordPos {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	bi, i, found := c.search(key)
+	if !found {
+		return nil
+	}
+	_, pos, _ := decodeCompactEntry(c.chunks, c.blocks[bi][i])
+	return pos
+}
+
+func (c *CompactIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	bi, i, found := c.search(key)
+	if !found {
+		return nil, false
+	}
+	_, oldPos, n := decodeCompactEntry(c.chunks, c.blocks[bi][i])
+	block := c.blocks[bi]
+	copy(block[i:], block[i+1:])
+	block = block[:len(block)-1]
+	if len(block) == 0 {
+		copy(c.blocks[bi:], c.blocks[bi+1:])
+		c.blocks[len(c.blocks)-1] = nil
+		c.blocks = c.blocks[:len(c.blocks)-1]
+	} else {
+		c.blocks[bi] = block
+	}
+	c.size--
+	c.garbage += int64(n)
+	c.maybeCompact()
+	return oldPos, true
+}
+
+// Iterator 只复制所有条目的引用（每个 key 8 个字节），arena 中的数据不会被修改，遍历时按需解码
+func (c *CompactIndex) Iterator(reverse bool) Iterator {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	refs := make([]uint64, 0, c.size)
+	for _, block := range c.blocks {
+		refs = append(refs, block...)
+	}
+	if reverse {
+		for i, j := 0, len(refs)-1; i < j; i, j = i+1, j-1 {
+			refs[i], refs[j] = refs[j], refs[i]
+		}
+	}
+	// 写入时会修改内存块的长度，迭代器持有内存块列表的副本
+	chunks := append([][]byte(nil), c.chunks...)
+	return &compactIterator{chunks: chunks, refs: refs, reverse: reverse}
+}
+
+func (c *CompactIndex) Size() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.size
+}
+
+func (c *CompactIndex) Close() error {
+	return nil
+}
+
+func (c *CompactIndex) MemoryUsage() MemoryUsage {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	usage := MemoryUsage{Keys: c.size, GarbageBytes: c.garbage}
+	for _, chunk := range c.chunks {
+		usage.ArenaBytes += int64(cap(chunk))
+	}
+	usage.RefBytes = int64(cap(c.blocks)) * 24
+	for _, block := range c.blocks {
+		usage.RefBytes += int64(cap(block)) * 8
+	}
+	return usage
+}
+
+// search 返回 key 所在（或者应该插入）的块和块内的位置
+func (c *CompactIndex) search(key []byte) (int, int, bool) {
+	if len(c.blocks) == 0 {
+		return 0, 0, false
+	}
+	// 最后一个第一个 key 不大于 key 的块，key 比所有数据都小时插入到第一个块
+	bi := sort.Search(len(c.blocks), func(i int) bool {
+		return bytes.Compare(compactKey(c.chunks, c.blocks[i][0]), key) > 0
+	}) - 1
+	if bi < 0 {
+		bi = 0
+	}
+	block := c.blocks[bi]
+	i := sort.Search(len(block), func(i int) bool {
+		return bytes.Compare(compactKey(c.chunks, block[i]), key) >= 0
+	})
+	return bi, i, i < len(block) && bytes.Equal(compactKey(c.chunks, block[i]), key)
+}
+
+// appendEntry 把 key 和位置信息写入 arena，返回条目的引用
+func (c *CompactIndex) appendEntry(key []byte, pos *data.LogRecordPos) uint64 {
+	var header [binary.MaxVarintLen64]byte
+	var posBuf [3 * binary.MaxVarintLen64]byte
+	hn := binary.PutUvarint(header[:], uint64(len(key)))
+	pn := binary.PutUvarint(posBuf[:], uint64(pos.Fid))
+	pn += binary.PutUvarint(posBuf[pn:], uint64(pos.Offset))
+	pn += binary.PutUvarint(posBuf[pn:], pos.Size)
+	need := hn + len(key) + pn
+
+	if len(c.chunks) == 0 || cap(c.chunks[len(c.chunks)-1])-len(c.chunks[len(c.chunks)-1]) < need {
+		c.chunks = append(c.chunks, make([]byte, 0, max(compactChunkSize, need)))
+	}
+	ci := len(c.chunks) - 1
+	chunk := c.chunks[ci]
+	ref := uint64(ci)<<32 | uint64(len(chunk))
+	chunk = append(chunk, header[:hn]...)
+	chunk = append(chunk, key...)
+	c.chunks[ci] = append(chunk, posBuf[:pn]...)
+	c.arenaSize += int64(need)
+	return ref
+}
+
+// maybeCompact 失效的数据超过一半时，把有效的条目按顺序复制到新的 arena 中
+// 旧的内存块仍然被之前创建的迭代器引用时，由 GC 在迭代器释放之后回收
+func (c *CompactIndex) maybeCompact() {
+	if c.garbage < compactChunkSize || c.garbage*2 < c.arenaSize {
+		return
+	}
+	oldChunks := c.chunks
+	c.chunks, c.arenaSize, c.garbage = nil, 0, 0
+	for _, block := range c.blocks {
+		for i, ref := range block {
+			key, pos, _ := decodeCompactEntry(oldChunks, ref)
+			block[i] = c.appendEntry(key, pos)
+		}
+	}
+}
+
+func compactEntry(chunks [][]byte, ref uint64) []byte {
+	return chunks[ref>>32][uint32(ref):]
+}
+
+func compactKey(chunks [][]byte, ref uint64) []byte {
+	entry := compactEntry(chunks, ref)
+	keyLen, n := binary.Uvarint(entry)
+	end := n + int(keyLen)
+	return entry[n:end:end]
+}
+
+// decodeCompactEntry 解码条目，返回 key、位置信息以及条目占用的字节数
+func decodeCompactEntry(chunks [][]byte, ref uint64) ([]byte, *data.LogRecordPos, int) {
+	entry := compactEntry(chunks, ref)
+	keyLen, n := binary.Uvarint(entry)
+	end := n + int(keyLen)
+	key := entry[n:end:end]
+	fid, n1 := binary.Uvarint(entry[end:])
+	offset, n2 := binary.Uvarint(entry[end+n1:])
+	size, n3 := binary.Uvarint(entry[end+n1+n2:])
+	pos := &data.LogRecordPos{Fid: uint32(fid), Offset: int64(offset), Size: size}
+	return key, pos, end + n1 + n2 + n3
+}
+
+// compactIterator 紧凑索引的迭代器，保存的是创建时所有条目的引用
+type compactIterator struct {
+	chunks   [][]byte
+	refs     []uint64
+	reverse  bool
+	curIndex int
+}
+
+func (it *compactIterator) Rewind() {
+	it.curIndex = 0
+}
+
+func (it *compactIterator) Seek(key []byte) {
+	it.curIndex = sort.Search(len(it.refs), func(i int) bool {
+		cmp := bytes.Compare(compactKey(it.chunks, it.refs[i]), key)
+		if it.reverse {
+			return cmp <= 0
+		}
+		return cmp >= 0
+	})
+}
+
+func (it *compactIterator) Next() {
+	it.curIndex++
+}
+
+func (it *compactIterator) Valid() bool {
+	return it.curIndex < len(it.refs)
+}
+
+func (it *compactIterator) Key() []byte {
+	return compactKey(it.chunks, it.refs[it.curIndex])
+}
+
+func (it *compactIterator) Value() *data.LogRecordPos {
+	_, pos, _ := decodeCompactEntry(it.chunks, it.refs[it.curIndex])
+	return pos
+}
+
+func (it *compactIterator) Close() {
+	it.chunks, it.refs = nil, nil
+}
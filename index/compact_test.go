@@ -0,0 +1,109 @@
+package index
+
+import (
+	"fmt"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestCompactIndex_PutGetDelete(t *testing.T) {
+	c := NewCompactIndex()
+	res1 := c.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2, Size: 10})
+	assert.Nil(t, res1)
+	res2 := c.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 1 << 40, Size: 20})
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 2, Size: 10}, res2)
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 1 << 40, Size: 20}, c.Get([]byte("a")))
+	assert.Nil(t, c.Get([]byte("b")))
+	assert.Equal(t, 1, c.Size())
+
+	oldPos, ok := c.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(1<<40), oldPos.Offset)
+	_, ok = c.Delete([]byte("a"))
+	assert.False(t, ok)
+	assert.Nil(t, c.Get([]byte("a")))
+	assert.Equal(t, 0, c.Size())
+	assert.Nil(t, c.Close())
+}
+
+func TestCompactIndex_RandomOps(t *testing.T) {
+	// 和 map 对比随机的写入和删除，覆盖块分裂、删除空块以及整理 arena
+	c := NewCompactIndex()
+	expected := make(map[string]int64)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200000; i++ {
+		key := fmt.Sprintf("key-%06d", r.Intn(20000))
+		if r.Intn(3) == 0 {
+			oldPos, ok := c.Delete([]byte(key))
+			offset, exists := expected[key]
+			assert.Equal(t, exists, ok)
+			if exists {
+				assert.Equal(t, offset, oldPos.Offset)
+			}
+			delete(expected, key)
+			continue
+		}
+		c.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		expected[key] = int64(i)
+	}
+	assert.Equal(t, len(expected), c.Size())
+	for key, offset := range expected {
+		assert.Equal(t, offset, c.Get([]byte(key)).Offset)
+	}
+
+	keys := make([]string, 0, len(expected))
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	iter := c.Iterator(false)
+	var i int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, keys[i], string(iter.Key()))
+		assert.Equal(t, expected[keys[i]], iter.Value().Offset)
+		i++
+	}
+	assert.Equal(t, len(keys), i)
+	iter.Close()
+
+	// 失效的数据会被整理，不会一直增长
+	usage := c.MemoryUsage()
+	assert.Equal(t, len(expected), usage.Keys)
+	assert.True(t, usage.GarbageBytes*2 < usage.ArenaBytes+compactChunkSize)
+	assert.True(t, usage.Total() > 0)
+}
+
+func TestCompactIndex_Iterator(t *testing.T) {
+	c := NewCompactIndex()
+	iter := c.Iterator(false)
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	for i := 0; i < 100; i++ {
+		c.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	iter = c.Iterator(false)
+	// 创建之后的写入不影响迭代器
+	c.Put([]byte("key-050"), &data.LogRecordPos{Fid: 2, Offset: 500})
+	c.Delete([]byte("key-051"))
+	iter.Seek([]byte("key-050"))
+	assert.Equal(t, int64(50), iter.Value().Offset)
+	iter.Next()
+	assert.Equal(t, "key-051", string(iter.Key()))
+	iter.Seek([]byte("key-100"))
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	iter = c.Iterator(true)
+	iter.Rewind()
+	assert.Equal(t, "key-099", string(iter.Key()))
+	iter.Seek([]byte("key-0515"))
+	assert.Equal(t, "key-050", string(iter.Key()))
+	assert.Equal(t, int64(500), iter.Value().Offset)
+	iter.Seek([]byte("a"))
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
@@ -0,0 +1,233 @@
+package index
+
+import (
+	"bytes"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"hash/maphash"
+	"sort"
+	"sync"
+)
+
+// hashMinCapacity 哈希索引的最小槽位数，必须是 2 的幂
+const hashMinCapacity = 16
+
+// HashIndex 使用开放寻址（线性探测）的哈希表索引，没有树节点的额外开销，适合只做点查的场景
+// 数据连续保存在 entries 中，槽位中只保存数据的下标，空槽位只占用 4 个字节
+// 有序遍历时需要先对所有 key 排序，不关心顺序时可以使用 UnorderedIterator（IteratorOptions.Unordered）
+type HashIndex struct {
+	lock    *sync.RWMutex
+	seed    maphash.Seed
+	slots   []uint32 // entries 中的下标加 1，为 0 时表示空槽位
+	entries []hashEntry
+}
+
+type hashEntry struct {
+	hash uint64
+	key  []byte
+	pos  *data.LogRecordPos
+}
+
+func NewHashIndex() *HashIndex {
+	return &HashIndex{
+		lock:  new(sync.RWMutex),
+		seed:  maphash.MakeSeed(),
+		slots: make([]uint32, hashMinCapacity),
+	}
+}
+
+func (h *HashIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	hash := maphash.Bytes(h.seed, key)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	i, found := h.find(hash, key)
+	if found {
+		entry := &h.entries[h.slots[i]-1]
+		oldPos := entry.pos
+		entry.pos = pos
+		return oldPos
+	}
+	h.entries = append(h.entries, hashEntry{hash: hash, key: key, pos: pos})
+	h.slots[i] = uint32(len(h.entries))
+	// 装载因子超过 3/4 时扩容
+	if len(h.entries)*4 > len(h.slots)*3 {
+		h.resize(len(h.slots) * 2)
+	}
+	return nil
+}
+
+func (h *HashIndex) Get(key []byte) *data.LogRecordPos {
+	hash := maphash.Bytes(h.seed, key)
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if i, found := h.find(hash, key); found {
+		return h.entries[h.slots[i]-1].pos
+	}
+	return nil
+}
+
+func (h *HashIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	hash := maphash.Bytes(h.seed, key)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	i, found := h.find(hash, key)
+	if !found {
+		return nil, false
+	}
+	idx := int(h.slots[i] - 1)
+	oldPos := h.entries[idx].pos
+	h.removeSlot(int(i))
+
+	// 最后一条数据移动到被删除的位置，保持 entries 连续
+	last := len(h.entries) - 1
+	if idx != last {
+		h.entries[idx] = h.entries[last]
+		h.slots[h.slotOf(h.entries[idx].hash, last)] = uint32(idx + 1)
+	}
+	h.entries[last] = hashEntry{}
+	h.entries = h.entries[:last]
+
+	// 删除大部分数据之后缩容，释放空槽位和数据占用的内存
+	if len(h.slots) > hashMinCapacity && len(h.entries)*8 < len(h.slots) {
+		h.resize(len(h.slots) / 2)
+	}
+	if cap(h.entries) > hashMinCapacity && len(h.entries)*4 < cap(h.entries) {
+		h.entries = append([]hashEntry(nil), h.entries...)
+	}
+	return oldPos, true
+}
+
+// Iterator 返回按照 key 排序的迭代器，创建时需要复制并排序所有的 key
+func (h *HashIndex) Iterator(reverse bool) Iterator {
+	values := h.items()
+	sort.Slice(values, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(values[i].key, values[j].key) > 0
+		}
+		return bytes.Compare(values[i].key, values[j].key) < 0
+	})
+	return &sliceIterator{reverse: reverse, values: values}
+}
+
+// UnorderedIterator 返回不排序的迭代器，key 的顺序不确定
+// Seek 之后只返回不小于 key（reverse 时不大于 key）的数据
+func (h *HashIndex) UnorderedIterator(reverse bool) Iterator {
+	return &unorderedIterator{reverse: reverse, values: h.items()}
+}
+
+func (h *HashIndex) Size() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.entries)
+}
+
+func (h *HashIndex) Close() error {
+	return nil
+}
+
+// find 查找 key 所在的槽位，不存在时返回探测路径上的第一个空槽位，调用方需要持有锁
+func (h *HashIndex) find(hash uint64, key []byte) (uint64, bool) {
+	mask := uint64(len(h.slots) - 1)
+	for i := hash & mask; ; i = (i + 1) & mask {
+		if h.slots[i] == 0 {
+			return i, false
+		}
+		entry := &h.entries[h.slots[i]-1]
+		if entry.hash == hash && bytes.Equal(entry.key, key) {
+			return i, true
+		}
+	}
+}
+
+// slotOf 返回指向 entries[idx] 的槽位，调用方需要持有锁
+func (h *HashIndex) slotOf(hash uint64, idx int) uint64 {
+	mask := uint64(len(h.slots) - 1)
+	i := hash & mask
+	for h.slots[i] != uint32(idx+1) {
+		i = (i + 1) & mask
+	}
+	return i
+}
+
+// removeSlot 清空槽位 i，并把之后探测路径上的槽位前移，不需要使用墓碑标记
+func (h *HashIndex) removeSlot(i int) {
+	mask := len(h.slots) - 1
+	for j := (i + 1) & mask; h.slots[j] != 0; j = (j + 1) & mask {
+		home := int(h.entries[h.slots[j]-1].hash) & mask
+		// home 不在 (i, j] 区间内时，j 上的数据可以移动到 i
+		if (i <= j && (home <= i || home > j)) || (i > j && home <= i && home > j) {
+			h.slots[i] = h.slots[j]
+			i = j
+		}
+	}
+	h.slots[i] = 0
+}
+
+// resize 使用 capacity 个槽位重建哈希表，调用方需要持有锁
+func (h *HashIndex) resize(capacity int) {
+	h.slots = make([]uint32, capacity)
+	mask := uint64(capacity - 1)
+	for idx, entry := range h.entries {
+		i := entry.hash & mask
+		for h.slots[i] != 0 {
+			i = (i + 1) & mask
+		}
+		h.slots[i] = uint32(idx + 1)
+	}
+}
+
+// items 复制所有数据
+func (h *HashIndex) items() []*Item {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	values := make([]*Item, len(h.entries))
+	for i, entry := range h.entries {
+		values[i] = &Item{key: entry.key, pos: entry.pos}
+	}
+	return values
+}
+
+type unorderedIterator struct {
+	curIndex int     //当前遍历的下标位置
+	reverse  bool    //是否反向
+	values   []*Item // 所有key+位置索引信息
+	seekKey  []byte  // Seek 传入的 key，为 nil 时返回所有数据
+}
+
+func (ui *unorderedIterator) Rewind() {
+	ui.curIndex = 0
+	ui.seekKey = nil
+}
+func (ui *unorderedIterator) Seek(key []byte) {
+	ui.curIndex = 0
+	ui.seekKey = key
+	ui.skip()
+}
+func (ui *unorderedIterator) Next() {
+	ui.curIndex += 1
+	ui.skip()
+}
+func (ui *unorderedIterator) Valid() bool {
+	return ui.curIndex < len(ui.values)
+}
+func (ui *unorderedIterator) Key() []byte {
+	return ui.values[ui.curIndex].key
+}
+func (ui *unorderedIterator) Value() *data.LogRecordPos {
+	return ui.values[ui.curIndex].pos
+}
+func (ui *unorderedIterator) Close() {
+	ui.values = nil
+}
+
+// skip 跳过 Seek 范围之外的数据
+func (ui *unorderedIterator) skip() {
+	if ui.seekKey == nil {
+		return
+	}
+	for ; ui.curIndex < len(ui.values); ui.curIndex++ {
+		cmp := bytes.Compare(ui.values[ui.curIndex].key, ui.seekKey)
+		if (!ui.reverse && cmp >= 0) || (ui.reverse && cmp <= 0) {
+			return
+		}
+	}
+}
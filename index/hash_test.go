@@ -0,0 +1,113 @@
+package index
+
+import (
+	"fmt"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func TestHash_PutGetDelete(t *testing.T) {
+	h := NewHashIndex()
+
+	res1 := h.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.Nil(t, res1)
+	res2 := h.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	assert.Equal(t, int64(2), res2.Offset)
+	assert.Equal(t, int64(3), h.Get([]byte("a")).Offset)
+	assert.Nil(t, h.Get([]byte("b")))
+	assert.Equal(t, 1, h.Size())
+
+	oldPos, ok := h.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), oldPos.Offset)
+	_, ok = h.Delete([]byte("a"))
+	assert.False(t, ok)
+	assert.Nil(t, h.Get([]byte("a")))
+	assert.Equal(t, 0, h.Size())
+}
+
+func TestHash_Random(t *testing.T) {
+	h := NewHashIndex()
+	expected := make(map[string]int64)
+	r := rand.New(rand.NewSource(1))
+	// 随机的写入和删除覆盖扩容、缩容以及删除时数据前移的情况
+	for i := 0; i < 50000; i++ {
+		key := fmt.Sprintf("key-%d", r.Intn(5000))
+		if r.Intn(3) == 0 {
+			_, ok := h.Delete([]byte(key))
+			_, exists := expected[key]
+			assert.Equal(t, exists, ok)
+			delete(expected, key)
+		} else {
+			h.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+			expected[key] = int64(i)
+		}
+	}
+	assert.Equal(t, len(expected), h.Size())
+	for key, offset := range expected {
+		pos := h.Get([]byte(key))
+		assert.NotNil(t, pos)
+		assert.Equal(t, offset, pos.Offset)
+	}
+
+	// 删除所有数据之后缩容到最小容量
+	for key := range expected {
+		_, ok := h.Delete([]byte(key))
+		assert.True(t, ok)
+	}
+	assert.Equal(t, 0, h.Size())
+	assert.Equal(t, hashMinCapacity, len(h.slots))
+}
+
+func TestHash_Iterator(t *testing.T) {
+	h := NewHashIndex()
+	iter := h.Iterator(false)
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	for i := 0; i < 100; i++ {
+		h.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	iter = h.Iterator(false)
+	var i int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%03d", i), string(iter.Key()))
+		i++
+	}
+	assert.Equal(t, 100, i)
+	iter.Seek([]byte("key-0505"))
+	assert.Equal(t, "key-051", string(iter.Key()))
+	iter.Close()
+
+	iter = h.Iterator(true)
+	iter.Seek([]byte("key-0505"))
+	assert.Equal(t, "key-050", string(iter.Key()))
+	iter.Close()
+
+	// 不排序的迭代器返回同样的数据，Seek 之后只返回范围内的 key
+	iter = h.UnorderedIterator(false)
+	seen := make(map[string]bool)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		seen[string(iter.Key())] = true
+	}
+	assert.Equal(t, 100, len(seen))
+	var count int
+	for iter.Seek([]byte("key-090")); iter.Valid(); iter.Next() {
+		assert.True(t, string(iter.Key()) >= "key-090")
+		count++
+	}
+	assert.Equal(t, 10, count)
+	iter.Close()
+
+	iter = h.UnorderedIterator(true)
+	count = 0
+	for iter.Seek([]byte("key-009")); iter.Valid(); iter.Next() {
+		assert.True(t, string(iter.Key()) <= "key-009")
+		count++
+	}
+	assert.Equal(t, 10, count)
+	iter.Close()
+}
@@ -2,8 +2,10 @@ package index
 
 import (
 	"bytes"
+	"errors"
 	"github.com/Tuanzi-bug/TuanKV/data"
 	"github.com/google/btree"
+	"sort"
 )
 
 // Indexer is an interface that represents the index of the data records.
@@ -16,6 +18,17 @@ type Indexer interface {
 	Close() error                                              // Close is an interface method that closes the index.
 }
 
+// PrefixIndexer 可以只遍历指定前缀的 key 的索引，不需要复制其他的 key
+type PrefixIndexer interface {
+	PrefixIterator(prefix []byte, reverse bool) Iterator
+}
+
+// UnorderedIndexer 可以按照不确定的顺序遍历的索引，不需要排序或者合并
+// Seek 之后只返回不小于 key（reverse 时不大于 key）的数据
+type UnorderedIndexer interface {
+	UnorderedIterator(reverse bool) Iterator
+}
+
 type Item struct {
 	key []byte
 	pos *data.LogRecordPos
@@ -27,20 +40,58 @@ const (
 	Btree IndexType = iota + 1
 	Art
 	BPTree
+	Hash
+	Skiplist
+	Compact
+)
+
+var (
+	ErrUnsupportedIndexType = errors.New("unsupported index type")
+	ErrShardedBPlusTree     = errors.New("b+tree index can not be sharded")
 )
 
-// NewIndexer 根据配置返回对应的索引对象
-func NewIndexer(indextype IndexType, dirPath string) Indexer {
+// NewIndexer 根据配置返回对应的索引对象，syncWrites 只对持久化在磁盘上的 B+ 树索引生效
+func NewIndexer(indextype IndexType, dirPath string, syncWrites bool) (Indexer, error) {
 	switch indextype {
 	case Btree:
-		return NewBTree()
+		return NewBTree(), nil
 	case Art:
-		return NewART()
-	//case BPTree:
-	//	return NewBPlusTree(dirPath)
+		return NewART(), nil
+	case BPTree:
+		bpt, err := NewBPlusTree(dirPath, syncWrites)
+		if err != nil {
+			return nil, err
+		}
+		return bpt, nil
+	case Hash:
+		return NewHashIndex(), nil
+	case Skiplist:
+		return NewSkipList(), nil
+	case Compact:
+		return NewCompactIndex(), nil
 	default:
-		panic("unsupported index type")
+		return nil, ErrUnsupportedIndexType
+	}
+}
+
+// NewShardedIndexer 返回分成 shards 个分片的索引，shards 不大于 1 时不分片
+func NewShardedIndexer(indextype IndexType, dirPath string, syncWrites bool, shards int) (Indexer, error) {
+	if shards <= 1 {
+		return NewIndexer(indextype, dirPath, syncWrites)
+	}
+	// 所有分片会打开同一个索引文件
+	if indextype == BPTree {
+		return nil, ErrShardedBPlusTree
+	}
+	indexes := make([]Indexer, shards)
+	for i := range indexes {
+		idx, err := NewIndexer(indextype, dirPath, syncWrites)
+		if err != nil {
+			return nil, err
+		}
+		indexes[i] = idx
 	}
+	return &ShardedIndex{shards: indexes}, nil
 }
 
 func (ai *Item) Less(bi btree.Item) bool {
@@ -56,3 +107,40 @@ type Iterator interface {
 	Value() *data.LogRecordPos // 当前遍历位置的Value数据
 	Close()                    // 关闭迭代器，释放相应资源
 }
+
+// sliceIterator 遍历创建时复制出来的有序数据，reverse 时 values 按照 key 从大到小排列
+type sliceIterator struct {
+	curIndex int     //当前遍历的下标位置
+	reverse  bool    //是否反向
+	values   []*Item // 所有key+位置索引信息
+}
+
+func (si *sliceIterator) Rewind() {
+	si.curIndex = 0
+}
+func (si *sliceIterator) Seek(key []byte) {
+	if si.reverse {
+		si.curIndex = sort.Search(len(si.values), func(i int) bool {
+			return bytes.Compare(si.values[i].key, key) <= 0
+		})
+	} else {
+		si.curIndex = sort.Search(len(si.values), func(i int) bool {
+			return bytes.Compare(si.values[i].key, key) >= 0
+		})
+	}
+}
+func (si *sliceIterator) Next() {
+	si.curIndex += 1
+}
+func (si *sliceIterator) Valid() bool {
+	return si.curIndex < len(si.values)
+}
+func (si *sliceIterator) Key() []byte {
+	return si.values[si.curIndex].key
+}
+func (si *sliceIterator) Value() *data.LogRecordPos {
+	return si.values[si.curIndex].pos
+}
+func (si *sliceIterator) Close() {
+	si.values = nil
+}
@@ -0,0 +1,265 @@
+package index
+
+import (
+	"bytes"
+	"container/heap"
+	"github.com/Tuanzi-bug/TuanKV/data"
+)
+
+// ShardedIndex 按照 key 的哈希把索引分成多个子索引，每个子索引使用自己的锁，
+// 不同分片上的读写互不阻塞。有序遍历时合并所有分片的迭代器。
+type ShardedIndex struct {
+	shards []Indexer
+}
+
+// NewShardedIndex 创建 shardNum 个由 newShard 生成的子索引
+func NewShardedIndex(shardNum int, newShard func() Indexer) *ShardedIndex {
+	if shardNum <= 0 {
+		shardNum = 1
+	}
+	shards := make([]Indexer, shardNum)
+	for i := range shards {
+		shards[i] = newShard()
+	}
+	return &ShardedIndex{shards: shards}
+}
+
+// shard 使用 FNV-1a 哈希选择 key 所在的分片
+func (si *ShardedIndex) shard(key []byte) Indexer {
+	var h uint32 = 2166136261
+	for _, b := range key {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return si.shards[h%uint32(len(si.shards))]
+}
+
+func (si *ShardedIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	return si.shard(key).Put(key, pos)
+}
+
+func (si *ShardedIndex) Get(key []byte) *data.LogRecordPos {
+	return si.shard(key).Get(key)
+}
+
+func (si *ShardedIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	return si.shard(key).Delete(key)
+}
+
+// Iterator 依次获取各个分片的迭代器，返回的迭代器不是所有分片在同一时刻的快照
+func (si *ShardedIndex) Iterator(reverse bool) Iterator {
+	iters := make([]Iterator, len(si.shards))
+	for i, shard := range si.shards {
+		iters[i] = shard.Iterator(reverse)
+	}
+	return newShardedIterator(iters, reverse)
+}
+
+// PrefixIterator 合并各个分片上前缀为 prefix 的 key，分片不支持按照前缀遍历时使用普通的迭代器，由调用方定位到前缀
+func (si *ShardedIndex) PrefixIterator(prefix []byte, reverse bool) Iterator {
+	iters := make([]Iterator, len(si.shards))
+	for i, shard := range si.shards {
+		if prefixIndexer, ok := shard.(PrefixIndexer); ok {
+			iters[i] = prefixIndexer.PrefixIterator(prefix, reverse)
+		} else {
+			iters[i] = shard.Iterator(reverse)
+		}
+	}
+	return newShardedIterator(iters, reverse)
+}
+
+// UnorderedIterator 依次遍历各个分片，不需要多路归并，分片支持时使用分片自己的不排序的迭代器
+func (si *ShardedIndex) UnorderedIterator(reverse bool) Iterator {
+	iters := make([]Iterator, len(si.shards))
+	for i, shard := range si.shards {
+		if unorderedIndexer, ok := shard.(UnorderedIndexer); ok {
+			iters[i] = unorderedIndexer.UnorderedIterator(reverse)
+		} else {
+			iters[i] = shard.Iterator(reverse)
+		}
+	}
+	it := &concatIterator{iters: iters}
+	it.skipInvalid()
+	return it
+}
+
+func (si *ShardedIndex) Size() int {
+	var size int
+	for _, shard := range si.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+// MemoryUsage 汇总所有分片的内存占用，分片不能报告内存占用时不计入
+func (si *ShardedIndex) MemoryUsage() MemoryUsage {
+	var usage MemoryUsage
+	for _, shard := range si.shards {
+		if reporter, ok := shard.(MemoryReporter); ok {
+			u := reporter.MemoryUsage()
+			usage.Keys += u.Keys
+			usage.ArenaBytes += u.ArenaBytes
+			usage.GarbageBytes += u.GarbageBytes
+			usage.RefBytes += u.RefBytes
+		}
+	}
+	return usage
+}
+
+func (si *ShardedIndex) Close() error {
+	var err error
+	for _, shard := range si.shards {
+		if closeErr := shard.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// concatIterator 依次遍历各个分片的迭代器，key 的顺序不确定
+type concatIterator struct {
+	iters   []Iterator
+	current int // 当前所在的迭代器
+}
+
+func (it *concatIterator) Rewind() {
+	for _, iter := range it.iters {
+		iter.Rewind()
+	}
+	it.current = 0
+	it.skipInvalid()
+}
+
+func (it *concatIterator) Seek(key []byte) {
+	for _, iter := range it.iters {
+		iter.Seek(key)
+	}
+	it.current = 0
+	it.skipInvalid()
+}
+
+func (it *concatIterator) Next() {
+	it.iters[it.current].Next()
+	it.skipInvalid()
+}
+
+func (it *concatIterator) Valid() bool {
+	return it.current < len(it.iters)
+}
+
+func (it *concatIterator) Key() []byte {
+	return it.iters[it.current].Key()
+}
+
+func (it *concatIterator) Value() *data.LogRecordPos {
+	return it.iters[it.current].Value()
+}
+
+func (it *concatIterator) Close() {
+	for _, iter := range it.iters {
+		iter.Close()
+	}
+	it.current = len(it.iters)
+}
+
+// skipInvalid 跳过已经遍历完的迭代器
+func (it *concatIterator) skipInvalid() {
+	for it.current < len(it.iters) && !it.iters[it.current].Valid() {
+		it.current++
+	}
+}
+
+// shardedIterator 多路归并各个分片的迭代器，分片之间的 key 不重复
+type shardedIterator struct {
+	iters []Iterator
+	heap  *iteratorHeap // 尚未遍历完的迭代器，堆顶是当前位置
+}
+
+func newShardedIterator(iters []Iterator, reverse bool) *shardedIterator {
+	it := &shardedIterator{
+		iters: iters,
+		heap:  &iteratorHeap{reverse: reverse},
+	}
+	it.Rewind()
+	return it
+}
+
+func (it *shardedIterator) Rewind() {
+	for _, iter := range it.iters {
+		iter.Rewind()
+	}
+	it.rebuild()
+}
+
+func (it *shardedIterator) Seek(key []byte) {
+	for _, iter := range it.iters {
+		iter.Seek(key)
+	}
+	it.rebuild()
+}
+
+func (it *shardedIterator) Next() {
+	top := it.heap.iters[0]
+	top.Next()
+	if top.Valid() {
+		heap.Fix(it.heap, 0)
+	} else {
+		heap.Pop(it.heap)
+	}
+}
+
+func (it *shardedIterator) Valid() bool {
+	return it.heap.Len() > 0
+}
+
+func (it *shardedIterator) Key() []byte {
+	return it.heap.iters[0].Key()
+}
+
+func (it *shardedIterator) Value() *data.LogRecordPos {
+	return it.heap.iters[0].Value()
+}
+
+func (it *shardedIterator) Close() {
+	for _, iter := range it.iters {
+		iter.Close()
+	}
+	it.heap.iters = nil
+}
+
+// rebuild 重新收集还有数据的迭代器并建堆
+func (it *shardedIterator) rebuild() {
+	it.heap.iters = it.heap.iters[:0]
+	for _, iter := range it.iters {
+		if iter.Valid() {
+			it.heap.iters = append(it.heap.iters, iter)
+		}
+	}
+	heap.Init(it.heap)
+}
+
+// iteratorHeap 按照迭代器当前的 key 排序的堆，反向遍历时 key 大的在堆顶
+type iteratorHeap struct {
+	iters   []Iterator
+	reverse bool
+}
+
+func (h *iteratorHeap) Len() int { return len(h.iters) }
+
+func (h *iteratorHeap) Less(i, j int) bool {
+	cmp := bytes.Compare(h.iters[i].Key(), h.iters[j].Key())
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (h *iteratorHeap) Swap(i, j int) { h.iters[i], h.iters[j] = h.iters[j], h.iters[i] }
+
+func (h *iteratorHeap) Push(x any) { h.iters = append(h.iters, x.(Iterator)) }
+
+func (h *iteratorHeap) Pop() any {
+	last := h.iters[len(h.iters)-1]
+	h.iters = h.iters[:len(h.iters)-1]
+	return last
+}
@@ -0,0 +1,173 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestShardedIndex_PutGetDelete(t *testing.T) {
+	si, err := NewShardedIndexer(Btree, "", false, 8)
+	assert.Nil(t, err)
+
+	res1 := si.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.Nil(t, res1)
+	res2 := si.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	assert.Equal(t, int64(2), res2.Offset)
+	assert.Equal(t, int64(3), si.Get([]byte("a")).Offset)
+	assert.Nil(t, si.Get([]byte("b")))
+
+	for i := 0; i < 100; i++ {
+		si.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Equal(t, 101, si.Size())
+	oldPos, ok := si.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), oldPos.Offset)
+	_, ok = si.Delete([]byte("a"))
+	assert.False(t, ok)
+	assert.Equal(t, 100, si.Size())
+	assert.Nil(t, si.Close())
+
+	// 分片数不大于 1 时不分片
+	idx, err := NewShardedIndexer(Btree, "", false, 1)
+	assert.Nil(t, err)
+	_, ok = idx.(*BTree)
+	assert.True(t, ok)
+	_, err = NewShardedIndexer(BPTree, "", false, 8)
+	assert.Equal(t, ErrShardedBPlusTree, err)
+}
+
+func TestShardedIndex_Iterator(t *testing.T) {
+	si := NewShardedIndex(4, func() Indexer { return NewART() })
+	iter := si.Iterator(false)
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	for i := 0; i < 100; i++ {
+		si.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// 正向遍历时所有分片的 key 合并为有序的序列
+	iter = si.Iterator(false)
+	var i int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%03d", i), string(iter.Key()))
+		assert.Equal(t, int64(i), iter.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 100, i)
+	iter.Seek([]byte("key-050"))
+	assert.Equal(t, "key-050", string(iter.Key()))
+	iter.Seek([]byte("key-0505"))
+	assert.Equal(t, "key-051", string(iter.Key()))
+	iter.Seek([]byte("key-100"))
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	iter = si.Iterator(true)
+	i = 99
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%03d", i), string(iter.Key()))
+		i--
+	}
+	assert.Equal(t, -1, i)
+	iter.Seek([]byte("key-0505"))
+	assert.Equal(t, "key-050", string(iter.Key()))
+	iter.Close()
+}
+
+func TestShardedIndex_PrefixIterator(t *testing.T) {
+	for _, newShard := range []func() Indexer{
+		func() Indexer { return NewART() },
+		func() Indexer { return NewBTree() },
+	} {
+		si := NewShardedIndex(4, newShard)
+		for i := 0; i < 100; i++ {
+			si.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+		prefix := []byte("key-05")
+		iter := si.PrefixIterator(prefix, false)
+		iter.Seek(prefix)
+		var keys []string
+		for ; iter.Valid() && bytes.HasPrefix(iter.Key(), prefix); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		iter.Close()
+		assert.Equal(t, 10, len(keys))
+		assert.Equal(t, "key-050", keys[0])
+		assert.Equal(t, "key-059", keys[9])
+
+		iter = si.PrefixIterator(prefix, true)
+		iter.Seek([]byte("key-06"))
+		keys = keys[:0]
+		for ; iter.Valid(); iter.Next() {
+			if bytes.HasPrefix(iter.Key(), prefix) {
+				keys = append(keys, string(iter.Key()))
+			}
+		}
+		iter.Close()
+		assert.Equal(t, 10, len(keys))
+		assert.Equal(t, "key-059", keys[0])
+		assert.Equal(t, "key-050", keys[9])
+	}
+}
+
+func TestShardedIndex_UnorderedIterator(t *testing.T) {
+	for _, newShard := range []func() Indexer{
+		func() Indexer { return NewHashIndex() },
+		func() Indexer { return NewBTree() },
+	} {
+		si := NewShardedIndex(4, newShard)
+		for i := 0; i < 100; i++ {
+			si.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+		iter := si.UnorderedIterator(false)
+		seen := make(map[string]bool)
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			assert.Equal(t, si.Get(iter.Key()), iter.Value())
+			seen[string(iter.Key())] = true
+		}
+		assert.Equal(t, 100, len(seen))
+
+		// Seek 之后各个分片只返回范围内的 key
+		var count int
+		for iter.Seek([]byte("key-090")); iter.Valid(); iter.Next() {
+			assert.True(t, string(iter.Key()) >= "key-090")
+			count++
+		}
+		assert.Equal(t, 10, count)
+		iter.Close()
+
+		iter = si.UnorderedIterator(true)
+		count = 0
+		for iter.Seek([]byte("key-009")); iter.Valid(); iter.Next() {
+			assert.True(t, string(iter.Key()) <= "key-009")
+			count++
+		}
+		assert.Equal(t, 10, count)
+		iter.Close()
+	}
+}
+
+func TestShardedIndex_Concurrent(t *testing.T) {
+	si, err := NewShardedIndexer(Btree, "", false, 16)
+	assert.Nil(t, err)
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := []byte(fmt.Sprintf("key-%d-%d", g, i))
+				si.Put(key, &data.LogRecordPos{Fid: uint32(g), Offset: int64(i)})
+				assert.Equal(t, int64(i), si.Get(key).Offset)
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 8000, si.Size())
+}
@@ -0,0 +1,316 @@
+package index
+
+import (
+	"bytes"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"math/rand"
+	"sync/atomic"
+)
+
+const (
+	skipListMaxHeight = 20
+	skipListBranching = 4 // 每一层的节点数大约是下一层的 1/4
+)
+
+// SkipList 无锁的跳表索引，写入使用 CAS 插入节点，读取不加锁，不会被写入阻塞
+// 删除先把节点的位置置为 nil，再标记节点每一层的后继指针，被标记的节点在之后的查找中从链表中摘除（Harris 的做法）
+type SkipList struct {
+	head *skipListNode
+	size atomic.Int64
+}
+
+type skipListNode struct {
+	key  []byte
+	pos  atomic.Pointer[data.LogRecordPos] // 为 nil 时表示 key 已经被删除，之后不会再被修改
+	next []atomic.Pointer[skipListRef]
+}
+
+// skipListRef 后继指针和删除标记，创建之后不会修改，通过 CAS 替换整个引用
+type skipListRef struct {
+	node   *skipListNode
+	marked bool // 所在的节点已经被删除，这一层的后继不能再修改
+}
+
+func NewSkipList() *SkipList {
+	return &SkipList{head: newSkipListNode(nil, skipListMaxHeight)}
+}
+
+func newSkipListNode(key []byte, height int) *skipListNode {
+	node := &skipListNode{key: key, next: make([]atomic.Pointer[skipListRef], height)}
+	for level := range node.next {
+		node.next[level].Store(&skipListRef{})
+	}
+	return node
+}
+
+func (sl *SkipList) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	var preds, succs [skipListMaxHeight]*skipListNode
+	for {
+		if node := sl.find(key, &preds, &succs); node != nil {
+			if oldPos, ok := node.swapPos(pos); ok {
+				return oldPos
+			}
+			// 节点正在被删除，帮助完成删除之后插入新的节点
+			node.mark()
+			continue
+		}
+		height := randomHeight()
+		node := newSkipListNode(key, height)
+		node.pos.Store(pos)
+		for level := 0; level < height; level++ {
+			node.next[level].Store(&skipListRef{node: succs[level]})
+		}
+		// 最底层链接成功之后节点才对读取可见，失败说明有并发的写入，重新查找插入位置
+		if !casNext(preds[0], 0, succs[0], node) {
+			continue
+		}
+		sl.size.Add(1)
+		sl.linkUpperLevels(node, height, &preds, &succs)
+		return nil
+	}
+}
+
+// linkUpperLevels 把已经插入最底层的节点链接到上面的各层，节点被并发删除之后停止
+func (sl *SkipList) linkUpperLevels(node *skipListNode, height int, preds, succs *[skipListMaxHeight]*skipListNode) {
+	for level := 1; level < height; level++ {
+		for {
+			ref := node.next[level].Load()
+			if ref.marked {
+				return
+			}
+			if ref.node != succs[level] && !node.next[level].CompareAndSwap(ref, &skipListRef{node: succs[level]}) {
+				continue
+			}
+			if casNext(preds[level], level, succs[level], node) {
+				break
+			}
+			// 重新查找这一层的插入位置，查找不到节点说明已经被删除
+			if sl.find(node.key, preds, succs) != node {
+				return
+			}
+		}
+	}
+}
+
+func (sl *SkipList) Get(key []byte) *data.LogRecordPos {
+	node := sl.findGreaterOrEqual(key)
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil
+	}
+	return node.pos.Load()
+}
+
+func (sl *SkipList) Delete(key []byte) (*data.LogRecordPos, bool) {
+	var preds, succs [skipListMaxHeight]*skipListNode
+	node := sl.find(key, &preds, &succs)
+	if node == nil {
+		return nil, false
+	}
+	oldPos, ok := node.swapPos(nil)
+	if !ok {
+		return nil, false
+	}
+	sl.size.Add(-1)
+	node.mark()
+	// 再查找一次，把标记之后的节点从每一层摘除
+	sl.find(key, &preds, &succs)
+	return oldPos, true
+}
+
+// Iterator 返回直接在跳表上遍历的迭代器，不复制数据，可以看到创建之后并发写入的数据
+func (sl *SkipList) Iterator(reverse bool) Iterator {
+	it := &skipListIterator{list: sl, reverse: reverse}
+	it.Rewind()
+	return it
+}
+
+func (sl *SkipList) Size() int {
+	return int(sl.size.Load())
+}
+
+func (sl *SkipList) Close() error {
+	return nil
+}
+
+// swapPos 替换节点的位置，节点已经被删除时返回 false
+func (node *skipListNode) swapPos(pos *data.LogRecordPos) (*data.LogRecordPos, bool) {
+	for {
+		oldPos := node.pos.Load()
+		if oldPos == nil {
+			return nil, false
+		}
+		if node.pos.CompareAndSwap(oldPos, pos) {
+			return oldPos, true
+		}
+	}
+}
+
+// mark 从上往下标记节点每一层的后继指针，标记之后节点不能再作为前驱插入新的节点
+func (node *skipListNode) mark() {
+	for level := len(node.next) - 1; level >= 0; level-- {
+		for {
+			ref := node.next[level].Load()
+			if ref.marked || node.next[level].CompareAndSwap(ref, &skipListRef{node: ref.node, marked: true}) {
+				break
+			}
+		}
+	}
+}
+
+// casNext 前驱在 level 层的后继仍然是没有标记的 expected 时替换为 node
+func casNext(pred *skipListNode, level int, expected, node *skipListNode) bool {
+	ref := pred.next[level].Load()
+	if ref.marked || ref.node != expected {
+		return false
+	}
+	return pred.next[level].CompareAndSwap(ref, &skipListRef{node: node})
+}
+
+// find 查找每一层中最后一个小于 key 的节点以及它的后继，途中摘除已经标记的节点，key 存在时返回最底层对应的节点
+func (sl *SkipList) find(key []byte, preds, succs *[skipListMaxHeight]*skipListNode) *skipListNode {
+retry:
+	prev := sl.head
+	for level := skipListMaxHeight - 1; level >= 0; level-- {
+		prevRef := prev.next[level].Load()
+		if prevRef.marked {
+			// 前驱在查找的过程中被删除，从头开始查找
+			goto retry
+		}
+		curr := prevRef.node
+		for curr != nil {
+			currRef := curr.next[level].Load()
+			if currRef.marked {
+				unlinked := &skipListRef{node: currRef.node}
+				if !prev.next[level].CompareAndSwap(prevRef, unlinked) {
+					goto retry
+				}
+				prevRef, curr = unlinked, currRef.node
+				continue
+			}
+			if bytes.Compare(curr.key, key) >= 0 {
+				break
+			}
+			prev, prevRef, curr = curr, currRef, currRef.node
+		}
+		preds[level], succs[level] = prev, curr
+	}
+	if succs[0] != nil && bytes.Equal(succs[0].key, key) {
+		return succs[0]
+	}
+	return nil
+}
+
+// findGreaterOrEqual 返回第一个不小于 key 的节点，不摘除节点，可能返回已经删除的节点
+func (sl *SkipList) findGreaterOrEqual(key []byte) *skipListNode {
+	prev := sl.head
+	var next *skipListNode
+	for level := skipListMaxHeight - 1; level >= 0; level-- {
+		for {
+			next = prev.next[level].Load().node
+			if next == nil || bytes.Compare(next.key, key) >= 0 {
+				break
+			}
+			prev = next
+		}
+	}
+	return next
+}
+
+// findLess 返回最后一个小于 key（orEqual 为 true 时不大于 key）的节点，key 为 nil 时返回最后一个节点
+func (sl *SkipList) findLess(key []byte, orEqual bool) *skipListNode {
+	prev := sl.head
+	for level := skipListMaxHeight - 1; level >= 0; level-- {
+		for {
+			next := prev.next[level].Load().node
+			if next == nil {
+				break
+			}
+			if key != nil {
+				cmp := bytes.Compare(next.key, key)
+				if cmp > 0 || (cmp == 0 && !orEqual) {
+					break
+				}
+			}
+			prev = next
+		}
+	}
+	if prev == sl.head {
+		return nil
+	}
+	return prev
+}
+
+func randomHeight() int {
+	height := 1
+	for height < skipListMaxHeight && rand.Intn(skipListBranching) == 0 {
+		height++
+	}
+	return height
+}
+
+// skipListIterator 跳表上的迭代器，反向遍历时每一步都从头查找前一个节点
+type skipListIterator struct {
+	list    *SkipList
+	reverse bool
+	node    *skipListNode
+	pos     *data.LogRecordPos // 定位到 node 时读到的位置
+}
+
+func (it *skipListIterator) Rewind() {
+	if it.reverse {
+		it.node = it.list.findLess(nil, true)
+	} else {
+		it.node = it.list.head.next[0].Load().node
+	}
+	it.skipDeleted()
+}
+
+func (it *skipListIterator) Seek(key []byte) {
+	if it.reverse {
+		it.node = it.list.findLess(key, true)
+	} else {
+		it.node = it.list.findGreaterOrEqual(key)
+	}
+	it.skipDeleted()
+}
+
+func (it *skipListIterator) Next() {
+	it.advance()
+	it.skipDeleted()
+}
+
+func (it *skipListIterator) Valid() bool {
+	return it.node != nil
+}
+
+func (it *skipListIterator) Key() []byte {
+	return it.node.key
+}
+
+func (it *skipListIterator) Value() *data.LogRecordPos {
+	return it.pos
+}
+
+func (it *skipListIterator) Close() {
+	it.node, it.pos = nil, nil
+}
+
+// advance 移动到下一个节点，当前节点被摘除之后它的后继指针仍然指向之后的节点
+func (it *skipListIterator) advance() {
+	if it.reverse {
+		it.node = it.list.findLess(it.node.key, false)
+	} else {
+		it.node = it.node.next[0].Load().node
+	}
+}
+
+// skipDeleted 跳过已经删除、还没有被摘除的节点
+func (it *skipListIterator) skipDeleted() {
+	for it.node != nil {
+		if it.pos = it.node.pos.Load(); it.pos != nil {
+			return
+		}
+		it.advance()
+	}
+	it.pos = nil
+}
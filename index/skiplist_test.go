@@ -0,0 +1,190 @@
+package index
+
+import (
+	"fmt"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestSkipList_PutGetDelete(t *testing.T) {
+	sl := NewSkipList()
+	res1 := sl.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.Nil(t, res1)
+	res2 := sl.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	assert.Equal(t, int64(2), res2.Offset)
+	assert.Equal(t, int64(3), sl.Get([]byte("a")).Offset)
+	assert.Nil(t, sl.Get([]byte("b")))
+	assert.Equal(t, 1, sl.Size())
+
+	oldPos, ok := sl.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), oldPos.Offset)
+	_, ok = sl.Delete([]byte("a"))
+	assert.False(t, ok)
+	_, ok = sl.Delete([]byte("b"))
+	assert.False(t, ok)
+	assert.Nil(t, sl.Get([]byte("a")))
+	assert.Equal(t, 0, sl.Size())
+
+	// 删除之后再次写入插入新的节点
+	assert.Nil(t, sl.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 4}))
+	assert.Equal(t, int64(4), sl.Get([]byte("a")).Offset)
+	assert.Equal(t, 1, sl.Size())
+	assert.Nil(t, sl.Close())
+}
+
+// 删除的节点从每一层摘除，不会一直占用内存
+func TestSkipList_DeleteUnlinks(t *testing.T) {
+	sl := NewSkipList()
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 1000; i++ {
+			sl.Put([]byte(fmt.Sprintf("key-%d-%04d", round, i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+		for i := 0; i < 1000; i++ {
+			if i%100 != 0 {
+				_, ok := sl.Delete([]byte(fmt.Sprintf("key-%d-%04d", round, i)))
+				assert.True(t, ok)
+			}
+		}
+	}
+	assert.Equal(t, 30, sl.Size())
+	for level := 0; level < skipListMaxHeight; level++ {
+		var nodes int
+		for node := sl.head.next[level].Load().node; node != nil; node = node.next[level].Load().node {
+			assert.NotNil(t, node.pos.Load())
+			nodes++
+		}
+		if level == 0 {
+			assert.Equal(t, 30, nodes)
+		}
+	}
+}
+
+func TestSkipList_Iterator(t *testing.T) {
+	sl := NewSkipList()
+	iter := sl.Iterator(false)
+	assert.False(t, iter.Valid())
+	iter.Close()
+	iter = sl.Iterator(true)
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	for i := 0; i < 100; i++ {
+		sl.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	// 已经删除的 key 不会出现在遍历结果中
+	for i := 0; i < 100; i += 10 {
+		sl.Delete([]byte(fmt.Sprintf("key-%03d", i)))
+	}
+
+	iter = sl.Iterator(false)
+	var count int
+	var last string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.True(t, string(iter.Key()) > last)
+		assert.NotNil(t, iter.Value())
+		last = string(iter.Key())
+		count++
+	}
+	assert.Equal(t, 90, count)
+	iter.Seek([]byte("key-050"))
+	assert.Equal(t, "key-051", string(iter.Key()))
+	iter.Seek([]byte("key-0515"))
+	assert.Equal(t, "key-052", string(iter.Key()))
+	iter.Seek([]byte("key-100"))
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	iter = sl.Iterator(true)
+	count, last = 0, "key-999"
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.True(t, string(iter.Key()) < last)
+		last = string(iter.Key())
+		count++
+	}
+	assert.Equal(t, 90, count)
+	iter.Seek([]byte("key-0515"))
+	assert.Equal(t, "key-051", string(iter.Key()))
+	iter.Seek([]byte("key-050"))
+	assert.Equal(t, "key-049", string(iter.Key()))
+	iter.Next()
+	assert.Equal(t, "key-048", string(iter.Key()))
+	iter.Seek([]byte("a"))
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
+
+func TestSkipList_Concurrent(t *testing.T) {
+	sl := NewSkipList()
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(2)
+		// 多个写入者并发插入相同和不同的 key
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				sl.Put([]byte(fmt.Sprintf("key-%d-%d", g, i)), &data.LogRecordPos{Fid: uint32(g), Offset: int64(i)})
+				sl.Put([]byte(fmt.Sprintf("shared-%d", i)), &data.LogRecordPos{Fid: uint32(g), Offset: int64(i)})
+			}
+		}(g)
+		go func() {
+			defer wg.Done()
+			iter := sl.Iterator(false)
+			defer iter.Close()
+			var last []byte
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				assert.True(t, last == nil || string(iter.Key()) > string(last))
+				last = iter.Key()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 9000, sl.Size())
+	for g := 0; g < 8; g++ {
+		for i := 0; i < 1000; i++ {
+			assert.Equal(t, int64(i), sl.Get([]byte(fmt.Sprintf("key-%d-%d", g, i))).Offset)
+		}
+	}
+	iter := sl.Iterator(false)
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, 9000, count)
+}
+
+func TestSkipList_ConcurrentDelete(t *testing.T) {
+	sl := NewSkipList()
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		// 多个写入者并发写入和删除相同和不同的 key
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := []byte(fmt.Sprintf("key-%d-%d", g, i%100))
+				shared := []byte(fmt.Sprintf("shared-%d", i%50))
+				pos := &data.LogRecordPos{Fid: uint32(g), Offset: int64(i)}
+				sl.Put(key, pos)
+				sl.Put(shared, pos)
+				if i%3 != 0 {
+					sl.Delete(key)
+					sl.Delete(shared)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// 所有的写入结束之后最底层只剩下没有删除的节点
+	var nodes int
+	for node := sl.head.next[0].Load().node; node != nil; node = node.next[0].Load().node {
+		assert.NotNil(t, node.pos.Load())
+		assert.Equal(t, node.pos.Load(), sl.Get(node.key))
+		nodes++
+	}
+	assert.Equal(t, sl.Size(), nodes)
+	assert.LessOrEqual(t, nodes, 8*100+50)
+}
@@ -0,0 +1,128 @@
+package bitcask_go
+
+import (
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"sync"
+)
+
+// decodedDataFile 一个数据文件解码得到的索引记录，按照写入顺序排列
+type decodedDataFile struct {
+	entries []*hintEntry
+	offset  int64 // 最后一条有效记录之后的偏移
+	records uint  // 记录数
+	err     error
+	done    chan struct{}
+}
+
+// dataFileDecoder 并行解码数据文件，同时解码的文件数不超过 IndexLoadParallelism
+// 调用方按照文件顺序等待每个文件的结果，应用到索引之后调用 release 释放名额，因此内存中最多保留 IndexLoadParallelism 个文件的解码结果
+type dataFileDecoder struct {
+	results []*decodedDataFile
+	sem     chan struct{}
+	stop    chan struct{}
+	wg      *sync.WaitGroup
+}
+
+// decodeDataFiles 开始并行解码 dataFiles，最后一个文件是活跃文件，完整扫描；封存的文件优先读取 hint 文件
+func (db *DB) decodeDataFiles(dataFiles []*data.DataFile) *dataFileDecoder {
+	decoder := &dataFileDecoder{
+		results: make([]*decodedDataFile, len(dataFiles)),
+		sem:     make(chan struct{}, db.options.IndexLoadParallelism),
+		stop:    make(chan struct{}),
+		wg:      new(sync.WaitGroup),
+	}
+	for i := range decoder.results {
+		decoder.results[i] = &decodedDataFile{done: make(chan struct{})}
+	}
+	decoder.wg.Add(1)
+	go func() {
+		defer decoder.wg.Done()
+		for i, dataFile := range dataFiles {
+			select {
+			case decoder.sem <- struct{}{}:
+			case <-decoder.stop:
+				return
+			}
+			decoder.wg.Add(1)
+			go func(result *decodedDataFile, dataFile *data.DataFile, active bool) {
+				defer decoder.wg.Done()
+				defer close(result.done)
+				if active {
+					result.err = db.decodeActiveFile(dataFile, result)
+				} else {
+					result.err = db.decodeSealedFile(dataFile, result)
+				}
+			}(decoder.results[i], dataFile, i == len(dataFiles)-1)
+		}
+	}()
+	return decoder
+}
+
+// wait 等待第 i 个文件解码完成
+func (d *dataFileDecoder) wait(i int) *decodedDataFile {
+	<-d.results[i].done
+	return d.results[i]
+}
+
+// release 第 i 个文件的结果已经应用，释放内存以及并发名额
+func (d *dataFileDecoder) release(i int) {
+	d.results[i] = nil
+	<-d.sem
+}
+
+// close 停止解码并等待正在进行的解码结束
+func (d *dataFileDecoder) close() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+// decodeActiveFile 扫描活跃文件，加载了索引快照时从快照覆盖到的位置开始扫描
+func (db *DB) decodeActiveFile(dataFile *data.DataFile, result *decodedDataFile) error {
+	var startOffset int64 = data.FileHeaderSize
+	if db.snapshotPos != nil && db.snapshotPos.fid == dataFile.FileId {
+		startOffset = db.snapshotPos.offset
+	}
+	offset, records, err := scanDataFile(dataFile, startOffset, func(logRecord *data.LogRecord, pos *data.LogRecordPos) error {
+		result.entries = append(result.entries, newHintEntry(logRecord, pos))
+		return nil
+	})
+	result.offset, result.records = offset, records
+	return err
+}
+
+// decodeSealedFile 从 hint 文件中读取封存文件的索引，hint 文件不存在或者已经失效时扫描数据文件，同时重新生成 hint 文件
+func (db *DB) decodeSealedFile(dataFile *data.DataFile, result *decodedDataFile) error {
+	entries, ok, err := db.readHintFile(dataFile)
+	if err != nil {
+		return err
+	}
+	if ok {
+		result.entries = entries
+		return nil
+	}
+	writer, err := newHintWriter(db.vfs, db.options.DirPath, dataFile)
+	if err != nil {
+		return err
+	}
+	_, _, err = scanDataFile(dataFile, data.FileHeaderSize, func(logRecord *data.LogRecord, pos *data.LogRecordPos) error {
+		if err := writer.add(logRecord, pos); err != nil {
+			return err
+		}
+		result.entries = append(result.entries, newHintEntry(logRecord, pos))
+		return nil
+	})
+	if err != nil {
+		writer.abort()
+		return err
+	}
+	return writer.commit()
+}
+
+// newHintEntry 记录中的 key 和 value 共用同一块内存，复制 key 避免保留 value
+func newHintEntry(logRecord *data.LogRecord, pos *data.LogRecordPos) *hintEntry {
+	return &hintEntry{
+		key: append([]byte(nil), logRecord.Key...),
+		typ: logRecord.Type,
+		pos: pos,
+	}
+}
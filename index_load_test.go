@@ -0,0 +1,102 @@
+package bitcask_go
+
+import (
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_ParallelIndexLoad(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-load")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3000; i++ {
+		err := db.Put(utils.GetTestKey(i%1000), utils.RandomValue(32))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 200; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	// 事务跨越多个数据文件，没有提交的事务不可见
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 2000; i++ {
+		_ = wb.Put(utils.GetTestKey(i), utils.RandomValue(32))
+	}
+	assert.Nil(t, wb.Commit())
+	for i := 2000; i < 2100; i++ {
+		record := &data.LogRecord{
+			Key:   logRecordKeyWithSeq(utils.GetTestKey(i), db.seqNo+1),
+			Value: utils.RandomValue(32),
+			Type:  data.LogRecordNormal,
+		}
+		_, err := db.appendLogRecordWithLock(record)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Close())
+
+	type loaded struct {
+		keys     [][]byte
+		seqNo    uint64
+		writeOff int64
+		reclaim  int64
+		progress []IndexLoadProgress
+	}
+	load := func(parallelism int) *loaded {
+		opts.IndexLoadParallelism = parallelism
+		res := &loaded{}
+		opts.OnIndexLoadProgress = func(progress IndexLoadProgress) {
+			res.progress = append(res.progress, progress)
+		}
+		db2, err := Open(opts)
+		assert.Nil(t, err)
+		defer func() {
+			_ = db2.Close()
+		}()
+		res.keys = db2.ListKeys()
+		res.seqNo = db2.seqNo
+		res.writeOff = db2.activeFile.WriteOff
+		res.reclaim = db2.reclaimSize
+		return res
+	}
+
+	sequential := load(1)
+	assert.Equal(t, 1800, len(sequential.keys))
+	assert.True(t, len(sequential.progress) > 3)
+	last := sequential.progress[len(sequential.progress)-1]
+	assert.Equal(t, last.TotalFiles, last.LoadedFiles)
+	assert.Equal(t, last.TotalBytes, last.LoadedBytes)
+	for i, progress := range sequential.progress {
+		assert.Equal(t, i+1, progress.LoadedFiles)
+	}
+
+	// 并行加载的结果和顺序加载一致，包括重新扫描数据文件的情况
+	parallel := load(8)
+	hints, _ := filepath.Glob(filepath.Join(dir, "*"+data.HintFileNameSuffix))
+	assert.True(t, len(hints) > 0)
+	for _, hint := range hints {
+		assert.Nil(t, os.Remove(hint))
+	}
+	rescanned := load(8)
+	for _, res := range []*loaded{parallel, rescanned} {
+		assert.Equal(t, sequential.keys, res.keys)
+		assert.Equal(t, sequential.seqNo, res.seqNo)
+		assert.Equal(t, sequential.writeOff, res.writeOff)
+		assert.Equal(t, sequential.reclaim, res.reclaim)
+		assert.Equal(t, sequential.progress, res.progress)
+	}
+
+	// 为 0 时使用 CPU 核数
+	assert.Equal(t, sequential.keys, load(0).keys)
+	opts.IndexLoadParallelism = -1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
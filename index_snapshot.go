@@ -0,0 +1,224 @@
+package bitcask_go
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/fio"
+	"github.com/Tuanzi-bug/TuanKV/index"
+	"hash/crc32"
+	"io/fs"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// 索引快照保存内存索引的全部内容以及快照覆盖到的日志位置，启动时加载快照之后只需要重放之后写入的记录
+//
+//	+-------+---------+----------+-------------+-----------------+--------+--------------+-------+---------+-----+
+//	| magic | version | reserved | covered fid | covered offset  | file   | seq no       | rec-  | entries | crc |
+//	|       |         |          |             |                 | ctime  |              | laim  |         |     |
+//	+-------+---------+----------+-------------+-----------------+--------+--------------+-------+---------+-----+
+//	   4         2         2           4               8             8          8            8               4
+//
+// 每条索引记录依次保存 key 的长度、key 以及位置信息，长度和位置信息都使用变长编码
+const (
+	indexSnapshotMagic      uint32 = 0x53564b54 // "TKVS"
+	indexSnapshotVersion    uint16 = 1
+	indexSnapshotHeaderSize        = 44
+)
+
+// indexSnapshotPos 快照覆盖到的日志位置，该位置之前的记录都已经反映在快照中
+type indexSnapshotPos struct {
+	fid           uint32
+	offset        int64
+	fileCreatedAt int64 // 所在数据文件的创建时间，用来判断数据文件是否被替换
+}
+
+// indexSnapshotEnabled 判断是否需要保存索引快照，B+ 树索引本身持久化在磁盘中
+func (db *DB) indexSnapshotEnabled() bool {
+	return db.options.IndexSnapshot && db.options.IndexType != index.BPTree
+}
+
+// encodeIndexSnapshot 编码当前的索引快照，调用方需要持有 db.mu，返回 nil 表示和上一次快照相比没有新的写入
+func (db *DB) encodeIndexSnapshot() ([]byte, *indexSnapshotPos) {
+	if db.activeFile == nil || db.activeFile.Header == nil {
+		return nil, nil
+	}
+	pos := &indexSnapshotPos{
+		fid:           db.activeFile.FileId,
+		offset:        db.activeFile.WriteOff,
+		fileCreatedAt: db.activeFile.Header.CreatedAt.UnixNano(),
+	}
+	if db.lastSnapshotPos != nil && *db.lastSnapshotPos == *pos {
+		return nil, nil
+	}
+
+	buf := make([]byte, indexSnapshotHeaderSize, indexSnapshotHeaderSize+db.index.Size()*32)
+	binary.LittleEndian.PutUint32(buf[0:4], indexSnapshotMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], indexSnapshotVersion)
+	binary.LittleEndian.PutUint32(buf[8:12], pos.fid)
+	binary.LittleEndian.PutUint64(buf[12:20], uint64(pos.offset))
+	binary.LittleEndian.PutUint64(buf[20:28], uint64(pos.fileCreatedAt))
+	binary.LittleEndian.PutUint64(buf[28:36], db.seqNo)
+	binary.LittleEndian.PutUint64(buf[36:44], uint64(atomic.LoadInt64(&db.reclaimSize)))
+
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	varint := make([]byte, binary.MaxVarintLen64)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		key, logRecordPos := iterator.Key(), iterator.Value()
+		buf = append(buf, varint[:binary.PutUvarint(varint, uint64(len(key)))]...)
+		buf = append(buf, key...)
+		buf = append(buf, varint[:binary.PutUvarint(varint, uint64(logRecordPos.Fid))]...)
+		buf = append(buf, varint[:binary.PutVarint(varint, logRecordPos.Offset)]...)
+		buf = append(buf, varint[:binary.PutUvarint(varint, logRecordPos.Size)]...)
+	}
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf)), pos
+}
+
+// saveIndexSnapshot 将编码后的快照写入临时文件，持久化之后替换原来的快照
+func (db *DB) saveIndexSnapshot(buf []byte, pos *indexSnapshotPos) error {
+	fileName := filepath.Join(db.options.DirPath, data.IndexSnapshotFileName)
+	tmpFileName := fileName + tmpFileSuffix
+	if err := fio.WriteFile(db.vfs, tmpFileName, buf); err != nil {
+		return err
+	}
+	if err := db.vfs.Rename(tmpFileName, fileName); err != nil {
+		return err
+	}
+	db.lastSnapshotPos = pos
+	return nil
+}
+
+// SnapshotIndex 保存一次索引快照，快照期间阻塞写入，但不阻塞读取
+func (db *DB) SnapshotIndex() error {
+	if !db.indexSnapshotEnabled() {
+		return nil
+	}
+	db.snapshotLock.Lock()
+	defer db.snapshotLock.Unlock()
+	// 等待正在更新索引的写入完成，快照中的索引包含它覆盖的位置之前的所有记录
+	db.writeLock.Lock()
+	db.mu.RLock()
+	buf, pos := db.encodeIndexSnapshot()
+	db.mu.RUnlock()
+	db.writeLock.Unlock()
+	if buf == nil {
+		return nil
+	}
+	return db.saveIndexSnapshot(buf, pos)
+}
+
+// startIndexSnapshotLoop 按照 IndexSnapshotInterval 定期保存索引快照，关闭数据库时退出
+func (db *DB) startIndexSnapshotLoop() {
+	if !db.indexSnapshotEnabled() || db.options.IndexSnapshotInterval <= 0 {
+		return
+	}
+	db.bgWg.Add(1)
+	go func() {
+		defer db.bgWg.Done()
+		ticker := time.NewTicker(db.options.IndexSnapshotInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = db.SnapshotIndex()
+			case <-db.closeCh:
+				return
+			}
+		}
+	}()
+}
+
+// loadIndexSnapshot 加载索引快照，快照不存在、损坏或者和数据文件不匹配时返回 false，之后从数据文件中重建索引
+func (db *DB) loadIndexSnapshot() (bool, error) {
+	if !db.indexSnapshotEnabled() {
+		return false, nil
+	}
+	buf, err := fio.ReadFile(db.vfs, filepath.Join(db.options.DirPath, data.IndexSnapshotFileName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if len(buf) < indexSnapshotHeaderSize+crc32.Size ||
+		binary.LittleEndian.Uint32(buf[0:4]) != indexSnapshotMagic ||
+		binary.LittleEndian.Uint16(buf[4:6]) != indexSnapshotVersion {
+		return false, nil
+	}
+	body := buf[:len(buf)-crc32.Size]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(buf[len(body):]) {
+		return false, nil
+	}
+	pos := &indexSnapshotPos{
+		fid:           binary.LittleEndian.Uint32(body[8:12]),
+		offset:        int64(binary.LittleEndian.Uint64(body[12:20])),
+		fileCreatedAt: int64(binary.LittleEndian.Uint64(body[20:28])),
+	}
+	if !db.validSnapshotPos(pos) {
+		return false, nil
+	}
+
+	type snapshotEntry struct {
+		key []byte
+		pos *data.LogRecordPos
+	}
+	var entries []snapshotEntry
+	for i := indexSnapshotHeaderSize; i < len(body); {
+		keySize, n := binary.Uvarint(body[i:])
+		if n <= 0 || uint64(len(body)-i-n) < keySize {
+			return false, nil
+		}
+		i += n
+		// 复制 key，避免索引引用整个快照文件的内容
+		key := append([]byte(nil), body[i:i+int(keySize)]...)
+		i += int(keySize)
+		fid, n1 := binary.Uvarint(body[i:])
+		if n1 <= 0 {
+			return false, nil
+		}
+		offset, n2 := binary.Varint(body[i+n1:])
+		if n2 <= 0 {
+			return false, nil
+		}
+		size, n3 := binary.Uvarint(body[i+n1+n2:])
+		if n3 <= 0 {
+			return false, nil
+		}
+		i += n1 + n2 + n3
+		entries = append(entries, snapshotEntry{
+			key: key,
+			pos: &data.LogRecordPos{Fid: uint32(fid), Offset: offset, Size: size},
+		})
+	}
+
+	for _, entry := range entries {
+		db.index.Put(entry.key, entry.pos)
+	}
+	db.seqNo = binary.LittleEndian.Uint64(body[28:36])
+	atomic.StoreInt64(&db.reclaimSize, int64(binary.LittleEndian.Uint64(body[36:44])))
+	db.snapshotPos = pos
+	db.lastSnapshotPos = pos
+	return true, nil
+}
+
+// validSnapshotPos 判断快照覆盖到的数据文件是否存在、没有被 merge 或者回收替换，并且没有丢失快照覆盖的数据
+func (db *DB) validSnapshotPos(pos *indexSnapshotPos) bool {
+	dataFile, err := db.getDataFile(pos.fid)
+	if err != nil || dataFile.Header == nil || dataFile.Header.CreatedAt.UnixNano() != pos.fileCreatedAt {
+		return false
+	}
+	size, err := dataFile.IoManager.Size()
+	return err == nil && size >= pos.offset
+}
+
+// removeIndexSnapshot 数据文件被重写之后快照中的位置全部失效，需要删除快照
+func (db *DB) removeIndexSnapshot() error {
+	err := db.vfs.Remove(filepath.Join(db.options.DirPath, data.IndexSnapshotFileName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
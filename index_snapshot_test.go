@@ -0,0 +1,172 @@
+package bitcask_go
+
+import (
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_IndexSnapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-snapshot")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 2000; i < 2100; i++ {
+		_ = wb.Put(utils.GetTestKey(i), utils.RandomValue(64))
+	}
+	assert.Nil(t, wb.Commit())
+	reclaimSize := db.reclaimSize
+	assert.Nil(t, db.Close())
+	_, err = os.Stat(filepath.Join(dir, data.IndexSnapshotFileName))
+	assert.Nil(t, err)
+
+	// 从快照中加载索引，快照之后没有新的写入
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db.snapshotPos)
+	assert.Equal(t, 1600, len(db.ListKeys()))
+	assert.Equal(t, reclaimSize, db.reclaimSize)
+	assert.Equal(t, uint64(1), db.seqNo)
+	_, err = db.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(2050))
+	assert.Nil(t, err)
+
+	// 快照之后的写入在启动时重放
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new value")))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(1000)))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1699, len(db.ListKeys()))
+	value, err := db.Get(utils.GetTestKey(50))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), value)
+	_, err = db.Get(utils.GetTestKey(1000))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_IndexSnapshotReplayTail(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-snapshot-tail")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.SnapshotIndex())
+	// 快照之后写入的记录跨越多个数据文件
+	for i := 1000; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 没有关闭数据库时复制数据目录，模拟进程异常退出
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-index-snapshot-crash")
+	assert.Nil(t, db.Backup(backupDir))
+	opts2 := opts
+	opts2.DirPath = backupDir
+	db2, err := Open(opts2)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.NotNil(t, db2.snapshotPos)
+	assert.Equal(t, 2800, len(db2.ListKeys()))
+	assert.Equal(t, db.reclaimSize, db2.reclaimSize)
+	for i := 0; i < 3000; i++ {
+		value, err := db2.Get(utils.GetTestKey(i))
+		if i < 200 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		expected, _ := db.Get(utils.GetTestKey(i))
+		assert.Equal(t, expected, value)
+	}
+}
+
+func TestDB_IndexSnapshotInvalid(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-snapshot-invalid")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 损坏的快照被忽略，从数据文件中重建索引
+	fileName := filepath.Join(dir, data.IndexSnapshotFileName)
+	buf, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	buf[len(buf)/2] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, buf, 0644))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.snapshotPos)
+	assert.Equal(t, 2000, len(db.ListKeys()))
+
+	// merge 之后快照失效
+	for i := 0; i < 1500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.snapshotPos)
+	assert.Equal(t, 500, len(db.ListKeys()))
+	for i := 1500; i < 2000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_IndexSnapshotInterval(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-snapshot-interval")
+	opts.DirPath = dir
+	opts.IndexSnapshot = true
+	opts.IndexSnapshotInterval = 20 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, data.IndexSnapshotFileName))
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
@@ -2,6 +2,7 @@ package bitcask_go
 
 import (
 	"bytes"
+	"github.com/Tuanzi-bug/TuanKV/data"
 	"github.com/Tuanzi-bug/TuanKV/index"
 )
 
@@ -9,54 +10,180 @@ type Iterator struct {
 	indexIter index.Iterator
 	db        *DB
 	options   IteratorOptions
+
+	// 开启预读时从索引迭代器中预先取出的位置，current 为当前位置
+	window  []*prefetchEntry
+	current int
+
+	// exhausted 指定了前缀时，索引迭代器已经越过了前缀的范围，之后不会再有匹配的 key
+	exhausted bool
+
+	// unordered 索引迭代器按照不确定的顺序遍历，只能逐个过滤前缀，不能提前结束
+	unordered bool
+}
+
+// prefetchEntry 预读窗口中的一个位置，value 在第一次读取时和窗口中之后的位置一起批量读取
+type prefetchEntry struct {
+	key    []byte
+	pos    *data.LogRecordPos
+	value  []byte
+	loaded bool
 }
 
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
+	indexIter, unordered := db.newIndexIterator(opts)
 	return &Iterator{
 		indexIter: indexIter,
 		db:        db,
 		options:   opts,
+		unordered: unordered,
 	}
 }
 
 func (it *Iterator) Rewind() {
-	it.indexIter.Rewind()
+	it.exhausted = false
+	// 正向遍历时直接定位到第一个可能匹配前缀的 key，反向遍历时定位到前缀后继之前的最后一个 key
+	switch {
+	case len(it.options.Prefix) == 0 || it.unordered:
+		it.indexIter.Rewind()
+	case !it.options.Reverse:
+		it.indexIter.Seek(it.options.Prefix)
+	default:
+		if successor := prefixSuccessor(it.options.Prefix); successor != nil {
+			it.indexIter.Seek(successor)
+		} else {
+			it.indexIter.Rewind()
+		}
+	}
 	it.skipToNext()
+	it.fillWindow()
 }
 func (it *Iterator) Seek(key []byte) {
+	it.exhausted = false
 	it.indexIter.Seek(key)
 	it.skipToNext()
+	it.fillWindow()
 }
 func (it *Iterator) Next() {
+	if it.options.Prefetch > 0 {
+		it.current++
+		if it.current >= len(it.window) {
+			it.fillWindow()
+		}
+		return
+	}
 	it.indexIter.Next()
 	it.skipToNext()
 }
 func (it *Iterator) Valid() bool {
-	return it.indexIter.Valid()
+	if it.options.Prefetch > 0 {
+		return it.current < len(it.window)
+	}
+	return !it.exhausted && it.indexIter.Valid()
 }
 func (it *Iterator) Key() []byte {
+	if it.options.Prefetch > 0 {
+		return it.window[it.current].key
+	}
 	return it.indexIter.Key()
 }
 func (it *Iterator) Value() ([]byte, error) {
-	pos := it.indexIter.Value()
-	it.db.mu.RLock()
-	defer it.db.mu.RUnlock()
-	return it.db.getValueByPosition(pos)
+	it.db.filesLock.RLock()
+	defer it.db.filesLock.RUnlock()
+	if it.options.Prefetch > 0 {
+		return it.prefetchValue()
+	}
+	return it.db.getValueByPosition(it.indexIter.Value())
 }
+
+// Meta 返回当前位置记录的元信息，不读取 value
+func (it *Iterator) Meta() (*RecordMeta, error) {
+	pos := it.position()
+	it.db.filesLock.RLock()
+	defer it.db.filesLock.RUnlock()
+	logRecord, err := it.db.getLogRecordByPosition(pos, -1)
+	if err != nil {
+		return nil, err
+	}
+	return newRecordMeta(logRecord, pos), nil
+}
+
 func (it *Iterator) Close() {
 	it.indexIter.Close()
 }
 
+func (it *Iterator) position() *data.LogRecordPos {
+	if it.options.Prefetch > 0 {
+		return it.window[it.current].pos
+	}
+	return it.indexIter.Value()
+}
+
+// fillWindow 从索引迭代器中取出之后的 Prefetch 个位置
+func (it *Iterator) fillWindow() {
+	if it.options.Prefetch <= 0 {
+		return
+	}
+	it.window = it.window[:0]
+	it.current = 0
+	for len(it.window) < it.options.Prefetch && !it.exhausted && it.indexIter.Valid() {
+		it.window = append(it.window, &prefetchEntry{key: it.indexIter.Key(), pos: it.indexIter.Value()})
+		it.indexIter.Next()
+		it.skipToNext()
+	}
+}
+
+// prefetchValue 当前位置的 value 还没有读取时，批量读取窗口中剩余位置的 value
+func (it *Iterator) prefetchValue() ([]byte, error) {
+	entry := it.window[it.current]
+	if !entry.loaded {
+		rest := it.window[it.current:]
+		positions := make([]*data.LogRecordPos, len(rest))
+		for i, e := range rest {
+			positions[i] = e.pos
+		}
+		values, err := it.db.getValuesByPositions(positions)
+		if err != nil {
+			return nil, err
+		}
+		for i, e := range rest {
+			e.value, e.loaded = values[i], true
+		}
+	}
+	return entry.value, nil
+}
+
+// skipToNext 跳过不匹配前缀的 key，有序遍历时越过前缀的范围之后停止遍历，不再访问之后的 key
 func (it *Iterator) skipToNext() {
-	PrefixLen := len(it.options.Prefix)
-	if PrefixLen == 0 {
+	prefix := it.options.Prefix
+	if len(prefix) == 0 {
 		return
 	}
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
 		key := it.indexIter.Key()
-		if PrefixLen < len(key) && bytes.Compare(key, it.options.Prefix) == 0 {
-			break
+		if bytes.HasPrefix(key, prefix) {
+			return
+		}
+		if it.unordered {
+			continue
+		}
+		// 匹配前缀的 key 都不小于前缀，并且小于所有比前缀大但不匹配前缀的 key
+		cmp := bytes.Compare(key, prefix)
+		if (!it.options.Reverse && cmp > 0) || (it.options.Reverse && cmp < 0) {
+			it.exhausted = true
+			return
+		}
+	}
+}
+
+// prefixSuccessor 返回大于所有匹配 prefix 的 key 的最小的 key，prefix 全部是 0xff 时返回 nil
+func prefixSuccessor(prefix []byte) []byte {
+	successor := append([]byte(nil), prefix...)
+	for i := len(successor) - 1; i >= 0; i-- {
+		if successor[i] < 0xff {
+			successor[i]++
+			return successor[:i+1]
 		}
 	}
+	return nil
 }
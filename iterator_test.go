@@ -1,6 +1,8 @@
 package bitcask_go
 
 import (
+	"bytes"
+	"github.com/Tuanzi-bug/TuanKV/index"
 	"github.com/Tuanzi-bug/TuanKV/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
@@ -96,3 +98,164 @@ func TestDB_Iterator_Multi_Values(t *testing.T) {
 	}
 	iter3.Close()
 }
+
+func TestDB_Iterator_Prefix(t *testing.T) {
+	for _, shards := range []int{1, 4} {
+		for _, indexType := range []index.IndexType{index.Btree, index.Art} {
+			testIteratorPrefix(t, indexType, shards)
+		}
+	}
+}
+
+func testIteratorPrefix(t *testing.T, indexType index.IndexType, shards int) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-prefix")
+	opts.DirPath = dir
+	opts.IndexType = indexType
+	opts.IndexShards = shards
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for _, key := range []string{"a", "ab", "abc", "abd", "ac", "b", "bab"} {
+		assert.Nil(t, db.Put([]byte(key), []byte(key)))
+	}
+
+	for _, prefetch := range []int{0, 2} {
+		iterOpts := DefaultIteratorOptions
+		iterOpts.Prefix = []byte("ab")
+		iterOpts.Prefetch = prefetch
+		var keys []string
+		iter := db.NewIterator(iterOpts)
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			value, err := iter.Value()
+			assert.Nil(t, err)
+			assert.Equal(t, iter.Key(), value)
+			keys = append(keys, string(iter.Key()))
+		}
+		iter.Close()
+		assert.Equal(t, []string{"ab", "abc", "abd"}, keys)
+
+		iterOpts.Reverse = true
+		keys = nil
+		iter = db.NewIterator(iterOpts)
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		iter.Close()
+		assert.Equal(t, []string{"abd", "abc", "ab"}, keys)
+	}
+	destroyDB(db)
+}
+
+// countingIterator 统计索引迭代器上 Next 的调用次数
+type countingIterator struct {
+	index.Iterator
+	steps *int
+}
+
+func (it *countingIterator) Next() {
+	*it.steps++
+	it.Iterator.Next()
+}
+
+// 前缀遍历越过前缀的范围之后停止，不访问之后的 key
+func TestDB_Iterator_PrefixStopsEarly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-prefix-stop")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("value")))
+	}
+	// 所有的 key 都大于 0xff 开头的前缀的时候也可以反向遍历
+	assert.Nil(t, db.Put([]byte{0xff, 0xff}, []byte("value")))
+
+	for _, reverse := range []bool{false, true} {
+		for _, prefetch := range []int{0, 4} {
+			iterOpts := DefaultIteratorOptions
+			iterOpts.Prefix = utils.GetTestKey(999)
+			iterOpts.Reverse = reverse
+			iterOpts.Prefetch = prefetch
+			iter := db.NewIterator(iterOpts)
+			var steps int
+			iter.indexIter = &countingIterator{Iterator: iter.indexIter, steps: &steps}
+			var keys int
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				keys++
+			}
+			iter.Close()
+			// bitcask-go-key-999 只匹配自身
+			assert.Equal(t, 1, keys)
+			assert.LessOrEqual(t, steps, 2)
+		}
+	}
+
+	iterOpts := DefaultIteratorOptions
+	iterOpts.Prefix = []byte{0xff}
+	iterOpts.Reverse = true
+	iter := db.NewIterator(iterOpts)
+	iter.Rewind()
+	assert.True(t, iter.Valid())
+	assert.Equal(t, []byte{0xff, 0xff}, iter.Key())
+	iter.Next()
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
+
+func TestDB_Iterator_Unordered(t *testing.T) {
+	for _, shards := range []int{1, 4} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-iterator-unordered")
+		opts.DirPath = dir
+		opts.IndexType = index.Hash
+		opts.IndexShards = shards
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		for i := 0; i < 1000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+		}
+
+		for _, prefetch := range []int{0, 4} {
+			iterOpts := DefaultIteratorOptions
+			iterOpts.Unordered = true
+			iterOpts.Prefetch = prefetch
+			iter := db.NewIterator(iterOpts)
+			assert.True(t, iter.unordered)
+			seen := make(map[string]bool)
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				value, err := iter.Value()
+				assert.Nil(t, err)
+				assert.Equal(t, iter.Key(), value)
+				seen[string(iter.Key())] = true
+			}
+			iter.Close()
+			assert.Equal(t, 1000, len(seen))
+
+			// 前缀匹配的 key 分散在遍历的顺序中，不能在第一个不匹配的 key 处结束
+			iterOpts.Prefix = []byte("bitcask-go-key-99")
+			iter = db.NewIterator(iterOpts)
+			var keys int
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				assert.True(t, bytes.HasPrefix(iter.Key(), iterOpts.Prefix))
+				keys++
+			}
+			iter.Close()
+			assert.Equal(t, 11, keys)
+		}
+		destroyDB(db)
+	}
+
+	// 不支持的索引仍然按照 key 的顺序遍历
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-unordered-btree")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	iterOpts := DefaultIteratorOptions
+	iterOpts.Unordered = true
+	iter := db.NewIterator(iterOpts)
+	assert.False(t, iter.unordered)
+	iter.Close()
+}
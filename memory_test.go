@@ -0,0 +1,99 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"github.com/Tuanzi-bug/TuanKV/index"
+	"github.com/Tuanzi-bug/TuanKV/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_InMemory(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-memory")
+	defer os.RemoveAll(dir)
+	opts.DirPath = filepath.Join(dir, "db")
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.InMemory = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(5000), []byte("batch")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(1000)))
+	assert.Nil(t, wb.Commit())
+	largeValue := bytes.Repeat([]byte("v"), 128*1024)
+	assert.Nil(t, db.PutStream(utils.GetTestKey(6000), bytes.NewReader(largeValue)))
+
+	// 不创建任何磁盘文件，也没有数据目录
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	check := func(db *DB) {
+		assert.Equal(t, 1001, len(db.ListKeys()))
+		_, err := db.Get(utils.GetTestKey(10))
+		assert.Equal(t, ErrKeyNotFound, err)
+		_, err = db.Get(utils.GetTestKey(1000))
+		assert.Equal(t, ErrKeyNotFound, err)
+		value, err := db.Get(utils.GetTestKey(1500))
+		assert.Nil(t, err)
+		assert.NotNil(t, value)
+		value, err = db.Get(utils.GetTestKey(5000))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("batch"), value)
+		value, err = db.Get(utils.GetTestKey(6000))
+		assert.Nil(t, err)
+		assert.Equal(t, largeValue, value)
+	}
+	check(db)
+
+	// merge 的结果立即生效，失效数据占用的内存被释放
+	before := db.Stat()
+	assert.Nil(t, db.Merge())
+	after := db.Stat()
+	assert.Less(t, after.DiskSize, before.DiskSize)
+	assert.Less(t, after.DataFileNum, before.DataFileNum)
+	check(db)
+	assert.Nil(t, db.Put(utils.GetTestKey(7000), []byte("after merge")))
+	value, err := db.Get(utils.GetTestKey(7000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after merge"), value)
+	assert.Nil(t, db.Close())
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// 每次打开都是新的数据库，多个实例之间互不影响
+	db1, err := Open(opts)
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(db1.ListKeys()))
+	assert.Nil(t, db1.Put([]byte("key"), []byte("value")))
+	_, err = db2.Get([]byte("key"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, db1.Close())
+	assert.Nil(t, db2.Close())
+}
+
+func TestDB_InMemoryOptions(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = ""
+	opts.InMemory = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.Close())
+
+	opts.IndexType = index.BPTree
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
@@ -3,70 +3,90 @@ package bitcask_go
 import (
 	"errors"
 	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/fio"
 	"github.com/Tuanzi-bug/TuanKV/index"
 	"github.com/Tuanzi-bug/TuanKV/utils"
 	"io"
 	"io/fs"
-	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"sync/atomic"
 )
 
 const (
 	mergeDirName   = "-merge"
 	mergeFinishKey = "merge.finish"
+
+	// 从 hint 文件加载索引时每批写入的记录数
+	hintLoadBatchSize = 4096
 )
 
-func (db *DB) Merge() error {
+func (db *DB) Merge() (err error) {
 	// 判空
 	if db.activeFile == nil {
 		return nil
 	}
+	// 等待正在更新索引的写入完成，封存的文件中的记录都已经更新到索引中，merge 时才能根据索引判断记录是否有效
+	db.writeLock.Lock()
 	db.mu.Lock()
+	unlock := func() {
+		db.mu.Unlock()
+		db.writeLock.Unlock()
+	}
 	// 如果正在 merge，不能重复进行merge
 	if db.isMerging {
-		db.mu.Unlock() // TODO: 弄清楚这里为什么需要解锁
+		unlock() // TODO: 弄清楚这里为什么需要解锁
 		return ErrMergeIsProgress
 	}
 	// 查询merge的数据量
-	totalSize, err := utils.DirSize(db.options.DirPath)
+	totalSize, err := fio.DirSize(db.vfs, db.options.DirPath)
 	if err != nil {
-		db.mu.Unlock()
+		unlock()
 		return err
 	}
 	// 判断当前无效数据量满足merge的阈值
-	if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
-		db.mu.Unlock()
+	if float32(atomic.LoadInt64(&db.reclaimSize))/float32(totalSize) < db.options.DataFileMergeRatio {
+		unlock()
 		return ErrMergeRatioUnreached
 	}
 	// 判断 当前磁盘容量是否满足merge的需求，一般磁盘容量是当前数据量的两倍
-	availableDiskSize, err := utils.AvailableDiskSize()
-	if err != nil {
-		db.mu.Unlock()
-		return err
-	}
-	if uint64(totalSize-db.reclaimSize) >= availableDiskSize {
-		db.mu.Unlock()
-		return ErrNoEnoughSpaceForMerge
+	if db.onLocalDisk() {
+		availableDiskSize, err := utils.AvailableDiskSize()
+		if err != nil {
+			unlock()
+			return err
+		}
+		if uint64(totalSize-atomic.LoadInt64(&db.reclaimSize)) >= availableDiskSize {
+			unlock()
+			return ErrNoEnoughSpaceForMerge
+		}
 	}
 
 	db.isMerging = true
 	defer func() {
 		db.isMerging = false
 	}()
-	// 持久化当前活跃文件
-	if err := db.activeFile.Sync(); err != nil {
-		db.mu.Unlock()
-		return err
+	// merge 结束之后（包括失败时删除了之前的 merge 目录）重新统计磁盘占用
+	defer func() {
+		if loadErr := db.loadDiskUsage(); err == nil {
+			err = loadErr
+		}
+	}()
+	// 内存模式下不会重新打开数据库，merge 完成之后立即加载 merge 的结果，释放失效数据占用的内存
+	// 配置了磁盘配额时同样立即加载，否则要等到重新打开数据库之后才能释放磁盘空间
+	if db.options.InMemory || db.options.MaxDiskBytes > 0 {
+		defer func() {
+			if err == nil {
+				err = db.reload()
+			}
+		}()
 	}
-	// 将活跃文件转换为旧文件
-	db.olderFiles[db.activeFile.FileId] = db.activeFile
-	// 打开新的活跃文件，防止写入操作不能正常进行
-	if err := db.setActiveDataFile(); err != nil {
-		db.mu.Unlock()
-		return nil
+	// 持久化当前活跃文件并将其转换为旧文件，打开新的活跃文件，防止写入操作不能正常进行
+	if err := db.rotateActiveFile(); err != nil {
+		unlock()
+		return err
 	}
 	// 记录没有参加merge的id
 	nonMergeFileId := db.activeFile.FileId
@@ -75,52 +95,61 @@ func (db *DB) Merge() error {
 	for _, file := range db.olderFiles {
 		mergeFiles = append(mergeFiles, file)
 	}
-	db.mu.Unlock() // TODO: 弄清楚这里为什么需要解锁
+	unlock() // TODO: 弄清楚这里为什么需要解锁
 	// 排序
 	sort.Slice(mergeFiles, func(i, j int) bool {
 		return mergeFiles[i].FileId < mergeFiles[j].FileId
 	})
 	// 如果发生过merge，将其删除
 	mergePath := db.getMergePath()
-	if _, err := os.Stat(mergePath); err == nil {
-		if err = os.RemoveAll(mergePath); err != nil {
+	if _, err := db.vfs.Stat(mergePath); err == nil {
+		if err = db.vfs.RemoveAll(mergePath); err != nil {
 			return err
 		}
 	}
 	//创建 merge 目录
-	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
+	if err := db.vfs.MkdirAll(mergePath); err != nil {
 		return err
 	}
 	// 打开一个新的实例
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
+	mergeOptions.MaxDiskBytes = 0
+	mergeOptions.ValueCacheSize = 0
+	mergeOptions.BlockCacheSize = 0
+	// 保留原记录的写入时间
+	mergeOptions.RecordTimestamp = false
+	mergeOptions.IndexSnapshot = false
 	defer func() {
 		mergeOptions.SyncWrites = db.options.SyncWrites
 	}()
 
-	mergeDB, err := Open(mergeOptions)
+	mergeDB, err := open(mergeOptions, db.vfs)
 	defer mergeDB.Close()
 	if err != nil {
 		return err
 	}
+	mergeDB.skipHintFiles = true
 	// 创建 hint 文件储存索引
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err := data.OpenHintFile(db.vfs, mergePath)
 	defer hintFile.Close()
 	if err != nil {
 		return err
 	}
 	// 遍历需要merge的文件
 	for _, mergeFile := range mergeFiles {
-		var offset int64 = 0
+		var offset int64 = data.FileHeaderSize
 		for {
-			logRecord, size, err := mergeFile.GetLogRecord(offset)
+			// 大 value 不读入内存，确认记录有效之后再分块写入
+			logRecord, valueReader, size, err := mergeFile.ReadLogRecord(offset, data.StreamValueThreshold)
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
 				return err
 			}
+			db.mergeLimiter.Wait(size)
 			realKey, _ := parseLogRecordKey(logRecord.Key)
 			logRecordPos := db.index.Get(realKey)
 			// todo: 弄清楚对于事务完成记录是否进行清除
@@ -129,10 +158,18 @@ func (db *DB) Merge() error {
 				logRecordPos.Offset == offset {
 				// 清除事务标记
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
-				pos, err := mergeDB.appendLogRecordWithLock(logRecord)
+				var pos *data.LogRecordPos
+				if valueReader == nil {
+					pos, err = mergeDB.appendLogRecordWithLock(logRecord)
+				} else {
+					pos, err = mergeDB.appendValueReader(logRecord, valueReader)
+				}
 				if err != nil {
 					return err
 				}
+				db.mergeLimiter.Wait(int64(pos.Size))
+				// merge 的输出同样占用磁盘配额
+				db.addDiskUsage(int64(pos.Size))
 				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
 					return err
 				}
@@ -147,7 +184,7 @@ func (db *DB) Merge() error {
 		return err
 	}
 
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(db.vfs, mergePath)
 	defer mergeFinishedFile.Close()
 	if err != nil {
 		return err
@@ -167,6 +204,14 @@ func (db *DB) Merge() error {
 	return nil
 }
 
+// SetMergeRateLimit 修改 merge 和备份每秒读写的最大字节数，为 0 时不限速，对正在进行的 merge 和备份同样生效
+func (db *DB) SetMergeRateLimit(bytesPerSec int64) {
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	db.mergeLimiter.SetRate(bytesPerSec)
+}
+
 func (db *DB) getMergePath() string {
 	dir := path.Dir(path.Clean(db.options.DirPath))
 	base := path.Base(db.options.DirPath)
@@ -176,14 +221,14 @@ func (db *DB) getMergePath() string {
 func (db *DB) loadMergeFiles() error {
 	mergePath := db.getMergePath()
 	// 判断是否存储merge目录
-	if _, err := os.Stat(mergePath); errors.Is(err, fs.ErrNotExist) {
+	if _, err := db.vfs.Stat(mergePath); errors.Is(err, fs.ErrNotExist) {
 		return nil
 	}
 	defer func() {
-		_ = os.RemoveAll(mergePath)
+		_ = db.vfs.RemoveAll(mergePath)
 	}()
 	// 读取merge目录文件
-	dirEntries, err := os.ReadDir(mergePath)
+	dirEntries, err := db.vfs.ReadDir(mergePath)
 	if err != nil {
 		return err
 	}
@@ -204,6 +249,10 @@ func (db *DB) loadMergeFiles() error {
 	if !mergeFinished {
 		return nil
 	}
+	// merge 之后记录的位置发生了变化，索引快照失效
+	if err := db.removeIndexSnapshot(); err != nil {
+		return err
+	}
 
 	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
 	if err != nil {
@@ -212,8 +261,8 @@ func (db *DB) loadMergeFiles() error {
 	var fileId uint32 = 0
 	for ; fileId < nonMergeFileId; fileId++ {
 		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
+		if _, err := db.vfs.Stat(fileName); err == nil {
+			if err := db.removeDataFile(fileId); err != nil {
 				return err
 			}
 		}
@@ -222,15 +271,19 @@ func (db *DB) loadMergeFiles() error {
 	for _, fileName := range mergeFileNames {
 		srcPath := filepath.Join(mergePath, fileName)
 		destPath := filepath.Join(db.options.DirPath, fileName)
-		if err := os.Rename(srcPath, destPath); err != nil {
+		if err := db.vfs.Rename(srcPath, destPath); err != nil {
 			return err
 		}
 	}
+	// merge 之后数据的位置都发生了变化，之前缓存的数据全部失效
+	if db.valueCache != nil {
+		db.valueCache.Purge()
+	}
 	return nil
 }
 
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(db.vfs, dirPath)
 	defer mergeFinishedFile.Close()
 	if err != nil {
 		return 0, err
@@ -249,15 +302,17 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 
 func (db *DB) loadIndexFromHintFile() error {
 	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
-	if _, err := os.Stat(hintFileName); errors.Is(err, fs.ErrNotExist) {
+	if _, err := db.vfs.Stat(hintFileName); errors.Is(err, fs.ErrNotExist) {
 		return nil
 	}
-	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	hintFile, err := data.OpenHintFile(db.vfs, db.options.DirPath)
 	defer hintFile.Close()
 	if err != nil {
 		return err
 	}
 	var offset int64 = 0
+	// 分批写入索引，B+ 树索引每一批在一个事务中完成
+	var ops []index.BatchOp
 	for {
 		record, size, err := hintFile.GetLogRecord(offset)
 		if err != nil {
@@ -268,8 +323,15 @@ func (db *DB) loadIndexFromHintFile() error {
 		}
 
 		pos := data.DecodeLogRecordPos(record.Value)
-		db.index.Put(record.Key, pos)
+		ops = append(ops, index.BatchOp{Key: record.Key, Pos: pos})
+		if len(ops) >= hintLoadBatchSize {
+			if _, err := db.applyIndexOps(ops, false); err != nil {
+				return err
+			}
+			ops = ops[:0]
+		}
 		offset += size
 	}
-	return nil
+	_, err = db.applyIndexOps(ops, false)
+	return err
 }
@@ -1,11 +1,13 @@
 package bitcask_go
 
 import (
+	"github.com/Tuanzi-bug/TuanKV/index"
 	"github.com/Tuanzi-bug/TuanKV/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"sync"
 	"testing"
+	"time"
 )
 
 // 没有任何数据的情况下进行 merge
@@ -201,3 +203,133 @@ func TestDB_Merge5(t *testing.T) {
 		assert.NotNil(t, val)
 	}
 }
+
+// 开启预分配和文件回收之后进行 merge
+func TestDB_MergeRecycleDataFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-recycle")
+	opts.DataFileSize = 4 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.PreallocateDataFile = true
+	opts.RecycleDataFiles = true
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 20000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 重启之后失效的数据文件被回收
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, maxRecycledDataFiles, len(db2.recycledFiles))
+	assert.Equal(t, 0, len(db2.ListKeys()))
+
+	// 轮转时复用回收的文件
+	for i := 0; i < 10000; i++ {
+		err := db2.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db2.recycledFiles) < maxRecycledDataFiles)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, 10000, len(db3.ListKeys()))
+	for i := 0; i < 10000; i++ {
+		val, err := db3.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+}
+
+func TestDB_MergeRateLimit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-rate-limit")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.MergeRateLimit = 256 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 512; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(1024)))
+	}
+	// 读取和重写约 1MB 的数据，桶中只有 256KB 的令牌
+	start := time.Now()
+	assert.Nil(t, db.Merge())
+	assert.GreaterOrEqual(t, time.Since(start), 2*time.Second)
+
+	// 取消限速之后备份不再等待
+	db.SetMergeRateLimit(0)
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-merge-rate-limit-backup")
+	defer os.RemoveAll(backupDir)
+	start = time.Now()
+	assert.Nil(t, db.Backup(backupDir))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestDB_BackupWithConcurrentWrites(t *testing.T) {
+	for _, indexType := range []index.IndexType{index.Btree, index.BPTree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-backup-concurrent-writes")
+		opts.DirPath = dir
+		opts.IndexType = indexType
+		opts.MergeRateLimit = 256 * 1024
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		expected := make(map[string][]byte)
+		for i := 0; i < 512; i++ {
+			value := utils.RandomValue(1024)
+			assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+			expected[string(utils.GetTestKey(i))] = value
+		}
+
+		// 限速复制约 512KB 的数据需要 1 秒以上，复制期间写入不会被阻塞
+		backupDir, _ := os.MkdirTemp("", "bitcask-go-backup-concurrent-writes-backup")
+		done := make(chan error, 1)
+		go func() {
+			done <- db.Backup(backupDir)
+		}()
+		time.Sleep(100 * time.Millisecond)
+		for i := 512; i < 612; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(1024)))
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("backup finished before the concurrent writes, index: %d, err: %v", indexType, err)
+		default:
+		}
+		assert.Nil(t, <-done)
+		destroyDB(db)
+
+		// 备份只包含开始时已经写入的数据
+		opts.DirPath = backupDir
+		backup, err := Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, len(expected), len(backup.ListKeys()))
+		for key, value := range expected {
+			v, err := backup.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, v)
+		}
+		destroyDB(backup)
+	}
+}
@@ -2,8 +2,14 @@ package bitcask_go
 
 import (
 	"errors"
+	"fmt"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/fio"
 	"github.com/Tuanzi-bug/TuanKV/index"
+	"hash/fnv"
 	"path"
+	"runtime"
+	"time"
 )
 
 type Options struct {
@@ -13,18 +19,114 @@ type Options struct {
 
 	SyncWrites bool
 
-	IndexType index.IndexType // 引擎类别
+	// IndexType 内存索引的类型
+	// ART 索引正向遍历时在冻结的树上流式遍历，遍历期间的写入暂存到迭代器全部关闭为止；反向遍历和前缀遍历仍然需要复制 key
+	IndexType index.IndexType
+
+	// IndexShards 索引的分片数量，默认为 1（不分片），大于 1 时按照 key 的哈希把索引分成多个子索引，减少并发读写时的锁竞争，
+	// 有序遍历时需要合并所有分片，B+ 树索引不分片，忽略这个配置
+	IndexShards int
 
 	BytesPerSync uint
 
 	MMapAtStartup bool
 
+	// FileIOType 读写数据文件使用的 IO 类型，fio.DirectIO 绕过页缓存，fio.MemoryMap 在整个生命周期内使用内存映射，
+	// fio.IOUring 批量提交事务的写入和 Fold、迭代器的读取
+	FileIOType fio.FileIOType
+
+	// IOManagerFactory 不为 nil 时使用它创建数据文件的 IOManager，FileIOType 和 MMapAtStartup 不再生效，
+	// 可以配合 fio.FaultInjector 注入磁盘故障
+	IOManagerFactory fio.IOManagerFactory
+
+	// VFS 数据目录所在的文件系统，为 nil 时使用操作系统的文件系统，可以替换为内存、加密或者对象存储等实现
+	VFS fio.VFS
+
+	// InMemory 所有文件都保存在新建的 fio.MemFS 中，Open 不创建目录、文件锁和任何磁盘文件，关闭之后数据丢失，
+	// DirPath 只作为内存中文件的路径，可以为空，不能和 VFS 同时配置
+	InMemory bool
+
 	DataFileMergeRatio float32
+
+	// MergeRateLimit merge 和备份每秒读写的最大字节数，避免占满磁盘带宽影响前台的读取，为 0 时不限速，
+	// 运行时可以通过 DB.SetMergeRateLimit 修改
+	MergeRateLimit int64
+
+	// MaxDiskBytes 数据目录允许占用的最大磁盘空间（包括 merge 目录和 PutStream 的临时文件），为 0 时不做限制
+	// 配置之后 merge 完成时立即加载 merge 的结果，不需要重新打开数据库就可以释放磁盘空间
+	MaxDiskBytes int64
+
+	// DiskSoftWatermark 超过 MaxDiskBytes 的该比例后，写入会被限速并触发 merge，为 0 时使用默认值（不超过 DiskHardWatermark）
+	DiskSoftWatermark float32
+
+	// DiskHardWatermark 超过 MaxDiskBytes 的该比例后，写入直接返回 ErrDiskQuotaExceeded，为 0 时使用默认值
+	DiskHardWatermark float32
+
+	// PreallocateDataFile 创建新的活跃文件时按照 DataFileSize 预分配磁盘空间
+	PreallocateDataFile bool
+
+	// RecycleDataFiles merge 后不删除失效的数据文件，而是留作后续轮转时复用
+	RecycleDataFiles bool
+
+	// DataFileMaxAge 活跃文件创建超过该时长后进行轮转，为 0 时不按时间轮转
+	DataFileMaxAge time.Duration
+
+	// DataFileMaxRecords 活跃文件写入的记录数达到该值后进行轮转，为 0 时不按记录数轮转
+	DataFileMaxRecords uint
+
+	// ValueCacheSize 热点数据缓存的最大字节数，为 0 时不开启缓存
+	ValueCacheSize int64
+
+	// ValueCacheShards 热点数据缓存的分片数量，分片越多锁竞争越小，为 0 时使用默认值
+	ValueCacheShards int
+
+	// BlockCacheSize 数据文件块缓存的最大字节数，为 0 时不开启块缓存
+	BlockCacheSize int64
+
+	// ReadAheadSize 开启块缓存后，检测到顺序读取时每次预读的字节数
+	ReadAheadSize int64
+
+	// Checksum 新数据文件中记录使用的校验算法，已有的数据文件沿用文件头部中记录的算法
+	Checksum data.ChecksumType
+
+	// StrictFingerprint 为 true 时，数据文件头部的配置指纹（DataFileSize、IndexType、Checksum）和当前配置不一致时
+	// Open 返回 ErrOptionsMismatch；为 false 时仍然可以打开，不一致的文件通过 Stat 的 MismatchedFiles 报告
+	StrictFingerprint bool
+
+	// RecordTimestamp 在记录头部中保存写入时间，可以通过 GetWithMeta 和 Iterator.Meta 获取
+	RecordTimestamp bool
+
+	// IndexLoadParallelism 启动时并行解码数据文件重建索引的并发数，为 0 时使用 CPU 核数
+	IndexLoadParallelism int
+
+	// OnIndexLoadProgress 启动时每加载完一个数据文件的索引回调一次，为 nil 时不报告进度
+	OnIndexLoadProgress func(progress IndexLoadProgress)
+
+	// IndexSnapshot 关闭时保存内存索引的快照，启动时加载快照后只重放之后写入的记录，对 B+ 树索引无效
+	IndexSnapshot bool
+
+	// IndexSnapshotInterval 开启 IndexSnapshot 后定期保存快照的间隔，为 0 时只在关闭时保存
+	IndexSnapshotInterval time.Duration
+}
+
+// IndexLoadProgress 启动时重建索引的进度
+type IndexLoadProgress struct {
+	LoadedFiles int
+	TotalFiles  int
+	LoadedBytes int64
+	TotalBytes  int64
 }
 
 type IteratorOptions struct {
 	Prefix  []byte
 	Reverse bool
+
+	// Prefetch 大于 0 时每次从索引中取出 Prefetch 个位置，第一次读取其中的 value 时批量读取
+	Prefetch int
+
+	// Unordered 为 true 时，索引支持的话（哈希索引以及分片的索引）按照不确定的顺序遍历，省去排序和多路归并
+	// Reverse 只影响 Seek：之后只返回不小于 key（Reverse 时不大于 key）的数据
+	Unordered bool
 }
 
 type WriteBatchOptions struct {
@@ -33,31 +135,134 @@ type WriteBatchOptions struct {
 }
 
 func checkOptions(options Options) error {
-	if len(options.DirPath) == 0 {
+	if len(options.DirPath) == 0 && !options.InMemory {
 		return errors.New("database dir path is empty")
 	}
+	if options.InMemory && options.VFS != nil {
+		return errors.New("in memory database can not use a custom vfs")
+	}
+	// B+ 树索引直接读写磁盘上的文件
+	if _, ok := options.VFS.(fio.OSFS); options.IndexType == index.BPTree && (options.InMemory || (options.VFS != nil && !ok)) {
+		return errors.New("b+tree index only supports the os file system")
+	}
+	switch options.IndexType {
+	case index.Btree, index.Art, index.BPTree, index.Hash, index.Skiplist, index.Compact:
+	default:
+		return errors.New("unsupported index type")
+	}
+	if options.IndexShards < 0 {
+		return errors.New("index shards must not be negative")
+	}
 	if options.DataFileSize <= 0 {
 		return errors.New("database data file size must be greater than 0")
 	}
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("invalid merge ratio, must between 0 and 1")
 	}
+	if options.MergeRateLimit < 0 {
+		return errors.New("merge rate limit must not be negative")
+	}
+	if options.DataFileMaxAge < 0 {
+		return errors.New("data file max age must not be negative")
+	}
+	if options.ValueCacheSize < 0 {
+		return errors.New("value cache size must not be negative")
+	}
+	if options.ValueCacheShards < 0 {
+		return errors.New("value cache shards must not be negative")
+	}
+	if options.BlockCacheSize < 0 || options.ReadAheadSize < 0 {
+		return errors.New("block cache size and read ahead size must not be negative")
+	}
+	if !data.IsValidChecksumType(options.Checksum) {
+		return errors.New("unsupported checksum type")
+	}
+	switch options.FileIOType {
+	case fio.StandardFIO, fio.MemoryMap, fio.DirectIO, fio.IOUring:
+	default:
+		return errors.New("unsupported file io type")
+	}
+	if options.IndexSnapshotInterval < 0 {
+		return errors.New("index snapshot interval must not be negative")
+	}
+	if options.IndexLoadParallelism < 0 {
+		return errors.New("index load parallelism must not be negative")
+	}
+	if options.MaxDiskBytes < 0 {
+		return errors.New("max disk bytes must not be negative")
+	}
+	if options.MaxDiskBytes > 0 {
+		if options.DiskSoftWatermark <= 0 || options.DiskSoftWatermark > options.DiskHardWatermark || options.DiskHardWatermark > 1 {
+			return errors.New("invalid disk watermark, must satisfy 0 < soft <= hard <= 1")
+		}
+	}
 	return nil
 }
 
+// fillDefaultOptions 将没有设置（为 0）的配置项替换为默认值，兼容不是从 DefaultOptions 修改得到的配置
+func fillDefaultOptions(options Options) Options {
+	if options.IndexLoadParallelism == 0 {
+		options.IndexLoadParallelism = runtime.NumCPU()
+	}
+	if options.ValueCacheShards == 0 {
+		options.ValueCacheShards = DefaultOptions.ValueCacheShards
+	}
+	if options.MaxDiskBytes > 0 {
+		if options.DiskHardWatermark == 0 {
+			options.DiskHardWatermark = DefaultOptions.DiskHardWatermark
+		}
+		if options.DiskSoftWatermark == 0 {
+			options.DiskSoftWatermark = DefaultOptions.DiskSoftWatermark
+			if options.DiskSoftWatermark > options.DiskHardWatermark {
+				options.DiskSoftWatermark = options.DiskHardWatermark
+			}
+		}
+	}
+	return options
+}
+
+// optionsFingerprint 计算写入数据文件头部的配置指纹
+func optionsFingerprint(options Options) uint64 {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%d/%d/%d", options.DataFileSize, options.IndexType, options.Checksum)
+	return h.Sum64()
+}
+
 var DefaultOptions = Options{
-	DirPath:            path.Join("../tem"),
-	DataFileSize:       256 * 1024 * 1024, // 256MB
-	SyncWrites:         false,
-	IndexType:          index.Btree,
-	BytesPerSync:       0,
-	MMapAtStartup:      false,
-	DataFileMergeRatio: 0.5,
+	DirPath:               path.Join("../tem"),
+	DataFileSize:          256 * 1024 * 1024, // 256MB
+	SyncWrites:            false,
+	IndexType:             index.Btree,
+	IndexShards:           1,
+	BytesPerSync:          0,
+	MMapAtStartup:         false,
+	FileIOType:            fio.StandardFIO,
+	IOManagerFactory:      nil,
+	DataFileMergeRatio:    0.5,
+	MaxDiskBytes:          0,
+	DiskSoftWatermark:     0.8,
+	DiskHardWatermark:     0.95,
+	PreallocateDataFile:   false,
+	RecycleDataFiles:      false,
+	DataFileMaxAge:        0,
+	DataFileMaxRecords:    0,
+	ValueCacheSize:        0,
+	ValueCacheShards:      16,
+	BlockCacheSize:        0,
+	ReadAheadSize:         1024 * 1024, // 1MB
+	Checksum:              data.ChecksumCRC32,
+	RecordTimestamp:       false,
+	IndexLoadParallelism:  runtime.NumCPU(),
+	OnIndexLoadProgress:   nil,
+	IndexSnapshot:         false,
+	IndexSnapshotInterval: 0,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
-	Prefix:  nil,
-	Reverse: false,
+	Prefix:    nil,
+	Reverse:   false,
+	Prefetch:  0,
+	Unordered: false,
 }
 
 var DefaultWriteBatchOptions = WriteBatchOptions{
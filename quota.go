@@ -0,0 +1,154 @@
+package bitcask_go
+
+import (
+	"github.com/Tuanzi-bug/TuanKV/fio"
+	"github.com/Tuanzi-bug/TuanKV/utils"
+	"io"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 超过软水位后单次写入的最大等待时间
+	maxDiskThrottleDelay = 10 * time.Millisecond
+
+	// 超过软水位后自动 merge 没有释放空间时，下一次尝试之前的等待时间，每次失败后加倍
+	minQuotaMergeBackoff = time.Second
+	maxQuotaMergeBackoff = time.Minute
+)
+
+// loadDiskUsage 统计数据目录（包括 merge 目录）大小和磁盘剩余空间，之后在写入时增量维护，避免每次写入都遍历目录
+// 启动、merge 结束以及其他释放了磁盘空间的操作之后重新统计
+func (db *DB) loadDiskUsage() error {
+	if db.options.MaxDiskBytes == 0 {
+		return nil
+	}
+	dirSize, err := fio.DirSize(db.vfs, db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	if _, err := db.vfs.Stat(db.getMergePath()); err == nil {
+		mergeSize, err := fio.DirSize(db.vfs, db.getMergePath())
+		if err != nil {
+			return err
+		}
+		dirSize += mergeSize
+	}
+	atomic.StoreInt64(&db.diskSize, dirSize)
+	// 数据目录不在本地磁盘上时只限制 MaxDiskBytes
+	if !db.onLocalDisk() {
+		atomic.StoreInt64(&db.availableDiskSize, math.MaxInt64)
+		return nil
+	}
+	availableSize, err := utils.AvailableDiskSize()
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&db.availableDiskSize, int64(availableSize))
+	return nil
+}
+
+// addDiskUsage 记录新写入（size 为负数时表示释放）的字节数
+func (db *DB) addDiskUsage(size int64) {
+	if db.options.MaxDiskBytes == 0 {
+		return
+	}
+	atomic.AddInt64(&db.diskSize, size)
+	atomic.AddInt64(&db.availableDiskSize, -size)
+}
+
+// checkDiskQuota 判断再写入 size 字节后是否会超过硬水位或者磁盘剩余空间
+func (db *DB) checkDiskQuota(size int64) error {
+	if db.options.MaxDiskBytes == 0 {
+		return nil
+	}
+	_, hardLimit := db.diskWatermarks()
+	if atomic.LoadInt64(&db.diskSize)+size > hardLimit || size > atomic.LoadInt64(&db.availableDiskSize) {
+		return ErrDiskQuotaExceeded
+	}
+	return nil
+}
+
+// throttleWrite 超过软水位后对写入进行限速，越接近硬水位等待越久，同时在后台触发一次 merge
+// 需要在获取 db.mu 之前调用，避免限速时阻塞读请求
+func (db *DB) throttleWrite() {
+	if db.options.MaxDiskBytes == 0 {
+		return
+	}
+	softLimit, hardLimit := db.diskWatermarks()
+	used := atomic.LoadInt64(&db.diskSize)
+	if used < softLimit {
+		return
+	}
+
+	if time.Now().UnixNano() >= atomic.LoadInt64(&db.quotaMergeNext) && atomic.CompareAndSwapInt32(&db.quotaMerging, 0, 1) {
+		db.bgWg.Add(1)
+		go func() {
+			defer db.bgWg.Done()
+			defer atomic.StoreInt32(&db.quotaMerging, 0)
+			db.quotaMerge()
+		}()
+	}
+
+	ratio := 1.0
+	if hardLimit > softLimit {
+		ratio = float64(used-softLimit) / float64(hardLimit-softLimit)
+	}
+	if ratio > 1 {
+		ratio = 1
+	}
+	time.Sleep(time.Duration(ratio * float64(maxDiskThrottleDelay)))
+}
+
+// quotaMerge 超过软水位后在后台执行 merge，失效数据量和上一次尝试时相同时 merge 不能释放空间，直接跳过
+// 没有执行或者 merge 失败时退避，避免每次写入都重新 merge 所有数据
+// 只会在 quotaMerging 保护下运行，quotaMergeReclaim 和 quotaMergeBackoff 不需要加锁
+func (db *DB) quotaMerge() {
+	reclaimSize := atomic.LoadInt64(&db.reclaimSize)
+	if reclaimSize == db.quotaMergeReclaim {
+		db.backoffQuotaMerge()
+		return
+	}
+	if err := db.Merge(); err != nil {
+		db.quotaMergeReclaim = reclaimSize
+		db.backoffQuotaMerge()
+		return
+	}
+	db.quotaMergeReclaim = atomic.LoadInt64(&db.reclaimSize)
+	db.quotaMergeBackoff = 0
+}
+
+func (db *DB) backoffQuotaMerge() {
+	backoff := db.quotaMergeBackoff * 2
+	if backoff < minQuotaMergeBackoff {
+		backoff = minQuotaMergeBackoff
+	}
+	if backoff > maxQuotaMergeBackoff {
+		backoff = maxQuotaMergeBackoff
+	}
+	db.quotaMergeBackoff = backoff
+	atomic.StoreInt64(&db.quotaMergeNext, time.Now().Add(backoff).UnixNano())
+}
+
+// quotaWriter 写入临时文件时同样占用磁盘配额，written 为已经计入配额的字节数
+type quotaWriter struct {
+	db      *DB
+	w       io.Writer
+	written int64
+}
+
+func (qw *quotaWriter) Write(p []byte) (int, error) {
+	if err := qw.db.checkDiskQuota(int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := qw.w.Write(p)
+	qw.db.addDiskUsage(int64(n))
+	qw.written += int64(n)
+	return n, err
+}
+
+func (db *DB) diskWatermarks() (int64, int64) {
+	maxBytes := float64(db.options.MaxDiskBytes)
+	return int64(maxBytes * float64(db.options.DiskSoftWatermark)), int64(maxBytes * float64(db.options.DiskHardWatermark))
+}
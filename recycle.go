@@ -0,0 +1,92 @@
+package bitcask_go
+
+import (
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/fio"
+	"path/filepath"
+	"strings"
+)
+
+// 最多保留的回收文件数量，超过之后的文件直接删除
+const maxRecycledDataFiles = 4
+
+// loadRecycledFiles 加载数据目录中等待复用的回收文件
+func (db *DB) loadRecycledFiles() error {
+	if !db.options.RecycleDataFiles {
+		return nil
+	}
+	dirEntries, err := db.vfs.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix+data.RecycleFileNameSuffix) {
+			db.recycledFiles = append(db.recycledFiles, filepath.Join(db.options.DirPath, entry.Name()))
+		}
+	}
+	return nil
+}
+
+// removeDataFile 删除 merge 之后已经失效的数据文件，开启回收时将文件清空后留作下一次轮转使用
+func (db *DB) removeDataFile(fileId uint32) error {
+	if err := db.removeHintFile(fileId); err != nil {
+		return err
+	}
+	fileName := data.GetDataFileName(db.options.DirPath, fileId)
+	if !db.options.RecycleDataFiles || len(db.recycledFiles) >= maxRecycledDataFiles {
+		return db.vfs.Remove(fileName)
+	}
+	recycleName := data.GetRecycleFileName(db.options.DirPath, fileId)
+	if _, err := db.vfs.Stat(recycleName); err == nil {
+		return db.vfs.Remove(fileName)
+	}
+	// 清空文件内容，保留已经分配的磁盘空间，复用时不需要重新分配
+	if err := db.clearFile(fileName); err != nil {
+		return err
+	}
+	if err := db.vfs.Rename(fileName, recycleName); err != nil {
+		return err
+	}
+	db.recycledFiles = append(db.recycledFiles, recycleName)
+	return nil
+}
+
+// clearFile 清空文件的内容，IOManager 支持时只把数据清零，文件大小和磁盘块保持不变，否则截断文件
+func (db *DB) clearFile(fileName string) error {
+	file, err := db.vfs.OpenFile(fileName, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if writer, ok := file.(fio.OffsetWriter); ok {
+		size, err := file.Size()
+		if err != nil {
+			return err
+		}
+		if err := writer.ZeroRange(0, size); err != nil {
+			return err
+		}
+		return file.Sync()
+	}
+	truncater, ok := file.(fio.Truncater)
+	if !ok {
+		return data.ErrTruncateNotSupported
+	}
+	return truncater.Truncate(0)
+}
+
+// reuseRecycledFile 写入新的文件头部之后将一个回收文件重命名为新的数据文件，避免重新创建文件和分配磁盘空间
+func (db *DB) reuseRecycledFile(fileName string, fileId uint32) error {
+	if len(db.recycledFiles) == 0 {
+		return nil
+	}
+	if _, err := db.vfs.Stat(fileName); err == nil {
+		return nil
+	}
+	recycleName := db.recycledFiles[len(db.recycledFiles)-1]
+	db.recycledFiles = db.recycledFiles[:len(db.recycledFiles)-1]
+	if err := data.PrepareRecycledFile(db.vfs, recycleName, fileId, db.fileOptions()); err != nil {
+		return err
+	}
+	return db.vfs.Rename(recycleName, fileName)
+}
@@ -0,0 +1,63 @@
+package bitcask_go
+
+import (
+	"github.com/Tuanzi-bug/TuanKV/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// 回收的数据文件保留已经分配的磁盘块，复用时不需要重新分配
+func TestDB_RecycledFileKeepsAllocation(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recycle-alloc")
+	opts.DataFileSize = 4 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.PreallocateDataFile = true
+	opts.RecycleDataFiles = true
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 20000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(1024)))
+	}
+	for i := 0; i < 20000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, db2.recycledFiles)
+	for _, name := range db2.recycledFiles {
+		info, err := os.Stat(name)
+		assert.Nil(t, err)
+		stat := info.Sys().(*syscall.Stat_t)
+		assert.GreaterOrEqual(t, stat.Blocks*512, opts.DataFileSize)
+	}
+
+	// 复用之后文件依然保留分配的磁盘块，数据可以正常读取
+	recycled := db2.recycledFiles[len(db2.recycledFiles)-1]
+	values := make([][]byte, 5000)
+	for i := range values {
+		values[i] = utils.RandomValue(1024)
+		assert.Nil(t, db2.Put(utils.GetTestKey(i), values[i]))
+	}
+	_, err = os.Stat(recycled)
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, db2.Close())
+
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, 5000, len(db3.ListKeys()))
+	for i := range values {
+		val, err := db3.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+}
@@ -0,0 +1,180 @@
+package bitcask_go
+
+import (
+	"fmt"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/fio"
+	"github.com/Tuanzi-bug/TuanKV/index"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// PutStream 写入前暂存 value 的临时文件后缀
+const streamFileSuffix = ".stream"
+
+// 生成暂存文件名称时使用的序号，避免并发写入时文件名冲突
+var streamSpoolSeq uint64
+
+// spoolFile 暂存 value 的临时文件，写入之后按照偏移读取
+type spoolFile struct {
+	fio.IOManager
+}
+
+func (f *spoolFile) ReadAt(b []byte, offset int64) (int, error) {
+	return f.Read(b, offset)
+}
+
+// PutStream 写入 value 由 reader 给出的 key，适合写入不能整个放入内存的大对象
+// 记录头部中需要 value 的长度和校验值，因此 value 会先写入数据目录下的临时文件，再分块追加到数据文件中
+func (db *DB) PutStream(key []byte, r io.Reader) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	spoolName := filepath.Join(db.options.DirPath,
+		fmt.Sprintf("%d-%d%s", time.Now().UnixNano(), atomic.AddUint64(&streamSpoolSeq, 1), streamFileSuffix))
+	ioManager, err := db.vfs.OpenFile(spoolName, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	spool := &spoolFile{ioManager}
+	// 临时文件同样计入磁盘配额，删除之后释放
+	spoolWriter := &quotaWriter{db: db, w: spool}
+	defer func() {
+		_ = spool.Close()
+		_ = db.vfs.Remove(spoolName)
+		db.addDiskUsage(-spoolWriter.written)
+	}()
+	valueSize, err := io.Copy(spoolWriter, r)
+	if err != nil {
+		return err
+	}
+
+	logRecord := &data.LogRecord{
+		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Type: data.LogRecordNormal,
+	}
+	if db.options.RecordTimestamp {
+		logRecord.Timestamp = time.Now().UnixNano()
+	}
+	// 在加锁之前计算校验值，避免持锁完整读取一遍 value
+	stream, err := data.EncodeLogRecordStream(logRecord, spool, valueSize, db.options.Checksum)
+	if err != nil {
+		return err
+	}
+
+	db.throttleWrite()
+	unlock := db.lockKeys([][]byte{key})
+	defer unlock()
+	_, err = db.appendAndApply(func() ([]index.BatchOp, error) {
+		pos, err := db.appendLogRecordStream(logRecord, spool, valueSize, stream)
+		if err != nil {
+			return nil, err
+		}
+		return []index.BatchOp{{Key: key, Pos: pos}}, nil
+	})
+	return err
+}
+
+// GetReader 返回读取 key 对应 value 的 reader，value 按需分块读取，读到末尾时完成校验
+// 返回的 reader 需要在关闭数据库之前关闭
+func (db *DB) GetReader(key []byte) (io.ReadCloser, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	db.filesLock.RLock()
+	defer db.filesLock.RUnlock()
+	logRecordPos := db.indexGet(key)
+	if logRecordPos == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	dataFile, err := db.getDataFile(logRecordPos.Fid)
+	if err != nil {
+		return nil, err
+	}
+	_, valueReader, _, err := dataFile.ReadLogRecord(logRecordPos.Offset, -1)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(valueReader), nil
+}
+
+// appendLogRecordStream 将编码后的流式记录追加到活跃文件中，调用方需要持有 db.mu
+// stream 使用配置中的校验算法编码
+func (db *DB) appendLogRecordStream(logRecord *data.LogRecord, value io.ReaderAt, valueSize int64,
+	stream *data.LogRecordStream) (*data.LogRecordPos, error) {
+	if db.activeFile == nil {
+		if err := db.setActiveDataFile(); err != nil {
+			return nil, err
+		}
+	}
+	size := stream.Size()
+	if logRecord.Type == data.LogRecordNormal {
+		if err := db.checkDiskQuota(size); err != nil {
+			return nil, err
+		}
+	}
+	if db.needRotate(size) {
+		if err := db.rotateActiveFile(); err != nil {
+			return nil, err
+		}
+	}
+	// 活跃文件使用了其他校验算法，或者是不支持时间戳的旧文件时重新编码
+	reencode := db.activeFile.ChecksumType() != db.options.Checksum
+	if logRecord.Timestamp != 0 && !db.activeFile.SupportsTimestamp() {
+		record := *logRecord
+		record.Timestamp = 0
+		logRecord = &record
+		reencode = true
+	}
+	if reencode {
+		var err error
+		stream, err = data.EncodeLogRecordStream(logRecord, value, valueSize, db.activeFile.ChecksumType())
+		if err != nil {
+			return nil, err
+		}
+		size = stream.Size()
+	}
+
+	writeOff := db.activeFile.WriteOff
+	if _, err := db.activeFile.WriteStream(stream.Reader()); err != nil {
+		return nil, err
+	}
+	if err := db.afterAppend(size); err != nil {
+		return nil, err
+	}
+	return &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: uint64(size)}, nil
+}
+
+// removeStreamFiles 删除上次异常退出时遗留的 PutStream 临时文件
+func (db *DB) removeStreamFiles() error {
+	entries, err := db.vfs.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), streamFileSuffix) {
+			if err := db.vfs.Remove(filepath.Join(db.options.DirPath, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// appendValueReader 将另一个数据文件中的大 value 分块复制为一条新的记录，先校验原记录，避免损坏的数据被重新计算校验值
+func (db *DB) appendValueReader(logRecord *data.LogRecord, valueReader *data.ValueReader) (*data.LogRecordPos, error) {
+	if err := valueReader.Verify(); err != nil {
+		return nil, err
+	}
+	stream, err := data.EncodeLogRecordStream(logRecord, valueReader, valueReader.Size(), db.options.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.appendLogRecordStream(logRecord, valueReader, valueReader.Size(), stream)
+}
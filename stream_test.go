@@ -0,0 +1,107 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/utils"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_PutStream(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream")
+	opts.DirPath = dir
+	opts.DataFileSize = 2 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 超过数据文件大小阈值的大 value
+	largeValue := utils.RandomValue(3 * data.StreamValueThreshold)
+	err = db.PutStream(utils.GetTestKey(1), bytes.NewReader(largeValue))
+	assert.Nil(t, err)
+	err = db.PutStream(utils.GetTestKey(2), bytes.NewReader([]byte("small value")))
+	assert.Nil(t, err)
+	err = db.PutStream(nil, bytes.NewReader(largeValue))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+	// 被覆盖的大 value 在 merge 时清理
+	err = db.PutStream(utils.GetTestKey(3), bytes.NewReader(largeValue))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(128))
+	assert.Nil(t, err)
+
+	readValue := func(db *DB, key []byte) []byte {
+		r, err := db.GetReader(key)
+		assert.Nil(t, err)
+		defer r.Close()
+		value, err := io.ReadAll(r)
+		assert.Nil(t, err)
+		return value
+	}
+	assert.Equal(t, largeValue, readValue(db, utils.GetTestKey(1)))
+	assert.Equal(t, []byte("small value"), readValue(db, utils.GetTestKey(2)))
+	value, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, largeValue, value)
+	_, err = db.GetReader(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 临时文件在写入之后删除
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"+streamFileSuffix))
+	assert.Equal(t, 0, len(matches))
+
+	// merge 之后重启，大 value 分块复制并重建索引
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Equal(t, largeValue, readValue(db2, utils.GetTestKey(1)))
+	assert.Equal(t, []byte("small value"), readValue(db2, utils.GetTestKey(2)))
+	assert.Equal(t, 3, len(db2.ListKeys()))
+}
+
+func TestDB_PutStreamDuringMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream-merge")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	// 配置了磁盘配额时 merge 结束之后重新加载数据文件
+	opts.MaxDiskBytes = 1 << 40
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	// value 写入临时文件的过程中完成 merge，临时文件不会被删除
+	largeValue := utils.RandomValue(3 * data.StreamValueThreshold)
+	r, w := io.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- db.PutStream(utils.GetTestKey(5000), r)
+	}()
+	_, err = w.Write(largeValue[:len(largeValue)/2])
+	assert.Nil(t, err)
+	assert.Nil(t, db.Merge())
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"+streamFileSuffix))
+	assert.Equal(t, 1, len(matches))
+	_, err = w.Write(largeValue[len(largeValue)/2:])
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	assert.Nil(t, <-errCh)
+
+	value, err := db.Get(utils.GetTestKey(5000))
+	assert.Nil(t, err)
+	assert.Equal(t, largeValue, value)
+}
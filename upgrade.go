@@ -0,0 +1,60 @@
+package bitcask_go
+
+import (
+	"errors"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/index"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// upgradeDataFiles 将旧版本格式的数据文件转换为当前版本
+// 转换后记录的偏移发生了变化，merge 生成的 hint 文件不再有效，需要先删除 merge 完成标识和 hint 文件，
+// 之后启动时会从数据文件中重新构建索引，因此任意一步崩溃之后重新启动都能继续完成转换
+func (db *DB) upgradeDataFiles() error {
+	dirEntries, err := db.vfs.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	var legacyFileIds []uint32
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.Split(entry.Name(), ".")[0])
+		if err != nil {
+			return ErrDataDirectoryCorrupted
+		}
+		version, err := data.DataFileVersion(db.vfs, db.options.DirPath, uint32(fileId))
+		if err != nil {
+			return err
+		}
+		if version > data.CurrentFileVersion {
+			return data.ErrUnsupportedFileVersion
+		}
+		if version == data.LegacyFileVersion {
+			legacyFileIds = append(legacyFileIds, uint32(fileId))
+		}
+	}
+	if len(legacyFileIds) == 0 {
+		return nil
+	}
+	// B+ 树索引持久化了记录的位置，无法跟随数据文件一起转换
+	if db.options.IndexType == index.BPTree {
+		return ErrUpgradeNotSupported
+	}
+
+	for _, name := range []string{data.MergeFinishedFileName, data.HintFileName, data.IndexSnapshotFileName} {
+		if err := db.vfs.Remove(filepath.Join(db.options.DirPath, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	for _, fileId := range legacyFileIds {
+		if err := data.UpgradeDataFile(db.vfs, db.options.DirPath, fileId, db.fingerprint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package bitcask_go
+
+import (
+	"errors"
+	"github.com/Tuanzi-bug/TuanKV/data"
+	"github.com/Tuanzi-bug/TuanKV/fio"
+	"github.com/Tuanzi-bug/TuanKV/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// writeLegacyDataFile 按照没有文件头部的旧版本格式写入数据文件，返回每条记录的位置
+func writeLegacyDataFile(t *testing.T, dir string, fileId uint32, records []*data.LogRecord) []*data.LogRecordPos {
+	var buf []byte
+	var positions []*data.LogRecordPos
+	for _, record := range records {
+		enc, size := data.EncodeLogRecord(record)
+		positions = append(positions, &data.LogRecordPos{Fid: fileId, Offset: int64(len(buf)), Size: uint64(size)})
+		buf = append(buf, enc...)
+	}
+	err := os.WriteFile(data.GetDataFileName(dir, fileId), buf, fio.DataFilePerm)
+	assert.Nil(t, err)
+	return positions
+}
+
+func TestDB_UpgradeLegacyDataFiles(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-upgrade")
+	opts := DefaultOptions
+	opts.DirPath = dir
+
+	// 旧版本中 merge 之后的文件
+	var mergedRecords []*data.LogRecord
+	for i := 0; i < 100; i++ {
+		mergedRecords = append(mergedRecords, &data.LogRecord{
+			Key:   logRecordKeyWithSeq(utils.GetTestKey(i), nonTransactionSeqNo),
+			Value: []byte("merged-" + strconv.Itoa(i)),
+		})
+	}
+	positions := writeLegacyDataFile(t, dir, 0, mergedRecords)
+	hintFile, err := data.OpenHintFile(fio.OSFS{}, dir)
+	assert.Nil(t, err)
+	for i, pos := range positions {
+		err = hintFile.WriteHintRecord(utils.GetTestKey(i), pos)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, hintFile.Close())
+	finRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte(mergeFinishKey), Value: []byte("1")})
+	err = os.WriteFile(filepath.Join(dir, data.MergeFinishedFileName), finRecord, fio.DataFilePerm)
+	assert.Nil(t, err)
+
+	// 旧版本中 merge 之后继续写入的文件
+	writeLegacyDataFile(t, dir, 1, []*data.LogRecord{
+		{Key: logRecordKeyWithSeq(utils.GetTestKey(1), nonTransactionSeqNo), Value: []byte("new-1")},
+		{Key: logRecordKeyWithSeq(utils.GetTestKey(2), nonTransactionSeqNo), Type: data.LogRecordDeleted},
+	})
+
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	assert.Equal(t, 99, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("merged-0"), val)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-1"), val)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	for _, fid := range []uint32{0, 1} {
+		version, err := data.DataFileVersion(fio.OSFS{}, dir, fid)
+		assert.Nil(t, err)
+		assert.Equal(t, data.CurrentFileVersion, version)
+	}
+
+	// 转换之后继续写入并重启
+	err = db.Put(utils.GetTestKey(200), []byte("after-upgrade"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db2.ListKeys()))
+	val, err = db2.Get(utils.GetTestKey(200))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after-upgrade"), val)
+}
+
+// 使用不同的配置重新打开数据目录时检查数据文件头部的配置指纹
+func TestDB_OptionsFingerprint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-fingerprint")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Empty(t, db.Stat().MismatchedFiles)
+	assert.Nil(t, db.Close())
+
+	// 配置相同时严格检查也可以打开
+	opts.StrictFingerprint = true
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	opts.DataFileSize = 128 * 1024
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrOptionsMismatch))
+
+	// 不做严格检查时仍然可以打开，不一致的文件在 Stat 中报告
+	opts.StrictFingerprint = false
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, db.Stat().MismatchedFiles)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+}
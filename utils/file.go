@@ -2,14 +2,17 @@ package utils
 
 import (
 	"errors"
+	"github.com/Tuanzi-bug/TuanKV/fio"
 	"github.com/shirou/gopsutil/v3/disk"
+	"io"
 	"io/fs"
-	"os"
 	"path/filepath"
-	"strings"
 	"syscall"
 )
 
+// CopyDir 复制文件时每次读写的字节数
+const copyBufferSize = 1024 * 1024
+
 func DirSize(dirPath string) (int64, error) {
 	var size int64
 	err := filepath.Walk(dirPath, func(path string, info fs.FileInfo, err error) error {
@@ -33,37 +36,79 @@ func AvailableDiskSize() (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
-	return info.Used, err
+	return info.Free, err
 }
 
-func CopyDir(src, dest string, exclude []string) error {
-	if _, err := os.Stat(dest); errors.Is(err, fs.ErrNotExist) {
-		if err := os.MkdirAll(dest, os.ModePerm); err != nil {
-			return err
-		}
+// CopyDir 将 srcFS 中的 src 目录复制到 destFS 中的 dest 目录，跳过名称匹配 exclude 中任意模式的文件和目录
+// limiter 不为 nil 时按照其限速读取文件
+func CopyDir(srcFS fio.VFS, src string, destFS fio.VFS, dest string, exclude []string, limiter *RateLimiter) error {
+	if err := destFS.MkdirAll(dest); err != nil {
+		return err
 	}
-
-	return filepath.Walk(src, func(path string, info fs.FileInfo, err error) error {
-		fileName := strings.Replace(path, src, "", 1)
-		if fileName == "" {
-			return nil
-		}
+	entries, err := srcFS.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		excluded := false
 		for _, e := range exclude {
-			matched, err := filepath.Match(e, info.Name())
+			matched, err := filepath.Match(e, entry.Name())
 			if err != nil {
 				return err
 			}
 			if matched {
-				return nil
+				excluded = true
+				break
 			}
 		}
-		if info.IsDir() {
-			return os.Mkdir(filepath.Join(dest, fileName), info.Mode())
+		if excluded {
+			continue
+		}
+		srcPath, destPath := filepath.Join(src, entry.Name()), filepath.Join(dest, entry.Name())
+		if entry.IsDir() {
+			err = CopyDir(srcFS, srcPath, destFS, destPath, exclude, limiter)
+		} else {
+			err = CopyFile(srcFS, srcPath, destFS, destPath, limiter)
 		}
-		data, err := os.ReadFile(filepath.Join(src, fileName))
 		if err != nil {
 			return err
 		}
-		return os.WriteFile(filepath.Join(dest, fileName), data, info.Mode())
-	})
+	}
+	return nil
+}
+
+// CopyFile 分块复制文件，不会把整个文件读入内存，limiter 限制每秒读写的字节数
+func CopyFile(srcFS fio.VFS, src string, destFS fio.VFS, dest string, limiter *RateLimiter) error {
+	srcFile, err := srcFS.OpenFile(src, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	if err := destFS.Remove(dest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	destFile, err := destFS.OpenFile(dest, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	buf := make([]byte, copyBufferSize)
+	for offset := int64(0); ; {
+		n, err := srcFile.Read(buf, offset)
+		if n > 0 {
+			limiter.Wait(int64(n))
+			if _, err := destFile.Write(buf[:n]); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return destFile.Sync()
 }
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"github.com/Tuanzi-bug/TuanKV/fio"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -18,3 +20,26 @@ func TestAvailableDiskSize(t *testing.T) {
 	assert.Nil(t, err)
 	assert.True(t, size > 0)
 }
+
+func TestCopyDir(t *testing.T) {
+	memFS := newTestMemFS(t)
+	dest, _ := os.MkdirTemp("", "bitcask-go-copy")
+	defer os.RemoveAll(dest)
+
+	err := CopyDir(memFS, "/src", fio.OSFS{}, dest, []string{"*.tmp"}, nil)
+	assert.Nil(t, err)
+	data, err := os.ReadFile(filepath.Join(dest, "sub", "a.data"))
+	assert.Nil(t, err)
+	assert.Equal(t, "value", string(data))
+	_, err = os.Stat(filepath.Join(dest, "b.tmp"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func newTestMemFS(t *testing.T) *fio.MemFS {
+	memFS := fio.NewMemFS()
+	assert.Nil(t, memFS.MkdirAll("/src/sub"))
+	for name, value := range map[string]string{"/src/sub/a.data": "value", "/src/b.tmp": "tmp"} {
+		assert.Nil(t, fio.WriteFile(memFS, name, []byte(value)))
+	}
+	return memFS
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter 按照每秒字节数限制读写速度的令牌桶，桶的容量为一秒的流量
+// 一次请求的字节数可以超过桶的容量，超出的部分由之后的请求等待偿还
+type RateLimiter struct {
+	lock   *sync.Mutex
+	rate   int64 // 每秒字节数，小于等于 0 时不限速
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	return &RateLimiter{
+		lock:   new(sync.Mutex),
+		rate:   bytesPerSec,
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// SetRate 修改限速，正在等待的请求不受影响
+func (l *RateLimiter) SetRate(bytesPerSec int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.refill(time.Now())
+	l.rate = bytesPerSec
+	if l.tokens > float64(bytesPerSec) {
+		l.tokens = float64(bytesPerSec)
+	}
+}
+
+// Rate 返回当前的限速
+func (l *RateLimiter) Rate() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.rate
+}
+
+// Wait 消耗 n 个字节的令牌，令牌不足时等待，l 为 nil 时不限速
+func (l *RateLimiter) Wait(n int64) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	if l.rate <= 0 {
+		l.lock.Unlock()
+		return
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.lock.Unlock()
+	time.Sleep(delay)
+}
+
+// refill 按照经过的时间补充令牌，调用方需要持有 l.lock
+func (l *RateLimiter) refill(now time.Time) {
+	if l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+		if l.tokens > float64(l.rate) {
+			l.tokens = float64(l.rate)
+		}
+	}
+	l.last = now
+}
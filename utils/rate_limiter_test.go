@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(10000)
+	start := time.Now()
+	// 桶中初始有一秒的令牌
+	limiter.Wait(10000)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	limiter.Wait(2000)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	// 不限速时不等待
+	limiter.SetRate(0)
+	assert.Equal(t, int64(0), limiter.Rate())
+	start = time.Now()
+	limiter.Wait(1 << 30)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	var nilLimiter *RateLimiter
+	nilLimiter.Wait(1 << 30)
+}
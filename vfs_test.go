@@ -0,0 +1,70 @@
+package bitcask_go
+
+import (
+	"github.com/Tuanzi-bug/TuanKV/fio"
+	"github.com/Tuanzi-bug/TuanKV/index"
+	"github.com/Tuanzi-bug/TuanKV/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_VFS(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-vfs")
+	defer os.RemoveAll(dir)
+	vfs := fio.NewMemFS()
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(dir, "db")
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.RecycleDataFiles = true
+	opts.VFS = vfs
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	// 同一个文件系统中的目录只能被一个实例使用
+	_, err = Open(opts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	expected := make(map[string][]byte)
+	putValues(t, db, expected, 0, 2000)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		delete(expected, string(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	backupDir := filepath.Join(dir, "backup")
+	assert.Nil(t, db.Backup(backupDir))
+	assert.Nil(t, db.Close())
+
+	check := func(opts Options) {
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, len(expected), len(db.ListKeys()))
+		for key, value := range expected {
+			v, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, v)
+		}
+		assert.Nil(t, db.Close())
+	}
+	// 重新打开时应用 merge 的结果，备份同样保存在该文件系统中
+	check(opts)
+	opts.DirPath = backupDir
+	check(opts)
+
+	// 所有的文件操作都经过 VFS，磁盘上没有创建任何文件
+	_, err = os.Stat(filepath.Join(dir, "db"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(backupDir)
+	assert.True(t, os.IsNotExist(err))
+
+	opts.IndexType = index.BPTree
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+	opts.IndexType = DefaultOptions.IndexType
+	opts.InMemory = true
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}